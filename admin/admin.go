@@ -0,0 +1,230 @@
+// Package admin exposes the administration of a running node over HTTP.
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tamararankovic/hyparview/config"
+	"github.com/tamararankovic/hyparview/data"
+	"github.com/tamararankovic/hyparview/hyparview"
+)
+
+type handler struct {
+	hv    *hyparview.HyParView
+	token string
+}
+
+// HandlerOption adds optional endpoints to the admin API.
+type HandlerOption func(mux *http.ServeMux, h *handler)
+
+// RequireToken rejects the requests not carrying the bearer token,
+// the health endpoints stay open to the probes.
+func RequireToken(token string) HandlerOption {
+	return func(mux *http.ServeMux, h *handler) {
+		h.token = token
+	}
+}
+
+// NewHandler returns the admin API of the node along with the health endpoints.
+//
+//	GET  /config                 returns the config values that can be changed at runtime
+//	PUT  /config                 applies the values in the JSON body, e.g. {"fanout": 5}
+//	GET  /views                  returns the active and the passive view
+//	GET  /peers?tag=zone=eu-1    returns the active peers whose metadata holds all the tags
+//	PUT  /metadata               replaces the metadata of the node with the JSON body, e.g. {"zone": "eu-1"},
+//	                             the reserved keys such as admin_address keep their value
+//	GET  /stats                  returns the view sizes, the protocol event counters and the bans
+//	POST /peers/{id}/disconnect  disconnects the active peer
+//	PUT  /bans/{id}?duration=1h  bans the node, for the default ban duration if none is given
+//	DELETE /bans/{id}            lifts the ban of the node
+//	POST /shuffle                starts a shuffle
+//	POST /leave                  makes the node leave the overlay
+func NewHandler(hv *hyparview.HyParView, opts ...HandlerOption) http.Handler {
+	h := handler{hv: hv}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /config", h.getConfig)
+	mux.HandleFunc("PUT /config", h.updateConfig)
+	mux.HandleFunc("GET /views", h.getViews)
+	mux.HandleFunc("GET /peers", h.getPeers)
+	mux.HandleFunc("PUT /metadata", h.setMetadata)
+	mux.HandleFunc("GET /stats", h.getStats)
+	mux.HandleFunc("POST /peers/{id}/disconnect", h.disconnect)
+	mux.HandleFunc("PUT /bans/{id}", h.ban)
+	mux.HandleFunc("DELETE /bans/{id}", h.unban)
+	mux.HandleFunc("POST /shuffle", h.shuffle)
+	mux.HandleFunc("POST /leave", h.leave)
+	for _, opt := range opts {
+		opt(mux, &h)
+	}
+	if h.token == "" {
+		RegisterHealth(mux, hv)
+		return mux
+	}
+	authorized := http.NewServeMux()
+	RegisterHealth(authorized, hv)
+	authorized.Handle("/", h.authorize(mux))
+	return authorized
+}
+
+// authorize passes on the requests carrying the bearer token of the handler.
+func (h handler) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "missing or invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h handler) getConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, config.Values(h.hv.Config()))
+}
+
+func (h handler) updateConfig(w http.ResponseWriter, r *http.Request) {
+	values := make(map[string]any)
+	if err := json.NewDecoder(r.Body).Decode(&values); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	updated, err := config.Apply(h.hv.Config(), values)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.hv.UpdateConfig(updated); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, config.Values(h.hv.Config()))
+}
+
+func (h handler) getViews(w http.ResponseWriter, r *http.Request) {
+	depths := h.hv.QueueDepths()
+	views := Views{
+		Self:    toNode(h.hv.Self()),
+		Active:  make([]ActivePeer, 0),
+		Passive: make([]Node, 0),
+	}
+	for _, peer := range h.hv.GetPeers() {
+		views.Active = append(views.Active, ActivePeer{
+			Node:       toNode(peer.Node()),
+			QueueDepth: depths[peer.Node().ID],
+		})
+	}
+	for _, peer := range h.hv.GetPassivePeers() {
+		views.Passive = append(views.Passive, toNode(peer.Node()))
+	}
+	writeJSON(w, views)
+}
+
+func (h handler) getPeers(w http.ResponseWriter, r *http.Request) {
+	tags := make(map[string]string)
+	for _, tag := range r.URL.Query()["tag"] {
+		key, value, ok := strings.Cut(tag, "=")
+		if !ok {
+			http.Error(w, fmt.Sprintf("tag %s not a KEY=VALUE pair", tag), http.StatusBadRequest)
+			return
+		}
+		tags[key] = value
+	}
+	nodes := make([]Node, 0)
+	for _, peer := range h.hv.GetPeersWhere(hyparview.MatchTags(tags)) {
+		nodes = append(nodes, toNode(peer.Node()))
+	}
+	writeJSON(w, nodes)
+}
+
+func (h handler) setMetadata(w http.ResponseWriter, r *http.Request) {
+	metadata := make(map[string]string)
+	if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	current := h.hv.Self().Metadata
+	for _, key := range reservedKeys {
+		if value, ok := current[key]; ok {
+			metadata[key] = value
+		} else {
+			delete(metadata, key)
+		}
+	}
+	if err := h.hv.SetMetadata(metadata); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, toNode(h.hv.Self()))
+}
+
+func (h handler) getStats(w http.ResponseWriter, r *http.Request) {
+	config := h.hv.Config()
+	writeJSON(w, Stats{
+		Self:                toNode(h.hv.Self()),
+		ActiveViewSize:      len(h.hv.GetPeers()),
+		ActiveViewCapacity:  config.ActiveViewSize(),
+		PassiveViewSize:     len(h.hv.GetPassivePeers()),
+		PassiveViewCapacity: config.PassiveViewSize,
+		Counters:            h.hv.Metrics(),
+		Bans:                h.hv.Bans(),
+	})
+}
+
+func (h handler) disconnect(w http.ResponseWriter, r *http.Request) {
+	if err := h.hv.DisconnectPeer(r.PathValue("id")); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h handler) ban(w http.ResponseWriter, r *http.Request) {
+	duration := hyparview.DefaultReputationConfig().BanDuration
+	if value := r.URL.Query().Get("duration"); value != "" {
+		var err error
+		duration, err = time.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			http.Error(w, fmt.Sprintf("invalid ban duration %s", value), http.StatusBadRequest)
+			return
+		}
+	}
+	h.hv.Ban(r.PathValue("id"), duration)
+	writeJSON(w, h.hv.Bans())
+}
+
+func (h handler) unban(w http.ResponseWriter, r *http.Request) {
+	h.hv.Unban(r.PathValue("id"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h handler) shuffle(w http.ResponseWriter, r *http.Request) {
+	if err := h.hv.Shuffle(); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h handler) leave(w http.ResponseWriter, r *http.Request) {
+	// the response is sent before the conns are closed
+	w.WriteHeader(http.StatusAccepted)
+	go h.hv.Leave()
+}
+
+func toNode(node data.Node) Node {
+	return Node{ID: node.ID, ListenAddress: node.ListenAddress, Metadata: node.Metadata}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,35 @@
+package admin
+
+import (
+	"maps"
+	"net"
+)
+
+// AddressKey is the metadata key the nodes advertise the address of their admin API under,
+// the crawl of hvctl follows it from node to node.
+const AddressKey = "admin_address"
+
+// reservedKeys are the metadata keys set by the node itself, PUT /metadata does not change them.
+var reservedKeys = []string{AddressKey}
+
+// Advertise returns the metadata along with the address of the admin API served on adminAddress.
+// An unspecified admin host is replaced by the host of the listen address, so the others can reach it.
+// Only the admin APIs requiring a token should be advertised, anyone reading the metadata can reach them.
+func Advertise(metadata map[string]string, adminAddress, listenAddress string) map[string]string {
+	advertised := maps.Clone(metadata)
+	if advertised == nil {
+		advertised = make(map[string]string)
+	}
+	host, port, err := net.SplitHostPort(adminAddress)
+	if err != nil {
+		advertised[AddressKey] = adminAddress
+		return advertised
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		if listenHost, _, err := net.SplitHostPort(listenAddress); err == nil {
+			host = listenHost
+		}
+	}
+	advertised[AddressKey] = net.JoinHostPort(host, port)
+	return advertised
+}
@@ -0,0 +1,33 @@
+package admin
+
+import "time"
+
+// Node is a node as reported by the admin API.
+type Node struct {
+	ID            string            `json:"id"`
+	ListenAddress string            `json:"listen_address"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+}
+
+type ActivePeer struct {
+	Node
+	QueueDepth int `json:"queue_depth"`
+}
+
+type Views struct {
+	Self    Node         `json:"self"`
+	Active  []ActivePeer `json:"active"`
+	Passive []Node       `json:"passive"`
+}
+
+type Stats struct {
+	Self                Node `json:"self"`
+	ActiveViewSize      int  `json:"active_view_size"`
+	ActiveViewCapacity  int  `json:"active_view_capacity"`
+	PassiveViewSize     int  `json:"passive_view_size"`
+	PassiveViewCapacity int  `json:"passive_view_capacity"`
+	// Counters holds the protocol event counters indexed by name and label
+	Counters map[string]map[string]uint64 `json:"counters"`
+	// Bans holds the ban expiry times indexed by node ID
+	Bans map[string]time.Time `json:"bans"`
+}
@@ -0,0 +1,172 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client calls the admin API of a node.
+type Client struct {
+	address string
+	token   string
+	client  *http.Client
+}
+
+// ClientOption configures the admin API client.
+type ClientOption func(c *Client)
+
+// WithToken sends the bearer token with every request.
+func WithToken(token string) ClientOption {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// NewClient returns the client of the admin API served on the address, e.g. 127.0.0.1:8000.
+func NewClient(address string, timeout time.Duration, opts ...ClientOption) *Client {
+	c := &Client{
+		address: address,
+		client:  &http.Client{Timeout: timeout},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *Client) Views() (Views, error) {
+	var views Views
+	err := c.do(http.MethodGet, "/views", nil, &views)
+	return views, err
+}
+
+func (c *Client) Stats() (Stats, error) {
+	var stats Stats
+	err := c.do(http.MethodGet, "/stats", nil, &stats)
+	return stats, err
+}
+
+func (c *Client) Config() (map[string]string, error) {
+	values := make(map[string]string)
+	err := c.do(http.MethodGet, "/config", nil, &values)
+	return values, err
+}
+
+// UpdateConfig applies the values and returns the resulting config.
+func (c *Client) UpdateConfig(values map[string]any) (map[string]string, error) {
+	body, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	updated := make(map[string]string)
+	err = c.do(http.MethodPut, "/config", body, &updated)
+	return updated, err
+}
+
+// PeersWhere returns the active peers whose metadata holds all the tags.
+func (c *Client) PeersWhere(tags map[string]string) ([]Node, error) {
+	query := url.Values{}
+	for key, value := range tags {
+		query.Add("tag", key+"="+value)
+	}
+	var nodes []Node
+	err := c.do(http.MethodGet, "/peers?"+query.Encode(), nil, &nodes)
+	return nodes, err
+}
+
+// SetMetadata replaces the metadata of the node and returns the resulting node info.
+func (c *Client) SetMetadata(metadata map[string]string) (Node, error) {
+	body, err := json.Marshal(metadata)
+	if err != nil {
+		return Node{}, err
+	}
+	var self Node
+	err = c.do(http.MethodPut, "/metadata", body, &self)
+	return self, err
+}
+
+func (c *Client) Faults() ([]FaultRule, error) {
+	var rules []FaultRule
+	err := c.do(http.MethodGet, "/faults", nil, &rules)
+	return rules, err
+}
+
+// SetFaults replaces the fault rules and returns the resulting ones.
+func (c *Client) SetFaults(rules []FaultRule) ([]FaultRule, error) {
+	body, err := json.Marshal(rules)
+	if err != nil {
+		return nil, err
+	}
+	var updated []FaultRule
+	err = c.do(http.MethodPut, "/faults", body, &updated)
+	return updated, err
+}
+
+func (c *Client) ClearFaults() error {
+	return c.do(http.MethodDelete, "/faults", nil, nil)
+}
+
+func (c *Client) Disconnect(nodeID string) error {
+	return c.do(http.MethodPost, "/peers/"+url.PathEscape(nodeID)+"/disconnect", nil, nil)
+}
+
+// Ban bans the node for the duration, or the default ban duration if it is zero,
+// and returns the ban expiry times of the banned nodes.
+func (c *Client) Ban(nodeID string, duration time.Duration) (map[string]time.Time, error) {
+	path := "/bans/" + url.PathEscape(nodeID)
+	if duration > 0 {
+		path += "?duration=" + url.QueryEscape(duration.String())
+	}
+	bans := make(map[string]time.Time)
+	err := c.do(http.MethodPut, path, nil, &bans)
+	return bans, err
+}
+
+func (c *Client) Unban(nodeID string) error {
+	return c.do(http.MethodDelete, "/bans/"+url.PathEscape(nodeID), nil, nil)
+}
+
+func (c *Client) Shuffle() error {
+	return c.do(http.MethodPost, "/shuffle", nil, nil)
+}
+
+func (c *Client) Leave() error {
+	return c.do(http.MethodPost, "/leave", nil, nil)
+}
+
+// do sends the request and decodes the JSON response into out if it is not nil.
+func (c *Client) do(method, path string, body []byte, out any) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, "http://"+c.address+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
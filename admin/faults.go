@@ -0,0 +1,122 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/tamararankovic/hyparview/data"
+	"github.com/tamararankovic/hyparview/transport"
+)
+
+// FaultRule is a transport.FaultRule as reported by the admin API,
+// the msg types are given by name and the durations as strings, e.g. 100ms.
+type FaultRule struct {
+	Peers         []string `json:"peers,omitempty"`
+	MsgTypes      []string `json:"msg_types,omitempty"`
+	Incoming      bool     `json:"incoming,omitempty"`
+	Drop          float64  `json:"drop,omitempty"`
+	Duplicate     float64  `json:"duplicate,omitempty"`
+	Corrupt       float64  `json:"corrupt,omitempty"`
+	Delay         string   `json:"delay,omitempty"`
+	Jitter        string   `json:"jitter,omitempty"`
+	HalfClose     bool     `json:"half_close,omitempty"`
+	RefuseConnect bool     `json:"refuse_connect,omitempty"`
+}
+
+// WithFaultInjector serves the rules of the injector:
+//
+//	GET    /faults  returns the fault rules
+//	PUT    /faults  replaces the rules with the JSON array in the body
+//	DELETE /faults  removes all the rules
+func WithFaultInjector(injector *transport.FaultInjector) HandlerOption {
+	return func(mux *http.ServeMux, h *handler) {
+		mux.HandleFunc("GET /faults", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, fromFaultRules(injector.Rules()))
+		})
+		mux.HandleFunc("PUT /faults", func(w http.ResponseWriter, r *http.Request) {
+			var rules []FaultRule
+			if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			faultRules, err := toFaultRules(rules)
+			if err == nil {
+				err = injector.SetRules(faultRules...)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, fromFaultRules(injector.Rules()))
+		})
+		mux.HandleFunc("DELETE /faults", func(w http.ResponseWriter, r *http.Request) {
+			_ = injector.SetRules()
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+func toFaultRules(rules []FaultRule) ([]transport.FaultRule, error) {
+	faultRules := make([]transport.FaultRule, len(rules))
+	for i, rule := range rules {
+		faultRule := transport.FaultRule{
+			Peers:         rule.Peers,
+			Incoming:      rule.Incoming,
+			Drop:          rule.Drop,
+			Duplicate:     rule.Duplicate,
+			Corrupt:       rule.Corrupt,
+			HalfClose:     rule.HalfClose,
+			RefuseConnect: rule.RefuseConnect,
+		}
+		for _, name := range rule.MsgTypes {
+			msgType, err := data.ParseMessageType(name)
+			if err != nil {
+				return nil, err
+			}
+			faultRule.MsgTypes = append(faultRule.MsgTypes, msgType)
+		}
+		var err error
+		if faultRule.Delay, err = parseDuration(rule.Delay); err != nil {
+			return nil, err
+		}
+		if faultRule.Jitter, err = parseDuration(rule.Jitter); err != nil {
+			return nil, err
+		}
+		faultRules[i] = faultRule
+	}
+	return faultRules, nil
+}
+
+func fromFaultRules(faultRules []transport.FaultRule) []FaultRule {
+	rules := make([]FaultRule, len(faultRules))
+	for i, faultRule := range faultRules {
+		rule := FaultRule{
+			Peers:         faultRule.Peers,
+			Incoming:      faultRule.Incoming,
+			Drop:          faultRule.Drop,
+			Duplicate:     faultRule.Duplicate,
+			Corrupt:       faultRule.Corrupt,
+			HalfClose:     faultRule.HalfClose,
+			RefuseConnect: faultRule.RefuseConnect,
+		}
+		for _, msgType := range faultRule.MsgTypes {
+			rule.MsgTypes = append(rule.MsgTypes, msgType.String())
+		}
+		if faultRule.Delay > 0 {
+			rule.Delay = faultRule.Delay.String()
+		}
+		if faultRule.Jitter > 0 {
+			rule.Jitter = faultRule.Jitter.String()
+		}
+		rules[i] = rule
+	}
+	return rules
+}
+
+func parseDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(value)
+}
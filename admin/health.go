@@ -0,0 +1,25 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/tamararankovic/hyparview/hyparview"
+)
+
+// RegisterHealth adds the health endpoints to the mux.
+//
+//	GET /healthz  succeeds while the process is up
+//	GET /readyz   succeeds once the node has at least one active peer
+func RegisterHealth(mux *http.ServeMux, hv *hyparview.HyParView) {
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		if len(hv.GetPeers()) == 0 {
+			http.Error(w, "no active peers", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
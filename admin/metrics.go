@@ -0,0 +1,51 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/tamararankovic/hyparview/hyparview"
+)
+
+const metricsPrefix = "hyparview_"
+
+// NewMetricsHandler returns the node metrics in the Prometheus text format
+// along with the health endpoints.
+//
+//	GET /metrics  the protocol event counters, view sizes and send queue depths
+func NewMetricsHandler(hv *hyparview.HyParView) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w, hv)
+	})
+	RegisterHealth(mux, hv)
+	return mux
+}
+
+func writeMetrics(w http.ResponseWriter, hv *hyparview.HyParView) {
+	fmt.Fprintf(w, "# TYPE %sactive_view_size gauge\n%sactive_view_size %d\n", metricsPrefix, metricsPrefix, len(hv.GetPeers()))
+	fmt.Fprintf(w, "# TYPE %spassive_view_size gauge\n%spassive_view_size %d\n", metricsPrefix, metricsPrefix, len(hv.GetPassivePeers()))
+	fmt.Fprintf(w, "# TYPE %ssend_queue_depth gauge\n", metricsPrefix)
+	depths := hv.QueueDepths()
+	for _, nodeID := range sortedKeys(depths) {
+		fmt.Fprintf(w, "%ssend_queue_depth{peer=%q} %d\n", metricsPrefix, nodeID, depths[nodeID])
+	}
+	counters := hv.Metrics()
+	for _, name := range sortedKeys(counters) {
+		fmt.Fprintf(w, "# TYPE %s%s counter\n", metricsPrefix, name)
+		for _, label := range sortedKeys(counters[name]) {
+			fmt.Fprintf(w, "%s%s{label=%q} %d\n", metricsPrefix, name, label, counters[name][label])
+		}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
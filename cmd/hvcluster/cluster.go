@@ -0,0 +1,346 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tamararankovic/hyparview/admin"
+	"github.com/tamararankovic/hyparview/data"
+	"github.com/tamararankovic/hyparview/testing/invariants"
+	"github.com/tamararankovic/hyparview/transport"
+)
+
+type options struct {
+	size      int
+	mode      string
+	transport string
+	basePort  int
+	adminPort int
+	nodeBin   string
+	dir       string
+	joinDelay time.Duration
+	// zones spreads the nodes round robin over that many zone metadata values, 0 for none
+	zones int
+	// nodeArgs are passed to every node as hyparview-node flags
+	nodeArgs []string
+}
+
+func (o *options) validate() error {
+	if o.size < 1 {
+		return fmt.Errorf("number of nodes must be at least 1, got %d", o.size)
+	}
+	if o.zones < 0 {
+		return fmt.Errorf("number of zones must not be negative, got %d", o.zones)
+	}
+	switch o.mode {
+	case "inproc":
+	case "proc":
+		if o.transport == "mem" {
+			return errors.New("the mem transport only connects the nodes of the inproc mode")
+		}
+		path, err := exec.LookPath(o.nodeBin)
+		if err != nil {
+			return fmt.Errorf("%w, build it with go build -o hyparview-node ./cmd/hyparview-node", err)
+		}
+		o.nodeBin = path
+	default:
+		return fmt.Errorf("unknown mode %s", o.mode)
+	}
+	switch o.transport {
+	case "mem", "tcp", "unix":
+	default:
+		return fmt.Errorf("unknown transport %s", o.transport)
+	}
+	return nil
+}
+
+// instance is a running or stopped node of the cluster.
+type instance interface {
+	// start starts the node, it joins through the contact address unless it is empty
+	start(contactAddress string) error
+	// kill stops the node without telling its peers
+	kill()
+	// stop makes the node leave the overlay
+	stop()
+	running() bool
+}
+
+type member struct {
+	index         int
+	id            string
+	listenAddress string
+	adminAddress  string
+	instance      instance
+}
+
+// args returns the hyparview-node flags of the member.
+func (m *member) args(o options) []string {
+	args := []string{
+		"-node-id", m.id,
+		"-listen-address", m.listenAddress,
+		"-admin-address", m.adminAddress,
+		"-expected-cluster-size", strconv.Itoa(o.size),
+	}
+	if o.transport != "mem" {
+		args = append(args, "-transport", o.transport)
+	}
+	if o.zones > 0 {
+		args = append(args, "-metadata", "zone="+m.zone(o))
+	}
+	return append(args, o.nodeArgs...)
+}
+
+// zone returns the zone of the member, empty if the nodes are not spread over zones.
+func (m *member) zone(o options) string {
+	if o.zones == 0 {
+		return ""
+	}
+	return fmt.Sprintf("z%d", m.index%o.zones)
+}
+
+type cluster struct {
+	opts    options
+	out     io.Writer
+	members []*member
+	// adminToken is the bearer token the admin APIs of the nodes require
+	adminToken string
+	// network connects the nodes when the transport is mem
+	network  *transport.MemNetwork
+	stopOnce sync.Once
+}
+
+func newCluster(opts options, out io.Writer) *cluster {
+	c := &cluster{
+		opts:       opts,
+		out:        out,
+		members:    make([]*member, opts.size),
+		adminToken: newAdminToken(),
+	}
+	if opts.transport == "mem" {
+		c.network = transport.NewMemNetwork(transport.DefaultConnConfig())
+	}
+	for i := range c.members {
+		m := &member{
+			index:        i,
+			id:           fmt.Sprintf("node-%d", i),
+			adminAddress: fmt.Sprintf("127.0.0.1:%d", opts.adminPort+i),
+		}
+		switch opts.transport {
+		case "mem":
+			m.listenAddress = m.id
+		case "tcp":
+			m.listenAddress = fmt.Sprintf("127.0.0.1:%d", opts.basePort+i)
+		case "unix":
+			m.listenAddress = filepath.Join(opts.dir, m.id+".sock")
+		}
+		if opts.mode == "inproc" {
+			m.instance = &inProcessNode{cluster: c, member: m}
+		} else {
+			m.instance = &childNode{cluster: c, member: m}
+		}
+		c.members[i] = m
+	}
+	return c
+}
+
+// newAdminToken returns a random token shared by the admin APIs of the cluster.
+func newAdminToken() string {
+	token := make([]byte, 16)
+	_, _ = crand.Read(token)
+	return hex.EncodeToString(token)
+}
+
+// client returns the client of the admin API of the member.
+func (c *cluster) client(m *member) *admin.Client {
+	return admin.NewClient(m.adminAddress, 2*time.Second, admin.WithToken(c.adminToken))
+}
+
+func (c *cluster) startAll() error {
+	for i := range c.members {
+		if err := c.start(i); err != nil {
+			return err
+		}
+		time.Sleep(c.opts.joinDelay)
+	}
+	return nil
+}
+
+// start starts the member, it joins through a random running member.
+func (c *cluster) start(index int) error {
+	m, err := c.member(index)
+	if err != nil {
+		return err
+	}
+	if m.instance.running() {
+		return fmt.Errorf("%s already running", m.id)
+	}
+	contacts := make([]string, 0)
+	for _, other := range c.members {
+		if other != m && other.instance.running() {
+			contacts = append(contacts, other.listenAddress)
+		}
+	}
+	contact := ""
+	if len(contacts) > 0 {
+		contact = contacts[rand.Intn(len(contacts))]
+	}
+	if err := m.instance.start(contact); err != nil {
+		return fmt.Errorf("%s: %w", m.id, err)
+	}
+	if contact == "" {
+		fmt.Fprintf(c.out, "%s started on %s, admin API on %s\n", m.id, m.listenAddress, m.adminAddress)
+	} else {
+		fmt.Fprintf(c.out, "%s started on %s, admin API on %s, joined through %s\n", m.id, m.listenAddress, m.adminAddress, contact)
+	}
+	return nil
+}
+
+func (c *cluster) kill(index int) error {
+	m, err := c.runningMember(index)
+	if err != nil {
+		return err
+	}
+	c.crash(m)
+	fmt.Fprintf(c.out, "%s killed\n", m.id)
+	return nil
+}
+
+// crash kills the member and gives its peers time to notice the closed conns,
+// until then they refuse the conns of the restarted member as duplicates.
+func (c *cluster) crash(m *member) {
+	m.instance.kill()
+	time.Sleep(c.opts.joinDelay)
+}
+
+func (c *cluster) stop(index int) error {
+	m, err := c.runningMember(index)
+	if err != nil {
+		return err
+	}
+	m.instance.stop()
+	fmt.Fprintf(c.out, "%s left\n", m.id)
+	return nil
+}
+
+func (c *cluster) restart(index int) error {
+	m, err := c.member(index)
+	if err != nil {
+		return err
+	}
+	if m.instance.running() {
+		c.crash(m)
+	}
+	return c.start(index)
+}
+
+// partition splits the members into the groups of indices,
+// the members not listed form a group of their own.
+func (c *cluster) partition(groups [][]int) error {
+	if c.network == nil {
+		return errors.New("partitions need the mem transport")
+	}
+	addresses := make([][]string, len(groups))
+	for i, group := range groups {
+		for _, index := range group {
+			m, err := c.member(index)
+			if err != nil {
+				return err
+			}
+			addresses[i] = append(addresses[i], m.listenAddress)
+		}
+	}
+	c.network.Partition(addresses...)
+	fmt.Fprintf(c.out, "partitioned into %v\n", groups)
+	return nil
+}
+
+func (c *cluster) heal() error {
+	if c.network == nil {
+		return errors.New("partitions need the mem transport")
+	}
+	c.network.Heal()
+	fmt.Fprintln(c.out, "partitions healed")
+	return nil
+}
+
+func (c *cluster) views(index int) (admin.Views, error) {
+	m, err := c.runningMember(index)
+	if err != nil {
+		return admin.Views{}, err
+	}
+	return c.client(m).Views()
+}
+
+// snapshots returns the views of the running members.
+func (c *cluster) snapshots() ([]invariants.Snapshot, error) {
+	snapshots := make([]invariants.Snapshot, 0, len(c.members))
+	for _, m := range c.members {
+		if !m.instance.running() {
+			continue
+		}
+		client := c.client(m)
+		views, err := client.Views()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", m.id, err)
+		}
+		stats, err := client.Stats()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", m.id, err)
+		}
+		snapshot := invariants.Snapshot{
+			Self:            toNode(views.Self),
+			ActiveViewSize:  stats.ActiveViewCapacity,
+			PassiveViewSize: stats.PassiveViewCapacity,
+		}
+		for _, peer := range views.Active {
+			snapshot.Active = append(snapshot.Active, toNode(peer.Node))
+		}
+		for _, node := range views.Passive {
+			snapshot.Passive = append(snapshot.Passive, toNode(node))
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
+
+func toNode(node admin.Node) data.Node {
+	return data.Node{ID: node.ID, ListenAddress: node.ListenAddress, Metadata: node.Metadata}
+}
+
+// shutdown makes the running members leave the overlay.
+func (c *cluster) shutdown() {
+	c.stopOnce.Do(func() {
+		for _, m := range c.members {
+			if m.instance.running() {
+				m.instance.stop()
+			}
+		}
+	})
+}
+
+func (c *cluster) member(index int) (*member, error) {
+	if index < 0 || index >= len(c.members) {
+		return nil, fmt.Errorf("no node %d, the nodes are 0-%d", index, len(c.members)-1)
+	}
+	return c.members[index], nil
+}
+
+func (c *cluster) runningMember(index int) (*member, error) {
+	m, err := c.member(index)
+	if err != nil {
+		return nil, err
+	}
+	if !m.instance.running() {
+		return nil, fmt.Errorf("%s not running", m.id)
+	}
+	return m, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/tamararankovic/hyparview/admin"
+	"github.com/tamararankovic/hyparview/config"
+	"github.com/tamararankovic/hyparview/data"
+	"github.com/tamararankovic/hyparview/hyparview"
+	"github.com/tamararankovic/hyparview/transport"
+)
+
+// inProcessNode runs the node in the cluster process.
+type inProcessNode struct {
+	cluster *cluster
+	member  *member
+	hv      *hyparview.HyParView
+	server  *http.Server
+}
+
+func (n *inProcessNode) start(contactAddress string) error {
+	cfg, err := config.Load(n.member.args(n.cluster.opts))
+	if err != nil {
+		return err
+	}
+	cfg.AdminToken = n.cluster.adminToken
+	self := data.Node{ID: cfg.NodeID, ListenAddress: cfg.ListenAddress, Metadata: admin.Advertise(cfg.Metadata, cfg.AdminAddress, cfg.ListenAddress)}
+	connConfig := transport.DefaultConnConfig()
+	var connManager *transport.ConnManager
+	switch n.cluster.opts.transport {
+	case "mem":
+		connManager = transport.NewConnManager(self, n.cluster.network.NewConnFn(self.ListenAddress), n.cluster.network.AcceptConnsFn(self.ListenAddress))
+	case "tcp":
+		connManager = transport.NewConnManager(self, transport.NewTCPConnFn(connConfig), transport.AcceptTcpConnsFn(self.ListenAddress, connConfig))
+	case "unix":
+		connManager = transport.NewConnManager(self, transport.NewUnixConnFn(connConfig), transport.AcceptUnixConnsFn(self.ListenAddress, connConfig))
+	}
+	hv, err := hyparview.NewHyParView(cfg.HyParViewConfig, self, connManager)
+	if err != nil {
+		if hv != nil {
+			hv.Stop()
+		}
+		return err
+	}
+	listener, err := net.Listen("tcp", cfg.AdminAddress)
+	if err != nil {
+		hv.Stop()
+		return err
+	}
+	n.hv = hv
+	n.server = &http.Server{Handler: admin.NewHandler(hv, admin.RequireToken(cfg.AdminToken))}
+	go func() {
+		if err := n.server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			log.Println(err)
+		}
+	}()
+	if contactAddress != "" {
+		if err := hv.Join(contactAddress); err != nil {
+			n.kill()
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *inProcessNode) kill() {
+	n.hv.Stop()
+	n.close()
+}
+
+func (n *inProcessNode) stop() {
+	n.hv.Leave()
+	n.close()
+}
+
+func (n *inProcessNode) running() bool {
+	if n.hv == nil {
+		return false
+	}
+	select {
+	case <-n.hv.Left():
+		return false
+	default:
+		return true
+	}
+}
+
+func (n *inProcessNode) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := n.server.Shutdown(ctx); err != nil {
+		log.Println(err)
+	}
+}
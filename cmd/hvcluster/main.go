@@ -0,0 +1,134 @@
+// Command hvcluster starts a local HyParView cluster for development and demos.
+//
+// The nodes run in this process (-mode inproc) or as hyparview-node child
+// processes (-mode proc) and talk over the in-memory (-transport mem, inproc only),
+// loopback TCP or Unix socket transport. Every node but the first joins
+// through a random running node. The flags following -- are passed
+// to every node the way hyparview-node takes them, e.g. -- -fanout 3.
+// With -zones the nodes get a zone metadata value round robin, e.g.
+// -zones 3 -- -locality-key zone -remote-peers 1 runs the locality policy
+// and check then reports the links crossing the zones.
+//
+// The cluster is driven by commands read from the scenario file (-scenario)
+// or typed in interactively, run help for the list. Every node serves its admin
+// API on 127.0.0.1 at its index plus -admin-port, so hvctl can be pointed at it
+// with the admin token printed on start.
+//
+// Exit codes:
+//
+//	0  the cluster shut down
+//	1  a scenario command failed
+//	2  the flags are invalid
+//	3  the cluster failed to start
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const (
+	exitOK = iota
+	exitFailure
+	exitUsage
+	exitStartFailed
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("hvcluster", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	opts := options{}
+	fs.IntVar(&opts.size, "n", 5, "number of nodes")
+	fs.StringVar(&opts.mode, "mode", "inproc", "run the nodes in this process (inproc) or as child processes (proc)")
+	fs.StringVar(&opts.transport, "transport", "mem", "transport between the nodes, mem, tcp or unix")
+	fs.IntVar(&opts.basePort, "port", 7000, "listen port of the first node when the transport is tcp")
+	fs.IntVar(&opts.adminPort, "admin-port", 8000, "admin API port of the first node")
+	fs.StringVar(&opts.nodeBin, "node-bin", "hyparview-node", "hyparview-node binary started in the proc mode")
+	fs.StringVar(&opts.dir, "dir", "", "directory for the logs and the Unix sockets, a temporary one by default")
+	fs.DurationVar(&opts.joinDelay, "join-delay", 200*time.Millisecond, "pause between two node starts")
+	fs.IntVar(&opts.zones, "zones", 0, "number of zones the nodes are spread over by their zone metadata, 0 for none")
+	scenario := fs.String("scenario", "", "file with the commands to run instead of reading them from the input")
+	verbose := fs.Bool("v", false, "write the node logs of the inproc mode to the output instead of the log file")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	opts.nodeArgs = fs.Args()
+	if err := opts.validate(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	if opts.dir == "" {
+		dir, err := os.MkdirTemp("", "hvcluster-")
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitStartFailed
+		}
+		opts.dir = dir
+	}
+	if err := os.MkdirAll(opts.dir, 0755); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitStartFailed
+	}
+	logPath := filepath.Join(opts.dir, "cluster.log")
+	if !*verbose {
+		logFile, err := os.Create(logPath)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitStartFailed
+		}
+		defer logFile.Close()
+		log.SetOutput(logFile)
+	}
+	fmt.Fprintf(stdout, "logs and sockets in %s\n", opts.dir)
+
+	c := newCluster(opts, stdout)
+	fmt.Fprintf(stdout, "admin API token %s\n", c.adminToken)
+	defer c.shutdown()
+	if err := c.startAll(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitStartFailed
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		fmt.Fprintln(stdout, "shutting down")
+		c.shutdown()
+		os.Exit(exitOK)
+	}()
+
+	if *scenario != "" {
+		file, err := os.Open(*scenario)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitUsage
+		}
+		defer file.Close()
+		if err := runScript(c, bufio.NewScanner(file), nil, stdout); err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", *scenario, err)
+			return exitFailure
+		}
+		return exitOK
+	}
+	// interactive errors are reported and the session goes on
+	_ = runScript(c, bufio.NewScanner(stdin), stderr, stdout)
+	return exitOK
+}
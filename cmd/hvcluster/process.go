@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const stopTimeout = 5 * time.Second
+
+// childNode runs the node as a hyparview-node child process
+// logging to a file of its own in the cluster directory.
+type childNode struct {
+	cluster *cluster
+	member  *member
+	cmd     *exec.Cmd
+	// exited is closed once the process exits
+	exited chan struct{}
+}
+
+func (n *childNode) start(contactAddress string) error {
+	args := n.member.args(n.cluster.opts)
+	if contactAddress != "" {
+		args = append(args, "-contact-node-address", contactAddress)
+	}
+	logFile, err := os.OpenFile(filepath.Join(n.cluster.opts.dir, n.member.id+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	n.cmd = exec.Command(n.cluster.opts.nodeBin, args...)
+	// the token is not passed as a flag, the flags of a process are visible to all the users
+	n.cmd.Env = append(os.Environ(), "HYPARVIEW_ADMIN_TOKEN="+n.cluster.adminToken)
+	n.cmd.Stdout = logFile
+	n.cmd.Stderr = logFile
+	if err := n.cmd.Start(); err != nil {
+		logFile.Close()
+		return err
+	}
+	n.exited = make(chan struct{})
+	go func(cmd *exec.Cmd, exited chan struct{}) {
+		err := cmd.Wait()
+		logFile.Close()
+		close(exited)
+		if err != nil {
+			log.Printf("%s exited: %v\n", n.member.id, err)
+		}
+	}(n.cmd, n.exited)
+	return n.awaitAdminAPI()
+}
+
+// awaitAdminAPI waits for the node to serve its admin API.
+func (n *childNode) awaitAdminAPI() error {
+	deadline := time.Now().Add(stopTimeout)
+	for time.Now().Before(deadline) {
+		if !n.running() {
+			return fmt.Errorf("exited with %s, see %s.log", n.cmd.ProcessState, n.member.id)
+		}
+		if _, err := n.cluster.views(n.member.index); err == nil {
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return fmt.Errorf("admin API on %s not served in %s", n.member.adminAddress, stopTimeout)
+}
+
+func (n *childNode) kill() {
+	if err := n.cmd.Process.Kill(); err != nil {
+		log.Println(err)
+	}
+	<-n.exited
+}
+
+// stop sends SIGTERM so the node leaves the overlay, the node is killed
+// if it does not exit in time.
+func (n *childNode) stop() {
+	if err := n.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		log.Println(err)
+	}
+	select {
+	case <-n.exited:
+	case <-time.After(stopTimeout):
+		n.kill()
+	}
+}
+
+func (n *childNode) running() bool {
+	if n.exited == nil {
+		return false
+	}
+	select {
+	case <-n.exited:
+		return false
+	default:
+		return true
+	}
+}
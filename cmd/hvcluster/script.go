@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tamararankovic/hyparview/testing/invariants"
+)
+
+var errQuit = errors.New("quit")
+
+type scriptCommand struct {
+	usage string
+	args  int
+	run   func(c *cluster, args []string, out io.Writer) error
+}
+
+var scriptCommands = map[string]scriptCommand{
+	"status":    {"status", 0, status},
+	"views":     {"views <node>", 1, withIndex(printViews)},
+	"start":     {"start <node>", 1, withIndex(func(c *cluster, index int, out io.Writer) error { return c.start(index) })},
+	"restart":   {"restart <node>", 1, withIndex(func(c *cluster, index int, out io.Writer) error { return c.restart(index) })},
+	"kill":      {"kill <node>", 1, withIndex(func(c *cluster, index int, out io.Writer) error { return c.kill(index) })},
+	"stop":      {"stop <node>", 1, withIndex(func(c *cluster, index int, out io.Writer) error { return c.stop(index) })},
+	"partition": {"partition <node,node,...> <node,node,...> ...", -1, partition},
+	"check":     {"check", 0, check},
+	"heal":      {"heal", 0, func(c *cluster, args []string, out io.Writer) error { return c.heal() }},
+	"sleep":     {"sleep <duration>", 1, sleep},
+	"quit":      {"quit", 0, func(c *cluster, args []string, out io.Writer) error { return errQuit }},
+}
+
+// runScript runs the commands one per line, the empty lines and the ones
+// starting with # are skipped. The first failing command ends the script
+// unless errOut is set, then the error is written to it and the script goes on.
+func runScript(c *cluster, lines *bufio.Scanner, errOut io.Writer, out io.Writer) error {
+	interactive := errOut != nil
+	for lineNumber := 1; ; lineNumber++ {
+		if interactive {
+			fmt.Fprint(out, "> ")
+		}
+		if !lines.Scan() {
+			return lines.Err()
+		}
+		fields := strings.Fields(lines.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		err := runCommand(c, fields, out)
+		if errors.Is(err, errQuit) {
+			return nil
+		}
+		if err == nil {
+			continue
+		}
+		if !interactive {
+			return fmt.Errorf("line %d: %s: %w", lineNumber, fields[0], err)
+		}
+		fmt.Fprintln(errOut, err)
+	}
+}
+
+func runCommand(c *cluster, fields []string, out io.Writer) error {
+	cmd, ok := scriptCommands[fields[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %s, run help for the list", fields[0])
+	}
+	args := fields[1:]
+	if cmd.args >= 0 && len(args) != cmd.args {
+		return fmt.Errorf("usage: %s", cmd.usage)
+	}
+	return cmd.run(c, args, out)
+}
+
+func withIndex(run func(c *cluster, index int, out io.Writer) error) func(c *cluster, args []string, out io.Writer) error {
+	return func(c *cluster, args []string, out io.Writer) error {
+		index, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("node %s not an index", args[0])
+		}
+		return run(c, index, out)
+	}
+}
+
+func status(c *cluster, args []string, out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tID\tSTATE\tACTIVE\tPASSIVE")
+	for _, m := range c.members {
+		if !m.instance.running() {
+			fmt.Fprintf(w, "%d\t%s\tdown\t-\t-\n", m.index, m.id)
+			continue
+		}
+		views, err := c.views(m.index)
+		if err != nil {
+			fmt.Fprintf(w, "%d\t%s\tunresponsive\t-\t-\n", m.index, m.id)
+			continue
+		}
+		active := make([]string, 0, len(views.Active))
+		for _, peer := range views.Active {
+			active = append(active, peer.ID)
+		}
+		sort.Strings(active)
+		fmt.Fprintf(w, "%d\t%s\tup\t%s\t%d\n", m.index, m.id, strings.Join(active, ","), len(views.Passive))
+	}
+	return w.Flush()
+}
+
+// check verifies the protocol invariants over the running nodes.
+func check(c *cluster, args []string, out io.Writer) error {
+	snapshots, err := c.snapshots()
+	if err != nil {
+		return err
+	}
+	if err := invariants.Check(snapshots); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "invariants hold for %d nodes\n", len(snapshots))
+	if c.opts.zones > 0 {
+		printZoneMix(snapshots, out)
+	}
+	return nil
+}
+
+// printZoneMix reports the share of the active view links crossing the zones
+// and the nodes without a peer in another zone.
+func printZoneMix(snapshots []invariants.Snapshot, out io.Writer) {
+	links, crossing := 0, 0
+	var isolated []string
+	for _, snapshot := range snapshots {
+		remote := 0
+		for _, node := range snapshot.Active {
+			if node.Metadata["zone"] != snapshot.Self.Metadata["zone"] {
+				remote++
+			}
+		}
+		links += len(snapshot.Active)
+		crossing += remote
+		if remote == 0 {
+			isolated = append(isolated, snapshot.Self.ID)
+		}
+	}
+	fmt.Fprintf(out, "%d of %d active view links cross the zones\n", crossing, links)
+	if len(isolated) > 0 {
+		sort.Strings(isolated)
+		fmt.Fprintf(out, "no peer in another zone: %s\n", strings.Join(isolated, ","))
+	}
+}
+
+func printViews(c *cluster, index int, out io.Writer) error {
+	views, err := c.views(index)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VIEW\tID\tADDRESS")
+	for _, peer := range views.Active {
+		fmt.Fprintf(w, "active\t%s\t%s\n", peer.ID, peer.ListenAddress)
+	}
+	for _, node := range views.Passive {
+		fmt.Fprintf(w, "passive\t%s\t%s\n", node.ID, node.ListenAddress)
+	}
+	return w.Flush()
+}
+
+func partition(c *cluster, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("usage: partition <node,node,...> <node,node,...> ...")
+	}
+	groups := make([][]int, len(args))
+	for i, arg := range args {
+		for _, field := range strings.Split(arg, ",") {
+			index, err := strconv.Atoi(field)
+			if err != nil {
+				return fmt.Errorf("node %s not an index", field)
+			}
+			groups[i] = append(groups[i], index)
+		}
+	}
+	return c.partition(groups)
+}
+
+func sleep(c *cluster, args []string, out io.Writer) error {
+	duration, err := time.ParseDuration(args[0])
+	if err != nil {
+		return err
+	}
+	time.Sleep(duration)
+	return nil
+}
+
+func init() {
+	// help lists the commands so it can not be part of their initialization
+	scriptCommands["help"] = scriptCommand{"help", 0, help}
+}
+
+func help(c *cluster, args []string, out io.Writer) error {
+	names := make([]string, 0, len(scriptCommands))
+	for name := range scriptCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintln(out, scriptCommands[name].usage)
+	}
+	return nil
+}
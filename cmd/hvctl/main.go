@@ -0,0 +1,506 @@
+// Command hvctl operates running HyParView nodes through their admin API.
+//
+// Usage:
+//
+//	hvctl [flags] views <addr>
+//	hvctl [flags] stats <addr>
+//	hvctl [flags] crawl <addr>
+//	hvctl [flags] config <addr> [name=value ...]
+//	hvctl [flags] peers <addr> [key=value ...]
+//	hvctl [flags] metadata <addr> [key=value ...]
+//	hvctl [flags] disconnect <addr> <peer>
+//	hvctl [flags] ban <addr> <node> [duration]
+//	hvctl [flags] unban <addr> <node>
+//	hvctl [flags] shuffle <addr>
+//	hvctl [flags] leave <addr>
+//	hvctl [flags] faults <addr> [rules.json|clear]
+//
+// The addr is the address of the node admin API. The crawl follows the views
+// of every reached node to the admin API addresses the peers advertise in their
+// metadata, for the peers advertising none it is expected on their listen host
+// at the listen port plus -admin-port-offset if set. The peers command lists the active
+// peers whose metadata holds all the given tags, the metadata command replaces
+// the metadata of the node with the given pairs. The faults command needs a node
+// started with -fault-injection=true, the rules file holds a JSON array of rules.
+// The admin APIs requiring a token are called with -token, or $HVCTL_TOKEN if it is not set.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tamararankovic/hyparview/admin"
+)
+
+const (
+	exitOK = iota
+	exitFailure
+	exitUsage
+)
+
+var errUsage = errors.New("usage")
+
+type command struct {
+	usage string
+	args  int
+	run   func(c *cli, args []string) error
+}
+
+var commands = map[string]command{
+	"views":      {"views <addr>", 1, (*cli).views},
+	"stats":      {"stats <addr>", 1, (*cli).stats},
+	"crawl":      {"crawl <addr>", 1, (*cli).crawl},
+	"config":     {"config <addr> [name=value ...]", -1, (*cli).config},
+	"peers":      {"peers <addr> [key=value ...]", -1, (*cli).peers},
+	"metadata":   {"metadata <addr> [key=value ...]", -1, (*cli).metadata},
+	"disconnect": {"disconnect <addr> <peer>", 2, (*cli).disconnect},
+	"ban":        {"ban <addr> <node> [duration]", -1, (*cli).ban},
+	"unban":      {"unban <addr> <node>", 2, (*cli).unban},
+	"shuffle":    {"shuffle <addr>", 1, (*cli).shuffle},
+	"leave":      {"leave <addr>", 1, (*cli).leave},
+	"faults":     {"faults <addr> [rules.json|clear]", -1, (*cli).faults},
+}
+
+type cli struct {
+	out             io.Writer
+	json            bool
+	timeout         time.Duration
+	token           string
+	adminPortOffset int
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("hvctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	output := fs.String("o", "table", "output format, table or json")
+	timeout := fs.Duration("timeout", 5*time.Second, "timeout of a single admin API request")
+	token := fs.String("token", os.Getenv("HVCTL_TOKEN"), "bearer token of the admin APIs, $HVCTL_TOKEN by default")
+	adminPortOffset := fs.Int("admin-port-offset", 0, "admin port relative to the listen port of the nodes not advertising their admin API, used by crawl, 0 to skip them")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: hvctl [flags] <command> <addr> [args]\n\ncommands:")
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(stderr, "  %s\n", commands[name].usage)
+		}
+		fmt.Fprintln(stderr, "\nflags:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if *output != "table" && *output != "json" {
+		fmt.Fprintf(stderr, "unknown output format %s\n", *output)
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	cmdArgs := fs.Args()[1:]
+	if !ok || (cmd.args >= 0 && len(cmdArgs) != cmd.args) || len(cmdArgs) == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	c := &cli{
+		out:             stdout,
+		json:            *output == "json",
+		timeout:         *timeout,
+		token:           *token,
+		adminPortOffset: *adminPortOffset,
+	}
+	if err := cmd.run(c, cmdArgs); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintf(stderr, "usage: hvctl %s\n", cmd.usage)
+			return exitUsage
+		}
+		fmt.Fprintln(stderr, err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+func (c *cli) client(address string) *admin.Client {
+	return admin.NewClient(address, c.timeout, admin.WithToken(c.token))
+}
+
+func (c *cli) views(args []string) error {
+	views, err := c.client(args[0]).Views()
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.writeJSON(views)
+	}
+	fmt.Fprintf(c.out, "node %s (%s)\n\n", views.Self.ID, views.Self.ListenAddress)
+	w := c.table("VIEW", "ID", "ADDRESS", "QUEUE", "METADATA")
+	for _, peer := range views.Active {
+		fmt.Fprintf(w, "active\t%s\t%s\t%d\t%s\n", peer.ID, peer.ListenAddress, peer.QueueDepth, formatPairs(peer.Metadata))
+	}
+	for _, node := range views.Passive {
+		fmt.Fprintf(w, "passive\t%s\t%s\t-\t%s\n", node.ID, node.ListenAddress, formatPairs(node.Metadata))
+	}
+	return w.Flush()
+}
+
+func (c *cli) stats(args []string) error {
+	stats, err := c.client(args[0]).Stats()
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.writeJSON(stats)
+	}
+	fmt.Fprintf(c.out, "node %s (%s)\n", stats.Self.ID, stats.Self.ListenAddress)
+	fmt.Fprintf(c.out, "active view  %d/%d\n", stats.ActiveViewSize, stats.ActiveViewCapacity)
+	fmt.Fprintf(c.out, "passive view %d/%d\n\n", stats.PassiveViewSize, stats.PassiveViewCapacity)
+	w := c.table("COUNTER", "LABEL", "VALUE")
+	for _, name := range sortedKeys(stats.Counters) {
+		for _, label := range sortedKeys(stats.Counters[name]) {
+			fmt.Fprintf(w, "%s\t%s\t%d\n", name, label, stats.Counters[name][label])
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(stats.Bans) == 0 {
+		return nil
+	}
+	fmt.Fprintln(c.out)
+	w = c.table("BANNED", "UNTIL")
+	for _, nodeID := range sortedKeys(stats.Bans) {
+		fmt.Fprintf(w, "%s\t%s\n", nodeID, stats.Bans[nodeID].Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+type crawlResult struct {
+	Nodes []admin.Views `json:"nodes"`
+	// Unreachable holds the listen addresses of the nodes whose admin API did not respond
+	Unreachable []string `json:"unreachable"`
+}
+
+// crawl visits the nodes breadth first following both views.
+func (c *cli) crawl(args []string) error {
+	result := crawlResult{
+		Nodes:       make([]admin.Views, 0),
+		Unreachable: make([]string, 0),
+	}
+	views, err := c.client(args[0]).Views()
+	if err != nil {
+		return err
+	}
+	visited := map[string]bool{views.Self.ListenAddress: true}
+	queue := []admin.Views{views}
+	for len(queue) > 0 {
+		views := queue[0]
+		queue = queue[1:]
+		result.Nodes = append(result.Nodes, views)
+		neighbors := make([]admin.Node, 0, len(views.Active)+len(views.Passive))
+		for _, peer := range views.Active {
+			neighbors = append(neighbors, peer.Node)
+		}
+		neighbors = append(neighbors, views.Passive...)
+		for _, node := range neighbors {
+			if visited[node.ListenAddress] {
+				continue
+			}
+			visited[node.ListenAddress] = true
+			address, err := c.adminAddress(node)
+			if err == nil {
+				var next admin.Views
+				next, err = c.client(address).Views()
+				if err == nil {
+					queue = append(queue, next)
+					continue
+				}
+			}
+			result.Unreachable = append(result.Unreachable, node.ListenAddress)
+		}
+	}
+	sort.Slice(result.Nodes, func(i, j int) bool {
+		return result.Nodes[i].Self.ID < result.Nodes[j].Self.ID
+	})
+	sort.Strings(result.Unreachable)
+	if c.json {
+		return c.writeJSON(result)
+	}
+	w := c.table("ID", "ADDRESS", "ACTIVE", "PASSIVE")
+	for _, views := range result.Nodes {
+		active := make([]string, 0, len(views.Active))
+		for _, peer := range views.Active {
+			active = append(active, peer.ID)
+		}
+		sort.Strings(active)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", views.Self.ID, views.Self.ListenAddress, strings.Join(active, ","), len(views.Passive))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "\n%d nodes reached, %d unreachable\n", len(result.Nodes), len(result.Unreachable))
+	for _, address := range result.Unreachable {
+		fmt.Fprintf(c.out, "unreachable %s\n", address)
+	}
+	return nil
+}
+
+func (c *cli) config(args []string) error {
+	client := c.client(args[0])
+	var values map[string]string
+	var err error
+	if len(args) == 1 {
+		values, err = client.Config()
+	} else {
+		updates := make(map[string]any)
+		for _, arg := range args[1:] {
+			name, value, ok := strings.Cut(arg, "=")
+			if !ok {
+				return errUsage
+			}
+			updates[name] = value
+		}
+		values, err = client.UpdateConfig(updates)
+	}
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.writeJSON(values)
+	}
+	w := c.table("NAME", "VALUE")
+	for _, name := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s\t%s\n", name, values[name])
+	}
+	return w.Flush()
+}
+
+func (c *cli) peers(args []string) error {
+	tags, err := parsePairs(args[1:])
+	if err != nil {
+		return err
+	}
+	nodes, err := c.client(args[0]).PeersWhere(tags)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.writeJSON(nodes)
+	}
+	w := c.table("ID", "ADDRESS", "METADATA")
+	for _, node := range nodes {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", node.ID, node.ListenAddress, formatPairs(node.Metadata))
+	}
+	return w.Flush()
+}
+
+// metadata replaces the metadata of the node, without pairs it prints the current one.
+func (c *cli) metadata(args []string) error {
+	client := c.client(args[0])
+	var self admin.Node
+	if len(args) == 1 {
+		views, err := client.Views()
+		if err != nil {
+			return err
+		}
+		self = views.Self
+	} else {
+		metadata, err := parsePairs(args[1:])
+		if err != nil {
+			return err
+		}
+		if self, err = client.SetMetadata(metadata); err != nil {
+			return err
+		}
+	}
+	if c.json {
+		return c.writeJSON(self.Metadata)
+	}
+	w := c.table("KEY", "VALUE")
+	for _, key := range sortedKeys(self.Metadata) {
+		fmt.Fprintf(w, "%s\t%s\n", key, self.Metadata[key])
+	}
+	return w.Flush()
+}
+
+func (c *cli) disconnect(args []string) error {
+	return c.client(args[0]).Disconnect(args[1])
+}
+
+// ban bans the node, for the default ban duration of the node if none is given.
+func (c *cli) ban(args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return errUsage
+	}
+	var duration time.Duration
+	if len(args) == 3 {
+		var err error
+		if duration, err = time.ParseDuration(args[2]); err != nil || duration <= 0 {
+			return errUsage
+		}
+	}
+	bans, err := c.client(args[0]).Ban(args[1], duration)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.writeJSON(bans)
+	}
+	w := c.table("BANNED", "UNTIL")
+	for _, nodeID := range sortedKeys(bans) {
+		fmt.Fprintf(w, "%s\t%s\n", nodeID, bans[nodeID].Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+func (c *cli) unban(args []string) error {
+	return c.client(args[0]).Unban(args[1])
+}
+
+func (c *cli) shuffle(args []string) error {
+	return c.client(args[0]).Shuffle()
+}
+
+func (c *cli) leave(args []string) error {
+	return c.client(args[0]).Leave()
+}
+
+func (c *cli) faults(args []string) error {
+	client := c.client(args[0])
+	var rules []admin.FaultRule
+	var err error
+	switch {
+	case len(args) == 1:
+		rules, err = client.Faults()
+	case len(args) > 2:
+		return errUsage
+	case args[1] == "clear":
+		err = client.ClearFaults()
+	default:
+		var file []byte
+		file, err = os.ReadFile(args[1])
+		if err != nil {
+			return err
+		}
+		if err = json.Unmarshal(file, &rules); err != nil {
+			return fmt.Errorf("%s: %w", args[1], err)
+		}
+		rules, err = client.SetFaults(rules)
+	}
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.writeJSON(rules)
+	}
+	w := c.table("PEERS", "MSG TYPES", "DIRECTION", "DROP", "DUPLICATE", "CORRUPT", "DELAY", "JITTER", "HALF CLOSE", "REFUSE CONNECT")
+	for _, rule := range rules {
+		direction := "out"
+		if rule.Incoming {
+			direction = "in"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%g\t%g\t%g\t%s\t%s\t%t\t%t\n",
+			orAll(rule.Peers), orAll(rule.MsgTypes), direction, rule.Drop, rule.Duplicate, rule.Corrupt,
+			orNone(rule.Delay), orNone(rule.Jitter), rule.HalfClose, rule.RefuseConnect)
+	}
+	return w.Flush()
+}
+
+// parsePairs parses the key=value args.
+func parsePairs(args []string) (map[string]string, error) {
+	pairs := make(map[string]string, len(args))
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, errUsage
+		}
+		pairs[key] = value
+	}
+	return pairs, nil
+}
+
+func formatPairs(pairs map[string]string) string {
+	if len(pairs) == 0 {
+		return "-"
+	}
+	formatted := make([]string, 0, len(pairs))
+	for _, key := range sortedKeys(pairs) {
+		formatted = append(formatted, key+"="+pairs[key])
+	}
+	return strings.Join(formatted, ",")
+}
+
+func orAll(values []string) string {
+	if len(values) == 0 {
+		return "*"
+	}
+	return strings.Join(values, ",")
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func (c *cli) table(columns ...string) *tabwriter.Writer {
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(columns, "\t"))
+	return w
+}
+
+func (c *cli) writeJSON(v any) error {
+	encoder := json.NewEncoder(c.out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// adminAddress returns the admin API address the node advertises,
+// or derives it from its listen address if the admin port offset is set.
+func (c *cli) adminAddress(node admin.Node) (string, error) {
+	if address, ok := node.Metadata[admin.AddressKey]; ok {
+		return address, nil
+	}
+	if c.adminPortOffset == 0 {
+		return "", fmt.Errorf("node %s advertises no admin API", node.ID)
+	}
+	host, port, err := net.SplitHostPort(node.ListenAddress)
+	if err != nil {
+		return "", err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(p+c.adminPortOffset)), nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
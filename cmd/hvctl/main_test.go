@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tamararankovic/hyparview/admin"
+	"github.com/tamararankovic/hyparview/data"
+	"github.com/tamararankovic/hyparview/hyparview"
+	"github.com/tamararankovic/hyparview/transport"
+)
+
+// fakeNode serves the views of a node over the admin API.
+type fakeNode struct {
+	server *httptest.Server
+	views  admin.Views
+}
+
+func newFakeNode(t *testing.T, id string) *fakeNode {
+	t.Helper()
+	n := &fakeNode{}
+	n.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/views" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(n.views)
+	}))
+	t.Cleanup(n.server.Close)
+	n.views = admin.Views{
+		Self:    admin.Node{ID: id, ListenAddress: id + ":7000", Metadata: map[string]string{admin.AddressKey: n.address()}},
+		Active:  []admin.ActivePeer{},
+		Passive: []admin.Node{},
+	}
+	return n
+}
+
+func (n *fakeNode) address() string {
+	return strings.TrimPrefix(n.server.URL, "http://")
+}
+
+func runHvctl(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"unknown", "127.0.0.1:8000"},
+		{"views"},
+		{"disconnect", "127.0.0.1:8000"},
+		{"-o", "xml", "views", "127.0.0.1:8000"},
+		{"ban", "127.0.0.1:8000", "a", "forever"},
+	} {
+		if code, _, _ := runHvctl(t, args...); code != exitUsage {
+			t.Errorf("hvctl %v exited with %d, want %d", args, code, exitUsage)
+		}
+	}
+	if code, _, _ := runHvctl(t, "-h"); code != exitOK {
+		t.Errorf("hvctl -h exited with %d, want %d", code, exitOK)
+	}
+}
+
+func TestUnreachableNode(t *testing.T) {
+	code, _, stderr := runHvctl(t, "-timeout", "100ms", "views", "127.0.0.1:1")
+	if code != exitFailure || stderr == "" {
+		t.Errorf("views of an unreachable node exited with %d and %q, want %d and the error", code, stderr, exitFailure)
+	}
+}
+
+func TestCrawlFollowsAdvertisedAddresses(t *testing.T) {
+	a, b, c := newFakeNode(t, "a"), newFakeNode(t, "b"), newFakeNode(t, "c")
+	a.views.Active = []admin.ActivePeer{{Node: b.views.Self}}
+	// d advertises no admin API
+	a.views.Passive = []admin.Node{{ID: "d", ListenAddress: "d:7000"}}
+	b.views.Active = []admin.ActivePeer{{Node: a.views.Self}}
+	b.views.Passive = []admin.Node{c.views.Self}
+	c.views.Passive = []admin.Node{b.views.Self}
+
+	code, stdout, stderr := runHvctl(t, "-o", "json", "crawl", a.address())
+	if code != exitOK {
+		t.Fatalf("crawl exited with %d: %s", code, stderr)
+	}
+	var result crawlResult
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatal(err)
+	}
+	reached := make([]string, 0, len(result.Nodes))
+	for _, views := range result.Nodes {
+		reached = append(reached, views.Self.ID)
+	}
+	if strings.Join(reached, ",") != "a,b,c" {
+		t.Errorf("crawl reached %v, want a, b and c", reached)
+	}
+	if len(result.Unreachable) != 1 || result.Unreachable[0] != "d:7000" {
+		t.Errorf("unreachable %v, want d without an admin API", result.Unreachable)
+	}
+}
+
+func TestCrawlAdminPortOffset(t *testing.T) {
+	a, b := newFakeNode(t, "a"), newFakeNode(t, "b")
+	host, port, err := net.SplitHostPort(b.address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := strconv.Atoi(port)
+	// b advertises no admin API, it is served at its listen port plus the offset
+	b.views.Self.Metadata = nil
+	b.views.Self.ListenAddress = net.JoinHostPort(host, strconv.Itoa(p-1000))
+	a.views.Active = []admin.ActivePeer{{Node: b.views.Self}}
+
+	for _, tc := range []struct {
+		offset  string
+		reached string
+	}{
+		{"0", "1 nodes reached, 1 unreachable"},
+		{"1000", "2 nodes reached, 0 unreachable"},
+	} {
+		code, stdout, stderr := runHvctl(t, "-admin-port-offset", tc.offset, "crawl", a.address())
+		if code != exitOK {
+			t.Fatalf("crawl exited with %d: %s", code, stderr)
+		}
+		if !strings.Contains(stdout, tc.reached) {
+			t.Errorf("crawl with offset %s printed:\n%s\nwant %q", tc.offset, stdout, tc.reached)
+		}
+	}
+}
+
+func TestBan(t *testing.T) {
+	until := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]time.Time{"a": until})
+	}))
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+
+	code, stdout, stderr := runHvctl(t, "ban", address, "a", "1h")
+	if code != exitOK {
+		t.Fatalf("ban exited with %d: %s", code, stderr)
+	}
+	if fields := strings.Fields(stdout); len(fields) != 4 || fields[2] != "a" || fields[3] != until.Format(time.RFC3339) {
+		t.Errorf("ban printed:\n%s\nwant the ban of a", stdout)
+	}
+	if code, _, stderr := runHvctl(t, "unban", address, "a"); code != exitOK {
+		t.Fatalf("unban exited with %d: %s", code, stderr)
+	}
+	want := []string{"PUT /bans/a?duration=1h0m0s", "DELETE /bans/a"}
+	if strings.Join(requests, ",") != strings.Join(want, ",") {
+		t.Errorf("requests %v, want %v", requests, want)
+	}
+}
+
+func TestAdminToken(t *testing.T) {
+	network := transport.NewMemNetwork(transport.DefaultConnConfig())
+	self := data.Node{ID: "a", ListenAddress: "a", Metadata: map[string]string{admin.AddressKey: "127.0.0.1:8000", "zone": "eu-1"}}
+	hv, err := hyparview.NewHyParView(hyparview.DefaultConfig(10), self, transport.NewConnManager(self, network.NewConnFn("a"), network.AcceptConnsFn("a")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(hv.Stop)
+	server := httptest.NewServer(admin.NewHandler(hv, admin.RequireToken("secret")))
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+
+	for _, token := range []string{"", "wrong"} {
+		code, _, stderr := runHvctl(t, "-token", token, "views", address)
+		if code != exitFailure || !strings.Contains(stderr, "401") {
+			t.Errorf("views with token %q exited with %d: %s", token, code, stderr)
+		}
+	}
+	resp, err := http.Get(server.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("healthz without a token returned %s", resp.Status)
+	}
+
+	t.Setenv("HVCTL_TOKEN", "secret")
+	code, _, stderr := runHvctl(t, "metadata", address, "zone=us-1", admin.AddressKey+"=10.0.0.1:8000")
+	if code != exitOK {
+		t.Fatalf("metadata exited with %d: %s", code, stderr)
+	}
+	want := map[string]string{admin.AddressKey: "127.0.0.1:8000", "zone": "us-1"}
+	if metadata := hv.Self().Metadata; !maps.Equal(metadata, want) {
+		t.Errorf("metadata %v after the update, want %v", metadata, want)
+	}
+}
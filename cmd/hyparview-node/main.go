@@ -0,0 +1,249 @@
+// Command hyparview-node runs a HyParView node.
+//
+// The node is configured the way config.Load describes: a YAML or JSON file,
+// HYPARVIEW_* environment variables and flags. The admin API and the metrics
+// are served if their addresses are set, both serve /healthz and /readyz.
+// With an identity file the node ID is derived from the key in it, with a cluster
+// secret only the nodes knowing it are admitted. The effective config is logged
+// at startup. On SIGINT or SIGTERM the node leaves the overlay and exits.
+//
+// Exit codes:
+//
+//	0  the node shut down after a signal
+//	1  the node failed while running
+//	2  the config is invalid
+//	3  the node failed to start
+//	4  the node failed to join the overlay
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/tamararankovic/hyparview/admin"
+	"github.com/tamararankovic/hyparview/config"
+	"github.com/tamararankovic/hyparview/data"
+	"github.com/tamararankovic/hyparview/hyparview"
+	"github.com/tamararankovic/hyparview/identity"
+	"github.com/tamararankovic/hyparview/transport"
+)
+
+const (
+	exitOK = iota
+	exitFailure
+	exitInvalidConfig
+	exitStartFailed
+	exitJoinFailed
+)
+
+const (
+	joinAttempts        = 5
+	joinBackoff         = time.Second
+	configWatchInterval = 5 * time.Second
+	shutdownTimeout     = 5 * time.Second
+)
+
+func main() {
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	cfg, err := config.Load(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		log.Println(err)
+		return exitInvalidConfig
+	}
+
+	var opts []hyparview.Option
+	if cfg.IdentityFile != "" {
+		id, err := identity.LoadOrGenerate(cfg.IdentityFile)
+		if err != nil {
+			log.Printf("identity: %v\n", err)
+			return exitStartFailed
+		}
+		if cfg.NodeID == "" {
+			cfg.NodeID = id.NodeID()
+		}
+		opts = append(opts, hyparview.WithIdentity(id))
+	}
+	if cfg.ClusterSecret != "" {
+		opts = append(opts, hyparview.WithAdmission(hyparview.NewClusterSecret([]byte(cfg.ClusterSecret))))
+	}
+	var effective strings.Builder
+	config.Print(&effective, cfg)
+	log.Printf("effective config:\n%s", effective.String())
+
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	self := data.Node{
+		ID:            cfg.NodeID,
+		ListenAddress: cfg.ListenAddress,
+		Metadata:      cfg.Metadata,
+	}
+	var adminOpts []admin.HandlerOption
+	if cfg.AdminAddress != "" && cfg.AdminToken != "" {
+		self.Metadata = admin.Advertise(cfg.Metadata, cfg.AdminAddress, cfg.ListenAddress)
+		adminOpts = append(adminOpts, admin.RequireToken(cfg.AdminToken))
+	} else if cfg.AdminAddress != "" {
+		log.Println("admin API served without a token, its address is not advertised")
+	}
+	connConfig := transport.DefaultConnConfig()
+	newConnFn, acceptConnsFn := transport.NewTCPConnFn(connConfig), transport.AcceptTcpConnsFn(self.ListenAddress, connConfig)
+	if cfg.Transport == "unix" {
+		newConnFn, acceptConnsFn = transport.NewUnixConnFn(connConfig), transport.AcceptUnixConnsFn(self.ListenAddress, connConfig)
+	}
+	if cfg.FaultInjection {
+		log.Println("fault injection enabled")
+		injector := transport.NewFaultInjector()
+		newConnFn, acceptConnsFn = injector.WrapNewConnFn(newConnFn), injector.WrapAcceptConnsFn(acceptConnsFn)
+		adminOpts = append(adminOpts, admin.WithFaultInjector(injector))
+	}
+	if cfg.MembershipFile != "" {
+		opts = append(opts, hyparview.WithMembershipStore(hyparview.NewFileStore(cfg.MembershipFile), cfg.MembershipPersistInterval))
+	}
+	connManager := transport.NewConnManager(self, newConnFn, acceptConnsFn)
+	hv, err := hyparview.NewHyParView(cfg.HyParViewConfig, self, connManager, opts...)
+	if err != nil {
+		log.Println(err)
+		if hv != nil {
+			hv.Leave()
+		}
+		return exitStartFailed
+	}
+
+	serveErrs := make(chan error, 2)
+	servers := make([]*http.Server, 0)
+	for _, s := range []struct {
+		name, address string
+		handler       http.Handler
+	}{
+		{"admin API", cfg.AdminAddress, admin.NewHandler(hv, adminOpts...)},
+		{"metrics", cfg.MetricsAddress, admin.NewMetricsHandler(hv)},
+	} {
+		if s.address == "" {
+			continue
+		}
+		server, err := serve(s.address, s.handler, serveErrs)
+		if err != nil {
+			log.Printf("%s: %v\n", s.name, err)
+			hv.Leave()
+			shutdown(servers)
+			return exitStartFailed
+		}
+		log.Printf("%s served on %s\n", s.name, s.address)
+		servers = append(servers, server)
+	}
+	defer shutdown(servers)
+
+	stopWatch, err := config.Watch(args, configWatchInterval, func(c hyparview.HyParViewConfig) {
+		if err := hv.UpdateConfig(c); err != nil {
+			log.Println(err)
+		}
+	})
+	if err == nil {
+		defer stopWatch()
+	}
+
+	if cfg.ContactNodeAddress != "" || cfg.MembershipFile != "" {
+		if sig, err := join(hv, cfg.ContactNodeAddress, sigs); sig != nil {
+			log.Printf("received %s while joining, leaving\n", sig)
+			hv.Leave()
+			return exitOK
+		} else if errors.Is(err, hyparview.ErrNoContactNode) {
+			// without a contact node the node starts a new overlay, as if nothing was persisted
+			log.Printf("no restored node reachable, starting a new overlay: %v\n", err)
+		} else if err != nil {
+			log.Printf("failed to join through %s: %v\n", cfg.ContactNodeAddress, err)
+			hv.Leave()
+			return exitJoinFailed
+		}
+	}
+
+	select {
+	case sig := <-sigs:
+		log.Printf("received %s, leaving\n", sig)
+		go func() {
+			sig := <-sigs
+			log.Printf("received %s again, exiting\n", sig)
+			os.Exit(exitFailure)
+		}()
+		hv.Leave()
+		return exitOK
+	case <-hv.Left():
+		log.Println("left the overlay through the admin API")
+		return exitOK
+	case err := <-serveErrs:
+		log.Println(err)
+		hv.Leave()
+		return exitFailure
+	}
+}
+
+// join retries the join with an exponential backoff, it gives up early
+// if there is no contact node to retry with and returns the signal if one is received.
+func join(hv *hyparview.HyParView, contactNodeAddress string, sigs chan os.Signal) (os.Signal, error) {
+	backoff := joinBackoff
+	var err error
+	for attempt := 1; attempt <= joinAttempts; attempt++ {
+		err = hv.Join(contactNodeAddress)
+		if err == nil || errors.Is(err, hyparview.ErrNoContactNode) {
+			return nil, err
+		}
+		log.Printf("join attempt %d/%d failed: %v\n", attempt, joinAttempts, err)
+		if attempt == joinAttempts {
+			break
+		}
+		select {
+		case sig := <-sigs:
+			return sig, nil
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return nil, err
+}
+
+// serve starts serving the handler, the listen errors are returned
+// and the ones occurring later are passed to errs.
+func serve(address string, handler http.Handler, errs chan<- error) (*http.Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		err := server.Serve(listener)
+		if !errors.Is(err, http.ErrServerClosed) {
+			errs <- fmt.Errorf("HTTP server on %s: %w", address, err)
+		}
+	}()
+	return server, nil
+}
+
+func shutdown(servers []*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tamararankovic/hyparview/hyparview"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	envPrefix                  = "HYPARVIEW_"
+	defaultExpectedClusterSize = 100
+	// expectedClusterSize is not a config value, the defaults are derived from it
+	expectedClusterSize = "expected_cluster_size"
+	configFile          = "config"
+)
+
+// assignment is a config value set by one of the sources.
+type assignment struct {
+	name, value, source string
+}
+
+// Load builds the node config out of the defaults, the YAML or JSON config file,
+// the HYPARVIEW_* environment variables and the command line flags,
+// each source overriding the values set by the previous ones.
+// The defaults are derived from the expected cluster size.
+func Load(args []string) (hyparview.Config, error) {
+	flags, err := parseFlags(args)
+	if err != nil {
+		return hyparview.Config{}, err
+	}
+	path := configPath(flags)
+	assignments := make([]assignment, 0)
+	if path != "" {
+		fromFile, err := readFile(path)
+		if err != nil {
+			return hyparview.Config{}, err
+		}
+		assignments = append(assignments, fromFile...)
+	}
+	assignments = append(assignments, readEnv()...)
+	assignments = append(assignments, flags...)
+
+	clusterSize := defaultExpectedClusterSize
+	for _, a := range assignments {
+		if a.name != expectedClusterSize {
+			continue
+		}
+		if _, err := fmt.Sscan(a.value, &clusterSize); err != nil {
+			return hyparview.Config{}, fmt.Errorf("%s from %s: %w", a.name, a.source, err)
+		}
+	}
+	config := hyparview.Config{
+		Transport:       "tcp",
+		HyParViewConfig: hyparview.DefaultConfig(clusterSize),
+	}
+	for _, a := range assignments {
+		if a.name == expectedClusterSize || a.name == configFile {
+			continue
+		}
+		p, ok := findParam(a.name)
+		if !ok {
+			return hyparview.Config{}, fmt.Errorf("unknown config value %s from %s", a.name, a.source)
+		}
+		if err := p.set(&config, a.value); err != nil {
+			return hyparview.Config{}, fmt.Errorf("%s from %s: %w", a.name, a.source, err)
+		}
+	}
+	if err := config.Validate(); err != nil {
+		return hyparview.Config{}, fmt.Errorf("invalid config: %w", err)
+	}
+	return config, nil
+}
+
+// Print writes the effective config, one value per line, the secrets are masked.
+func Print(w io.Writer, config hyparview.Config) {
+	for _, p := range params {
+		value := p.get(config)
+		if p.secret && value != "" {
+			value = "***"
+		}
+		fmt.Fprintf(w, "%s=%s\n", p.name, value)
+	}
+}
+
+// configPath returns the config file path set by the flags or the environment.
+func configPath(flags []assignment) string {
+	path := os.Getenv(envPrefix + strings.ToUpper(configFile))
+	for _, a := range flags {
+		if a.name == configFile {
+			path = a.value
+		}
+	}
+	return path
+}
+
+func parseFlags(args []string) ([]assignment, error) {
+	fs := flag.NewFlagSet("hyparview", flag.ContinueOnError)
+	fs.String(flagName(configFile), "", "path to the YAML or JSON config file")
+	fs.Int(flagName(expectedClusterSize), defaultExpectedClusterSize, "cluster size the defaults are derived from")
+	for _, p := range params {
+		fs.String(flagName(p.name), "", p.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	assignments := make([]assignment, 0)
+	fs.Visit(func(f *flag.Flag) {
+		assignments = append(assignments, assignment{
+			name:   strings.ReplaceAll(f.Name, "-", "_"),
+			value:  f.Value.String(),
+			source: "flag -" + f.Name,
+		})
+	})
+	return assignments, nil
+}
+
+func readEnv() []assignment {
+	assignments := make([]assignment, 0)
+	names := []string{expectedClusterSize}
+	for _, p := range params {
+		names = append(names, p.name)
+	}
+	for _, name := range names {
+		envName := envPrefix + strings.ToUpper(name)
+		if value, ok := os.LookupEnv(envName); ok {
+			assignments = append(assignments, assignment{name: name, value: value, source: "env " + envName})
+		}
+	}
+	return assignments
+}
+
+func readFile(path string) ([]assignment, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]any)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(content, &values)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &values)
+	default:
+		return nil, fmt.Errorf("config file %s not a YAML or JSON file", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	assignments := make([]assignment, 0, len(values))
+	for name, value := range values {
+		assignments = append(assignments, assignment{name: name, value: fileValue(value), source: "file " + path})
+	}
+	return assignments, nil
+}
+
+// fileValue formats the value the way it would be set by a flag,
+// maps such as msg_rate_limits become comma separated KEY=VALUE pairs.
+func fileValue(value any) string {
+	m, ok := value.(map[string]any)
+	if !ok {
+		return scalarValue(value)
+	}
+	pairs := make([]string, 0, len(m))
+	for k, v := range m {
+		pairs = append(pairs, k+"="+scalarValue(v))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// scalarValue formats the value, the JSON numbers decoded as floats
+// are written without an exponent so large integers stay integers.
+func scalarValue(value any) string {
+	if f, ok := value.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+func flagName(name string) string {
+	return strings.ReplaceAll(name, "_", "-")
+}
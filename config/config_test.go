@@ -0,0 +1,179 @@
+package config
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tamararankovic/hyparview/data"
+	"github.com/tamararankovic/hyparview/hyparview"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "node_id: from-file\nlisten_address: 127.0.0.1:7000\nfanout: 3\narwl: 5\nshuffle_interval: 3s\nmsg_rate_limits:\n  SHUFFLE: 1/5\nmetadata:\n  zone: eu-1\n  rack: r2\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HYPARVIEW_FANOUT", "4")
+	t.Setenv("HYPARVIEW_ARWL", "7")
+
+	config, err := Load([]string{"-config", path, "-arwl", "8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.NodeID != "from-file" || config.ShuffleInterval != 3*time.Second {
+		t.Errorf("file values not applied: %+v", config)
+	}
+	if config.Fanout != 4 {
+		t.Errorf("fanout = %d, want the env value 4", config.Fanout)
+	}
+	if config.ARWL != 8 {
+		t.Errorf("arwl = %d, want the flag value 8", config.ARWL)
+	}
+	if limit := config.MsgRateLimits[data.SHUFFLE]; limit.Rate != 1 || limit.Burst != 5 {
+		t.Errorf("shuffle rate limit = %+v, want 1/5", limit)
+	}
+	if config.Metadata["zone"] != "eu-1" || config.Metadata["rack"] != "r2" {
+		t.Errorf("metadata = %v, want zone eu-1 and rack r2", config.Metadata)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	if _, err := Load([]string{"-node-id", "a", "-listen-address", "127.0.0.1:7000", "-prwl", "100"}); err == nil {
+		t.Error("expected PRWL above ARWL to be rejected")
+	}
+	if _, err := Load([]string{"-node-id", "a", "-fanout", "many"}); err == nil {
+		t.Error("expected a non numeric fanout to be rejected")
+	}
+}
+
+func TestApply(t *testing.T) {
+	config := hyparview.DefaultConfig(100)
+	updated, err := Apply(config, map[string]any{"fanout": 5.0, "shuffle_interval": "1m", "msg_rate_limits": map[string]any{"JOIN": "2/4"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Fanout != 5 || updated.ShuffleInterval != time.Minute || updated.MsgRateLimits[data.JOIN].Burst != 4 {
+		t.Errorf("values not applied: %+v", updated)
+	}
+	if _, err := Apply(config, map[string]any{"node_id": "other"}); err == nil {
+		t.Error("expected the node ID change to be rejected")
+	}
+	if _, err := Apply(config, map[string]any{"fanout": 0}); err == nil {
+		t.Error("expected the invalid fanout to be rejected")
+	}
+}
+
+func TestWatchComparesWithLastReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	modTime := time.Now()
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		// the mod time is moved on explicitly, the writes may land within the timestamp resolution
+		modTime = modTime.Add(time.Second)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var logs bytes.Buffer
+	var logsLock sync.Mutex
+	out := log.Writer()
+	log.SetOutput(writerFunc(func(p []byte) (int, error) {
+		logsLock.Lock()
+		defer logsLock.Unlock()
+		return logs.Write(p)
+	}))
+	t.Cleanup(func() { log.SetOutput(out) })
+
+	write("node_id: a\nlisten_address: 127.0.0.1:7000\nfanout: 3\n")
+	changes := make(chan hyparview.HyParViewConfig, 10)
+	stop, err := Watch([]string{"-config", path}, 10*time.Millisecond, func(config hyparview.HyParViewConfig) {
+		changes <- config
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	await := func() hyparview.HyParViewConfig {
+		t.Helper()
+		select {
+		case config := <-changes:
+			return config
+		case <-time.After(2 * time.Second):
+			t.Fatal("config change not reported")
+			return hyparview.HyParViewConfig{}
+		}
+	}
+
+	write("node_id: b\nlisten_address: 127.0.0.1:7000\nfanout: 4\n")
+	if config := await(); config.Fanout != 4 {
+		t.Errorf("fanout %d, want 4", config.Fanout)
+	}
+	write("node_id: b\nlisten_address: 127.0.0.1:7000\nfanout: 5\n")
+	if config := await(); config.Fanout != 5 {
+		t.Errorf("fanout %d, want 5", config.Fanout)
+	}
+	// a reload changing no protocol value is not passed on
+	write("node_id: b\nlisten_address: 127.0.0.1:7000\nfanout: 5\n# touched\n")
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case config := <-changes:
+		t.Errorf("unchanged config %+v passed on", config)
+	default:
+	}
+	logsLock.Lock()
+	defer logsLock.Unlock()
+	if n := strings.Count(logs.String(), "node_id changed"); n != 1 {
+		t.Errorf("node ID change reported %d times, want once:\n%s", n, logs.String())
+	}
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func TestLoadJSONNumbers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	content := `{"node_id": "a", "listen_address": "127.0.0.1:7000", "passive_view_size": 1000000, "peer_rate_limit_rate": 0.5, "peer_rate_limit_burst": 2000000}`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := Load([]string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.PassiveViewSize != 1000000 || config.PeerRateLimit.Burst != 2000000 {
+		t.Errorf("passive view size %d and burst %d, want 1000000 and 2000000", config.PassiveViewSize, config.PeerRateLimit.Burst)
+	}
+	if config.PeerRateLimit.Rate != 0.5 {
+		t.Errorf("peer rate %g, want 0.5", config.PeerRateLimit.Rate)
+	}
+}
+
+func TestPrintMasksSecrets(t *testing.T) {
+	config, err := Load([]string{"-identity-file", "node.key", "-listen-address", "127.0.0.1:7000", "-cluster-secret", "s3cret"})
+	if err != nil {
+		t.Fatalf("config without a node ID but with an identity file rejected: %v", err)
+	}
+	var printed strings.Builder
+	Print(&printed, config)
+	if strings.Contains(printed.String(), "s3cret") {
+		t.Errorf("cluster secret printed:\n%s", printed.String())
+	}
+	for _, line := range []string{"cluster_secret=***\n", "identity_file=node.key\n", "listen_address=127.0.0.1:7000\n"} {
+		if !strings.Contains(printed.String(), line) {
+			t.Errorf("%q not printed:\n%s", line, printed.String())
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tamararankovic/hyparview/data"
+	"github.com/tamararankovic/hyparview/hyparview"
+)
+
+// param is a config value that can be set from the file, the environment and the flags.
+// Its name is the file key, the flag name with dashes instead of underscores
+// and the environment variable name in upper case prefixed with HYPARVIEW_.
+type param struct {
+	name  string
+	usage string
+	set   func(c *hyparview.Config, value string) error
+	get   func(c hyparview.Config) string
+	// static params identify the node and can not be changed at runtime
+	static bool
+	// secret params are not printed
+	secret bool
+}
+
+var params = []param{
+	stringParam("node_id", "ID of the node", func(c *hyparview.Config) *string { return &c.NodeID }).makeStatic(),
+	stringParam("listen_address", "address the node accepts connections on", func(c *hyparview.Config) *string { return &c.ListenAddress }).makeStatic(),
+	stringParam("contact_node_address", "address of the node contacted when joining", func(c *hyparview.Config) *string { return &c.ContactNodeAddress }).makeStatic(),
+	stringParam("admin_address", "HTTP address of the admin API, empty to disable it", func(c *hyparview.Config) *string { return &c.AdminAddress }).makeStatic(),
+	stringParam("admin_token", "bearer token the admin API requires, the admin address is advertised only if it is set", func(c *hyparview.Config) *string { return &c.AdminToken }).makeStatic().makeSecret(),
+	stringParam("metrics_address", "HTTP address of the metrics, empty to disable them", func(c *hyparview.Config) *string { return &c.MetricsAddress }).makeStatic(),
+	stringParam("transport", "network the node listens on, tcp or unix", func(c *hyparview.Config) *string { return &c.Transport }).makeStatic(),
+	boolParam("fault_injection", "let the admin API inject transport failures, for testing only", func(c *hyparview.Config) *bool { return &c.FaultInjection }).makeStatic(),
+	stringParam("membership_file", "file the view membership is persisted to and restored from, empty to disable it", func(c *hyparview.Config) *string { return &c.MembershipFile }).makeStatic(),
+	durationParam("membership_persist_interval", "min interval between two saves of the view membership, 0 saves every change", func(c *hyparview.Config) *time.Duration { return &c.MembershipPersistInterval }).makeStatic(),
+	param{
+		name:  "metadata",
+		usage: "metadata advertised to the other nodes as KEY=VALUE pairs separated by commas, e.g. zone=eu-1",
+		set:   setMetadata,
+		get:   getMetadata,
+	}.makeStatic(),
+	stringParam("identity_file", "file holding the private key the node ID is derived from, generated if missing", func(c *hyparview.Config) *string { return &c.IdentityFile }).makeStatic(),
+	stringParam("cluster_secret", "secret the nodes admitted into the overlay share, it keeps misconfigured nodes out but authenticates none, empty to admit all", func(c *hyparview.Config) *string { return &c.ClusterSecret }).makeStatic().makeSecret(),
+	intParam("fanout", "active view size minus one", func(c *hyparview.Config) *int { return &c.Fanout }),
+	intParam("passive_view_size", "passive view size", func(c *hyparview.Config) *int { return &c.PassiveViewSize }),
+	intParam("arwl", "active random walk length", func(c *hyparview.Config) *int { return &c.ARWL }),
+	intParam("prwl", "passive random walk length", func(c *hyparview.Config) *int { return &c.PRWL }),
+	intParam("ka", "number of active view nodes sent in a shuffle", func(c *hyparview.Config) *int { return &c.Ka }),
+	intParam("kp", "number of passive view nodes sent in a shuffle", func(c *hyparview.Config) *int { return &c.Kp }),
+	durationParam("shuffle_interval", "interval between two shuffles", func(c *hyparview.Config) *time.Duration { return &c.ShuffleInterval }),
+	floatParam("peer_rate_limit_rate", "msgs per second accepted from a single peer, 0 disables the limit", func(c *hyparview.Config) *float64 { return &c.PeerRateLimit.Rate }),
+	intParam("peer_rate_limit_burst", "burst of msgs accepted from a single peer", func(c *hyparview.Config) *int { return &c.PeerRateLimit.Burst }),
+	{
+		name:  "msg_rate_limits",
+		usage: "per msg type limits as TYPE=RATE/BURST pairs separated by commas, e.g. SHUFFLE=1/5",
+		set:   setMsgRateLimits,
+		get:   getMsgRateLimits,
+	},
+	intParam("max_passive_per_source", "max passive view entries learned from a single node, 0 disables the cap", func(c *hyparview.Config) *int { return &c.MaxPassivePerSource }),
+	intParam("max_passive_per_prefix", "max passive view entries sharing an address prefix, 0 disables the cap", func(c *hyparview.Config) *int { return &c.MaxPassivePerPrefix }),
+	intParam("prefix_length_v4", "IPv4 address prefix length, defaults to 24", func(c *hyparview.Config) *int { return &c.PrefixLengthV4 }),
+	intParam("prefix_length_v6", "IPv6 address prefix length, defaults to 48", func(c *hyparview.Config) *int { return &c.PrefixLengthV6 }),
+	boolParam("prefer_diverse_origins", "evict the passive view entries of the most represented origin first", func(c *hyparview.Config) *bool { return &c.PreferDiverseOrigins }),
+	intParam("remote_peers", "active view slots kept for the peers outside the locality of the node, 0 disables the locality policy", func(c *hyparview.Config) *int { return &c.RemotePeers }),
+	stringParam("locality_key", "metadata key whose shared value makes the peers local, e.g. zone", func(c *hyparview.Config) *string { return &c.LocalityKey }),
+}
+
+func (p param) makeStatic() param {
+	p.static = true
+	return p
+}
+
+func (p param) makeSecret() param {
+	p.secret = true
+	return p
+}
+
+func findParam(name string) (param, bool) {
+	for _, p := range params {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return param{}, false
+}
+
+func stringParam(name, usage string, field func(c *hyparview.Config) *string) param {
+	return param{
+		name:  name,
+		usage: usage,
+		set: func(c *hyparview.Config, value string) error {
+			*field(c) = value
+			return nil
+		},
+		get: func(c hyparview.Config) string {
+			return *field(&c)
+		},
+	}
+}
+
+func intParam(name, usage string, field func(c *hyparview.Config) *int) param {
+	return param{
+		name:  name,
+		usage: usage,
+		set: func(c *hyparview.Config, value string) error {
+			v, err := strconv.Atoi(value)
+			if err != nil {
+				return err
+			}
+			*field(c) = v
+			return nil
+		},
+		get: func(c hyparview.Config) string {
+			return strconv.Itoa(*field(&c))
+		},
+	}
+}
+
+func floatParam(name, usage string, field func(c *hyparview.Config) *float64) param {
+	return param{
+		name:  name,
+		usage: usage,
+		set: func(c *hyparview.Config, value string) error {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return err
+			}
+			*field(c) = v
+			return nil
+		},
+		get: func(c hyparview.Config) string {
+			return strconv.FormatFloat(*field(&c), 'g', -1, 64)
+		},
+	}
+}
+
+func boolParam(name, usage string, field func(c *hyparview.Config) *bool) param {
+	return param{
+		name:  name,
+		usage: usage,
+		set: func(c *hyparview.Config, value string) error {
+			v, err := strconv.ParseBool(value)
+			if err != nil {
+				return err
+			}
+			*field(c) = v
+			return nil
+		},
+		get: func(c hyparview.Config) string {
+			return strconv.FormatBool(*field(&c))
+		},
+	}
+}
+
+func durationParam(name, usage string, field func(c *hyparview.Config) *time.Duration) param {
+	return param{
+		name:  name,
+		usage: usage,
+		set: func(c *hyparview.Config, value string) error {
+			v, err := time.ParseDuration(value)
+			if err != nil {
+				return err
+			}
+			*field(c) = v
+			return nil
+		},
+		get: func(c hyparview.Config) string {
+			return field(&c).String()
+		},
+	}
+}
+
+func setMsgRateLimits(c *hyparview.Config, value string) error {
+	limits := make(map[data.MessageType]hyparview.RateLimit)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		typeName, limitValue, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("%s not a TYPE=RATE/BURST pair", pair)
+		}
+		msgType, err := data.ParseMessageType(strings.ToUpper(strings.TrimSpace(typeName)))
+		if err != nil {
+			return err
+		}
+		rateValue, burstValue, ok := strings.Cut(limitValue, "/")
+		if !ok {
+			return fmt.Errorf("%s not a RATE/BURST limit", limitValue)
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(rateValue), 64)
+		if err != nil {
+			return err
+		}
+		burst, err := strconv.Atoi(strings.TrimSpace(burstValue))
+		if err != nil {
+			return err
+		}
+		limits[msgType] = hyparview.RateLimit{Rate: rate, Burst: burst}
+	}
+	c.MsgRateLimits = limits
+	return nil
+}
+
+func setMetadata(c *hyparview.Config, value string) error {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("%s not a KEY=VALUE pair", pair)
+		}
+		metadata[strings.TrimSpace(key)] = strings.TrimSpace(v)
+	}
+	c.Metadata = metadata
+	return nil
+}
+
+func getMetadata(c hyparview.Config) string {
+	pairs := make([]string, 0, len(c.Metadata))
+	for key, value := range c.Metadata {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func getMsgRateLimits(c hyparview.Config) string {
+	pairs := make([]string, 0, len(c.MsgRateLimits))
+	for msgType, limit := range c.MsgRateLimits {
+		pairs = append(pairs, fmt.Sprintf("%s=%g/%d", msgType, limit.Rate, limit.Burst))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
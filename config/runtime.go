@@ -0,0 +1,111 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/tamararankovic/hyparview/hyparview"
+)
+
+// Values returns the config values that can be changed at runtime indexed by name.
+func Values(config hyparview.HyParViewConfig) map[string]string {
+	values := make(map[string]string)
+	for _, p := range params {
+		if !p.static {
+			values[p.name] = p.get(hyparview.Config{HyParViewConfig: config})
+		}
+	}
+	return values
+}
+
+// Apply sets the values on top of the config, the values are given
+// the way they would be in a JSON config file, e.g. {"fanout": 5}.
+func Apply(config hyparview.HyParViewConfig, values map[string]any) (hyparview.HyParViewConfig, error) {
+	updated := hyparview.Config{HyParViewConfig: config}
+	for name, value := range values {
+		p, ok := findParam(name)
+		if !ok {
+			return config, fmt.Errorf("unknown config value %s", name)
+		}
+		if p.static {
+			return config, fmt.Errorf("%s can not be changed at runtime", name)
+		}
+		if err := p.set(&updated, fileValue(value)); err != nil {
+			return config, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if err := updated.HyParViewConfig.Validate(); err != nil {
+		return config, fmt.Errorf("invalid config: %w", err)
+	}
+	return updated.HyParViewConfig, nil
+}
+
+// Watch checks the config file for changes every interval and reloads the config
+// the same way Load does, passing the protocol config to onChange if any of its values changed.
+// The static values, such as the node ID, are only applied on restart,
+// each change of them is reported once.
+// Calling stop ends the watch.
+func Watch(args []string, interval time.Duration, onChange func(hyparview.HyParViewConfig)) (stop func(), err error) {
+	flags, err := parseFlags(args)
+	if err != nil {
+		return nil, err
+	}
+	path := configPath(flags)
+	if path == "" {
+		return nil, errors.New("no config file to watch")
+	}
+	last, err := Load(args)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	modTime := info.ModTime()
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			if info.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = info.ModTime()
+			reloaded, err := Load(args)
+			if err != nil {
+				log.Printf("config file %s not applied: %v\n", path, err)
+				continue
+			}
+			changed := false
+			for _, p := range params {
+				if p.get(reloaded) == p.get(last) {
+					continue
+				}
+				if p.static {
+					log.Printf("%s changed in %s, restart the node to apply it\n", p.name, path)
+				} else {
+					changed = true
+				}
+			}
+			last = reloaded
+			if changed {
+				onChange(reloaded.HyParViewConfig)
+			}
+		}
+	}()
+	return func() { close(done) }, nil
+}
@@ -1,5 +1,7 @@
 package data
 
+import "fmt"
+
 type MessageType int8
 
 const (
@@ -10,48 +12,158 @@ const (
 	NEIGHTBOR_REPLY
 	SHUFFLE
 	SHUFFLE_REPLY
+	HANDSHAKE
+	HANDSHAKE_REPLY
+	BATCH
+	COMPRESSED
+	REJECT
+	HANDSHAKE_PROOF
+	JOIN_REPLY
 )
 
+var messageTypeNames = map[MessageType]string{
+	JOIN:            "JOIN",
+	FORWARD_JOIN:    "FORWARD_JOIN",
+	DISCONNECT:      "DISCONNECT",
+	NEIGHTBOR:       "NEIGHTBOR",
+	NEIGHTBOR_REPLY: "NEIGHTBOR_REPLY",
+	SHUFFLE:         "SHUFFLE",
+	SHUFFLE_REPLY:   "SHUFFLE_REPLY",
+	HANDSHAKE:       "HANDSHAKE",
+	HANDSHAKE_REPLY: "HANDSHAKE_REPLY",
+	BATCH:           "BATCH",
+	COMPRESSED:      "COMPRESSED",
+	REJECT:          "REJECT",
+	HANDSHAKE_PROOF: "HANDSHAKE_PROOF",
+	JOIN_REPLY:      "JOIN_REPLY",
+}
+
+func (t MessageType) String() string {
+	if name, ok := messageTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", int8(t))
+}
+
+func ParseMessageType(name string) (MessageType, error) {
+	for msgType, msgTypeName := range messageTypeNames {
+		if msgTypeName == name {
+			return msgType, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown message type %s", name)
+}
+
 type Message struct {
 	Type    MessageType
 	Payload any
 }
 
 type Join struct {
-	NodeID        string
-	ListenAddress string
+	NodeID          string
+	ListenAddress   string
+	Metadata        map[string]string
+	MetadataVersion uint64
+	Credentials     []byte
+	Signature       []byte
+}
+
+// JoinReply tells the joining node the contact node took it into its active view.
+type JoinReply struct {
+	NodeID          string
+	ListenAddress   string
+	Metadata        map[string]string
+	MetadataVersion uint64
+	Signature       []byte
 }
 
 type ForwardJoin struct {
-	NodeID        string
-	ListenAddress string
-	TTL           int
+	NodeID          string
+	ListenAddress   string
+	Metadata        map[string]string
+	MetadataVersion uint64
+	TTL             int
+	Credentials     []byte
+	Signature       []byte
 }
 
 type Disconnect struct {
-	NodeID string
+	NodeID    string
+	Signature []byte
 }
 
 type Neighbor struct {
-	NodeID        string
-	ListenAddress string
-	HighPriority  bool
+	NodeID          string
+	ListenAddress   string
+	Metadata        map[string]string
+	MetadataVersion uint64
+	HighPriority    bool
+	Credentials     []byte
+	Signature       []byte
 }
 
 type NeighborReply struct {
-	NodeID        string
-	ListenAddress string
-	Accepted      bool
+	NodeID          string
+	ListenAddress   string
+	Metadata        map[string]string
+	MetadataVersion uint64
+	Accepted        bool
+	Signature       []byte
 }
 
 type Shuffle struct {
-	NodeID        string
-	ListenAddress string
-	Nodes         []Node
-	TTL           int
+	NodeID          string
+	ListenAddress   string
+	Metadata        map[string]string
+	MetadataVersion uint64
+	Nodes           []Node
+	TTL             int
+	Signature       []byte
 }
 
 type ShuffleReply struct {
+	NodeID        string
 	ReceivedNodes []Node
 	Nodes         []Node
+	Signature     []byte
+}
+
+// Reject tells a node its JOIN or NEIGHTBOR msg was refused by the admission control.
+type Reject struct {
+	NodeID    string
+	MsgType   MessageType
+	Reason    string
+	Signature []byte
+}
+
+// Handshake is the first message sent on every new connection by the dialing side.
+type Handshake struct {
+	NodeID        string
+	ListenAddress string
+	Version       int
+	// Compression lists the codecs supported by the dialing side in order of preference
+	Compression []string
+	// Nonce is the challenge the accepting side signs to prove its node ID
+	Nonce []byte
+}
+
+// HandshakeReply is the accepting side's answer to a Handshake.
+type HandshakeReply struct {
+	NodeID        string
+	ListenAddress string
+	Version       int
+	Accepted      bool
+	Reason        string
+	// Compression is the codec picked for the connection
+	Compression string
+	// Nonce is the challenge the dialing side signs in its HandshakeProof
+	Nonce []byte
+	// Signature covers the nonce of the Handshake if the accepting side authenticates
+	Signature []byte
+}
+
+// HandshakeProof completes an authenticated handshake, the dialing side
+// proves its node ID by signing the nonce of the HandshakeReply.
+type HandshakeProof struct {
+	Signature []byte
 }
@@ -1,6 +1,11 @@
 package data
 
 type Node struct {
-	ID string
+	ID            string
 	ListenAddress string
+	// Metadata holds the tags the node advertises, e.g. its zone or role.
+	// MetadataVersion grows with every update of the metadata,
+	// the copies of the node info with a higher version replace the older ones.
+	Metadata        map[string]string
+	MetadataVersion uint64
 }
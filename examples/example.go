@@ -4,8 +4,11 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/tamararankovic/hyparview/config"
 	"github.com/tamararankovic/hyparview/data"
 	"github.com/tamararankovic/hyparview/hyparview"
 	"github.com/tamararankovic/hyparview/transport"
@@ -14,38 +17,37 @@ import (
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
-	id := os.Args[1]
-	address := os.Args[2]
-	contactNodeAddress := os.Args[3]
-	config := hyparview.Config{
-		NodeID:             id,
-		ListenAddress:      address,
-		ContactNodeAddress: contactNodeAddress,
-		HyParViewConfig: hyparview.HyParViewConfig{
-			Fanout:          2,
-			PassiveViewSize: 10,
-			ARWL:            3,
-			PRWL:            2,
-			ShuffleInterval: 10,
-			Ka:              1,
-			Kp:              1,
-		},
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
 	}
+	var effective strings.Builder
+	config.Print(&effective, cfg)
+	log.Printf("effective config:\n%s", effective.String())
 	self := data.Node{
-		ID:            config.NodeID,
-		ListenAddress: config.ListenAddress,
+		ID:            cfg.NodeID,
+		ListenAddress: cfg.ListenAddress,
 	}
-	connManager := transport.NewConnManager(transport.NewTCPConn, transport.AcceptTcpConnsFn(self.ListenAddress))
-	hv, err := hyparview.NewHyParView(config.HyParViewConfig, self, connManager)
+	connManager := transport.NewConnManager(self, transport.NewTCPConn, transport.AcceptTcpConnsFn(self.ListenAddress, transport.DefaultConnConfig()))
+	hv, err := hyparview.NewHyParView(cfg.HyParViewConfig, self, connManager)
 	if err != nil {
 		log.Fatal(err)
 	}
 	// time.Sleep(10 * time.Second)
-	err = hv.Join(contactNodeAddress)
+	err = hv.Join(cfg.ContactNodeAddress)
 	if err != nil {
 		log.Println(err)
 	}
 
+	stopWatch, err := config.Watch(os.Args[1:], 5*time.Second, func(c hyparview.HyParViewConfig) {
+		if err := hv.UpdateConfig(c); err != nil {
+			log.Println(err)
+		}
+	})
+	if err == nil {
+		defer stopWatch()
+	}
+
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 	log.Println("Waiting for exit signal...")
@@ -1,3 +1,7 @@
 module github.com/tamararankovic/hyparview
 
 go 1.24.2
+
+require github.com/klauspost/compress v1.18.0
+
+require gopkg.in/yaml.v3 v3.0.1
@@ -0,0 +1,119 @@
+package hyparview
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tamararankovic/hyparview/data"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrJoinTokenExpired   = errors.New("join token expired")
+)
+
+// Admission decides which nodes may become members of the overlay.
+// HyParView consults it before accepting JOIN, FORWARD_JOIN and NEIGHTBOR msgs.
+type Admission interface {
+	// Credentials returns what the node presents to the others when asking to be admitted.
+	Credentials(self data.Node) ([]byte, error)
+	// Admit returns an error if the node presenting the credentials should be rejected.
+	Admit(msgType data.MessageType, node data.Node, credentials []byte) error
+}
+
+// ClusterSecret admits the nodes that know the shared cluster secret,
+// the credentials are an HMAC of the node identity keyed by the secret.
+// The credentials of a node never change and are passed on in the FORWARD_JOIN msgs,
+// so whoever sees them can replay them. ClusterSecret keeps the nodes configured with
+// another secret out of the overlay, it does not authenticate them, WithIdentity does.
+type ClusterSecret struct {
+	secret []byte
+}
+
+func NewClusterSecret(secret []byte) ClusterSecret {
+	return ClusterSecret{secret: secret}
+}
+
+func (c ClusterSecret) Credentials(self data.Node) ([]byte, error) {
+	return c.mac(self), nil
+}
+
+func (c ClusterSecret) Admit(msgType data.MessageType, node data.Node, credentials []byte) error {
+	if !hmac.Equal(credentials, c.mac(node)) {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+func (c ClusterSecret) mac(node data.Node) []byte {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte("hyparview-cluster-secret\x00"))
+	mac.Write([]byte(node.ID))
+	mac.Write([]byte{0})
+	mac.Write([]byte(node.ListenAddress))
+	return mac.Sum(nil)
+}
+
+// JoinTokens admits the nodes holding a join token issued for their node ID.
+// The expiry is enforced for JOIN msgs only, so a node that joined in time
+// can keep repairing its active view after the token expired.
+type JoinTokens struct {
+	secret []byte
+	token  string
+	now    func() time.Time
+}
+
+// NewJoinTokens creates the admission of a node that presents the token,
+// the token may be empty for the nodes that only admit others.
+func NewJoinTokens(secret []byte, token string) JoinTokens {
+	return JoinTokens{
+		secret: secret,
+		token:  token,
+		now:    time.Now,
+	}
+}
+
+// IssueJoinToken creates a token allowing the node to join within the ttl.
+func IssueJoinToken(secret []byte, nodeID string, ttl time.Duration) string {
+	expiry := make([]byte, 8)
+	binary.BigEndian.PutUint64(expiry, uint64(time.Now().Add(ttl).Unix()))
+	token := append(expiry, joinTokenMAC(secret, nodeID, expiry)...)
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+func (j JoinTokens) Credentials(self data.Node) ([]byte, error) {
+	if j.token == "" {
+		return nil, errors.New("no join token")
+	}
+	return []byte(j.token), nil
+}
+
+func (j JoinTokens) Admit(msgType data.MessageType, node data.Node, credentials []byte) error {
+	token, err := base64.RawURLEncoding.DecodeString(string(credentials))
+	if err != nil || len(token) != 8+sha256.Size {
+		return ErrInvalidCredentials
+	}
+	expiry, mac := token[:8], token[8:]
+	if !hmac.Equal(mac, joinTokenMAC(j.secret, node.ID, expiry)) {
+		return ErrInvalidCredentials
+	}
+	expiresAt := time.Unix(int64(binary.BigEndian.Uint64(expiry)), 0)
+	if msgType == data.JOIN && j.now().After(expiresAt) {
+		return fmt.Errorf("%w at %s", ErrJoinTokenExpired, expiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func joinTokenMAC(secret []byte, nodeID string, expiry []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("hyparview-join-token\x00"))
+	mac.Write([]byte(nodeID))
+	mac.Write([]byte{0})
+	mac.Write(expiry)
+	return mac.Sum(nil)
+}
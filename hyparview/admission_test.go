@@ -0,0 +1,108 @@
+package hyparview
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tamararankovic/hyparview/data"
+	"github.com/tamararankovic/hyparview/transport"
+)
+
+func TestClusterSecret(t *testing.T) {
+	node := testNode("a")
+	admission := NewClusterSecret([]byte("secret"))
+	credentials, err := admission.Credentials(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := admission.Admit(data.JOIN, node, credentials); err != nil {
+		t.Errorf("node with the cluster secret rejected: %v", err)
+	}
+	wrongSecret, _ := NewClusterSecret([]byte("other")).Credentials(node)
+	if err := admission.Admit(data.JOIN, node, wrongSecret); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("MAC keyed by another secret admitted with %v", err)
+	}
+	// the MAC is bound to the node identity
+	other := node
+	other.ListenAddress = "m:7000"
+	if err := admission.Admit(data.NEIGHTBOR, other, credentials); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("MAC of another listen address admitted with %v", err)
+	}
+	if err := admission.Admit(data.JOIN, testNode("b"), credentials); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("MAC of another node ID admitted with %v", err)
+	}
+}
+
+func TestJoinTokens(t *testing.T) {
+	secret := []byte("secret")
+	node := testNode("a")
+	token := IssueJoinToken(secret, node.ID, time.Hour)
+	admission := NewJoinTokens(secret, "")
+	if _, err := admission.Credentials(node); err == nil {
+		t.Error("credentials returned without a join token")
+	}
+	credentials, err := NewJoinTokens(secret, token).Credentials(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := admission.Admit(data.JOIN, node, credentials); err != nil {
+		t.Errorf("node with a valid token rejected: %v", err)
+	}
+	if err := admission.Admit(data.JOIN, testNode("b"), credentials); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("token of another node admitted with %v", err)
+	}
+	forged := []byte(IssueJoinToken([]byte("other"), node.ID, time.Hour))
+	if err := admission.Admit(data.JOIN, node, forged); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("token with a wrong MAC admitted with %v", err)
+	}
+	if err := admission.Admit(data.JOIN, node, []byte("garbage")); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("malformed token admitted with %v", err)
+	}
+	// the expiry only applies to the joins
+	admission.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if err := admission.Admit(data.JOIN, node, credentials); !errors.Is(err, ErrJoinTokenExpired) {
+		t.Errorf("expired token admitted with %v", err)
+	}
+	if err := admission.Admit(data.NEIGHTBOR, node, credentials); err != nil {
+		t.Errorf("expired token rejected for a neighbor msg: %v", err)
+	}
+}
+
+func TestJoinAccepted(t *testing.T) {
+	network := transport.NewMemNetwork(transport.DefaultConnConfig())
+	secret := []byte("secret")
+	a := newMemNetworkTestNode(t, network, "a", WithAdmission(NewClusterSecret(secret)))
+	b := newMemNetworkTestNode(t, network, "b", WithAdmission(NewClusterSecret(secret)))
+	if err := a.SetMetadata(map[string]string{"zone": "eu-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Join("a"); err != nil {
+		t.Fatal(err)
+	}
+	peers := b.GetPeers()
+	if len(peers) != 1 || peers[0].Node().ID != "a" {
+		t.Fatalf("b active view %v, want the contact node", peers)
+	}
+	if zone := peers[0].Node().Metadata["zone"]; zone != "eu-1" {
+		t.Errorf("contact node zone %q, want eu-1 from the join reply", zone)
+	}
+}
+
+func TestJoinRejected(t *testing.T) {
+	network := transport.NewMemNetwork(transport.DefaultConnConfig())
+	secret := []byte("secret")
+	a := newMemNetworkTestNode(t, network, "a", WithAdmission(NewJoinTokens(secret, "")))
+	expired := NewJoinTokens(secret, IssueJoinToken(secret, "b", -time.Minute))
+	b := newMemNetworkTestNode(t, network, "b", WithAdmission(expired))
+	err := b.Join("a")
+	if !errors.Is(err, ErrJoinRejected) {
+		t.Fatalf("join with an expired token returned %v, want %v", err, ErrJoinRejected)
+	}
+	if peers := b.GetPeers(); len(peers) != 0 {
+		t.Errorf("b active view %v after the rejected join, want it empty", peers)
+	}
+	if peers := a.GetPeers(); len(peers) != 0 {
+		t.Errorf("a active view %v after rejecting the join, want it empty", peers)
+	}
+}
@@ -1,18 +1,232 @@
 package hyparview
 
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/tamararankovic/hyparview/data"
+	"github.com/tamararankovic/hyparview/identity"
+)
+
 type HyParViewConfig struct {
 	Fanout,
 	PassiveViewSize,
 	ARWL,
 	PRWL,
-	ShuffleInterval,
 	Ka,
 	Kp int
+	ShuffleInterval time.Duration
+	// PeerRateLimit limits all the msgs received from a single peer,
+	// MsgRateLimits additionally limit the msgs of a type received from a single peer.
+	PeerRateLimit RateLimit
+	MsgRateLimits map[data.MessageType]RateLimit
+	// MaxPassivePerSource and MaxPassivePerPrefix cap the passive view entries learned
+	// from a single node and the ones sharing an address prefix, zero disables the caps.
+	// The prefix lengths default to /24 for IPv4 and /48 for IPv6 addresses.
+	MaxPassivePerSource,
+	MaxPassivePerPrefix,
+	PrefixLengthV4,
+	PrefixLengthV6 int
+	// PreferDiverseOrigins evicts the entries learned from the most represented origin
+	// instead of random ones when room has to be made in the passive view.
+	PreferDiverseOrigins bool
+	// RemotePeers is the number of active view slots kept for the peers that are not local,
+	// the rest is kept for the local ones, zero disables the locality policy.
+	// The peers sharing the LocalityKey metadata value with the node are local
+	// unless WithLocality sets another locality, a positive RemotePeers needs one of them.
+	RemotePeers int
+	LocalityKey string
+}
+
+// RateLimit is a token bucket refilled with Rate tokens per second
+// holding up to Burst tokens. A zero Rate disables the limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
 }
 
 type Config struct {
 	NodeID,
 	ListenAddress,
 	ContactNodeAddress string
+	// AdminAddress and MetricsAddress are the HTTP addresses
+	// the admin API and the metrics are served on, empty to disable them
+	AdminAddress,
+	MetricsAddress string
+	// AdminToken is the bearer token the admin API requires, the admin address
+	// is advertised in the metadata only if it is set
+	AdminToken string
+	// Transport is the network the node listens on and dials, tcp or unix,
+	// the listen addresses of unix nodes are socket paths
+	Transport string
+	// FaultInjection lets the admin API inject transport failures, for testing only
+	FaultInjection bool
+	// MembershipFile is the file the view membership is persisted to, empty to disable it,
+	// MembershipPersistInterval limits the saves to one per interval, zero saves every change
+	MembershipFile            string
+	MembershipPersistInterval time.Duration
+	// Metadata is advertised to the other nodes along with the node info, e.g. the zone of the node
+	Metadata map[string]string
+	// IdentityFile holds the private key of the node, generated if missing. The node ID
+	// is derived from the key and the msgs and the handshakes are signed with it.
+	IdentityFile string
+	// ClusterSecret admits only the nodes knowing it into the overlay, empty to admit all.
+	// It keeps misconfigured nodes out but authenticates no one, see IdentityFile for that.
+	ClusterSecret string
 	HyParViewConfig
 }
+
+// DefaultConfig derives the parameters recommended by the HyParView paper
+// for a cluster of the expected size: the active view holds log(n)+c nodes
+// and the passive view k·(log(n)+c) nodes with c=1 and k=6,
+// which gives the paper's 5 and 30 for 10 000 nodes.
+func DefaultConfig(expectedClusterSize int) HyParViewConfig {
+	activeViewSize := int(math.Ceil(math.Log10(float64(max(expectedClusterSize, 2))))) + 1
+	passiveViewSize := 6 * activeViewSize
+	return HyParViewConfig{
+		Fanout:          activeViewSize - 1,
+		PassiveViewSize: passiveViewSize,
+		ARWL:            6,
+		PRWL:            3,
+		Ka:              min(3, activeViewSize),
+		Kp:              min(4, passiveViewSize),
+		ShuffleInterval: 10 * time.Second,
+	}
+}
+
+// Validate returns all the problems with the config joined into a single error.
+func (c HyParViewConfig) Validate() error {
+	var errs []error
+	if c.Fanout < 1 {
+		errs = append(errs, fmt.Errorf("Fanout must be at least 1, got %d", c.Fanout))
+	}
+	if c.PassiveViewSize < 1 {
+		errs = append(errs, fmt.Errorf("PassiveViewSize must be at least 1, got %d", c.PassiveViewSize))
+	}
+	if c.ARWL < 1 {
+		errs = append(errs, fmt.Errorf("ARWL must be at least 1, got %d", c.ARWL))
+	}
+	if c.PRWL < 1 || c.PRWL > c.ARWL {
+		errs = append(errs, fmt.Errorf("PRWL must be between 1 and ARWL (%d), got %d", c.ARWL, c.PRWL))
+	}
+	if c.Ka < 0 || c.Ka > c.ActiveViewSize() {
+		errs = append(errs, fmt.Errorf("Ka must be between 0 and the active view size (%d), got %d", c.ActiveViewSize(), c.Ka))
+	}
+	if c.Kp < 0 || c.Kp > c.PassiveViewSize {
+		errs = append(errs, fmt.Errorf("Kp must be between 0 and PassiveViewSize (%d), got %d", c.PassiveViewSize, c.Kp))
+	}
+	if c.ShuffleInterval <= 0 {
+		errs = append(errs, fmt.Errorf("ShuffleInterval must be positive, got %s", c.ShuffleInterval))
+	}
+	if err := c.PeerRateLimit.validate(); err != nil {
+		errs = append(errs, fmt.Errorf("PeerRateLimit: %w", err))
+	}
+	for msgType, limit := range c.MsgRateLimits {
+		if err := limit.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("MsgRateLimits[%s]: %w", msgType, err))
+		}
+	}
+	if c.MaxPassivePerSource < 0 {
+		errs = append(errs, fmt.Errorf("MaxPassivePerSource must not be negative, got %d", c.MaxPassivePerSource))
+	}
+	if c.MaxPassivePerPrefix < 0 {
+		errs = append(errs, fmt.Errorf("MaxPassivePerPrefix must not be negative, got %d", c.MaxPassivePerPrefix))
+	}
+	if c.PrefixLengthV4 < 0 || c.PrefixLengthV4 > 32 {
+		errs = append(errs, fmt.Errorf("PrefixLengthV4 must be between 0 and 32, got %d", c.PrefixLengthV4))
+	}
+	if c.PrefixLengthV6 < 0 || c.PrefixLengthV6 > 128 {
+		errs = append(errs, fmt.Errorf("PrefixLengthV6 must be between 0 and 128, got %d", c.PrefixLengthV6))
+	}
+	if c.RemotePeers < 0 || c.RemotePeers > c.ActiveViewSize() {
+		errs = append(errs, fmt.Errorf("RemotePeers must be between 0 and the active view size (%d), got %d", c.ActiveViewSize(), c.RemotePeers))
+	}
+	return errors.Join(errs...)
+}
+
+// ActiveViewSize returns the capacity of the active view, the fanout plus one.
+func (c HyParViewConfig) ActiveViewSize() int {
+	return c.Fanout + 1
+}
+
+func (c Config) Validate() error {
+	var errs []error
+	if c.NodeID == "" && c.IdentityFile == "" {
+		errs = append(errs, errors.New("NodeID must not be empty unless it is derived from the IdentityFile"))
+	}
+	if c.ListenAddress == "" {
+		errs = append(errs, errors.New("ListenAddress must not be empty"))
+	}
+	if c.Transport != "tcp" && c.Transport != "unix" {
+		errs = append(errs, fmt.Errorf("Transport must be tcp or unix, got %q", c.Transport))
+	}
+	if c.MembershipPersistInterval < 0 {
+		errs = append(errs, fmt.Errorf("MembershipPersistInterval must not be negative, got %s", c.MembershipPersistInterval))
+	}
+	// the node runs the metadata locality only
+	if c.RemotePeers > 0 && c.LocalityKey == "" {
+		errs = append(errs, fmt.Errorf("RemotePeers %d needs a LocalityKey", c.RemotePeers))
+	}
+	if err := c.HyParViewConfig.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (l RateLimit) validate() error {
+	if l.Rate < 0 {
+		return fmt.Errorf("Rate must not be negative, got %f", l.Rate)
+	}
+	if l.Rate > 0 && l.Burst < 1 {
+		return fmt.Errorf("Burst must be at least 1 when the limit is enabled, got %d", l.Burst)
+	}
+	return nil
+}
+
+// Option configures the optional components of HyParView.
+type Option func(h *HyParView)
+
+// WithIdentity makes the node sign all the msgs it issues and reject
+// the msgs that are unsigned or not signed by the node they claim to come from.
+// The node ID has to be derived from the identity.
+func WithIdentity(id identity.Identity) Option {
+	return func(h *HyParView) {
+		h.identity = &id
+	}
+}
+
+// WithAdmission makes the node present its credentials when joining
+// and admit only the nodes approved by the admission.
+func WithAdmission(admission Admission) Option {
+	return func(h *HyParView) {
+		h.admission = admission
+	}
+}
+
+// WithMembershipStore persists the view membership to the store and restores it on start,
+// Join then tries the most recently seen restored nodes before the contact node. The membership is saved
+// right after every change or, with a positive interval, at most once per interval.
+func WithMembershipStore(store MembershipStore, interval time.Duration) Option {
+	return func(h *HyParView) {
+		h.membership = newMembershipPersister(store, interval)
+	}
+}
+
+// WithLocality makes the active view policy tell the local peers from the remote ones
+// by the locality instead of the LocalityKey metadata, e.g. by a latency estimate.
+// The policy is only applied with a positive RemotePeers.
+func WithLocality(locality Locality) Option {
+	return func(h *HyParView) {
+		h.locality = locality
+	}
+}
+
+// WithReputation replaces the default peer reputation tracker,
+// e.g. to share the ban list between components.
+func WithReputation(reputation *Reputation) Option {
+	return func(h *HyParView) {
+		h.reputation = reputation
+	}
+}
@@ -0,0 +1,60 @@
+package hyparview
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultConfig(t *testing.T) {
+	tests := []struct {
+		clusterSize, activeViewSize, passiveViewSize int
+	}{
+		{1, 2, 12},
+		{50, 3, 18},
+		{10000, 5, 30},
+	}
+	for _, tt := range tests {
+		config := DefaultConfig(tt.clusterSize)
+		if err := config.Validate(); err != nil {
+			t.Errorf("DefaultConfig(%d) invalid: %v", tt.clusterSize, err)
+		}
+		if config.Fanout+1 != tt.activeViewSize || config.PassiveViewSize != tt.passiveViewSize {
+			t.Errorf("DefaultConfig(%d) views = %d/%d, want %d/%d", tt.clusterSize, config.Fanout+1, config.PassiveViewSize, tt.activeViewSize, tt.passiveViewSize)
+		}
+	}
+}
+
+func TestNodeConfigValidate(t *testing.T) {
+	config := Config{NodeID: "a", ListenAddress: "a:7000", Transport: "tcp", HyParViewConfig: DefaultConfig(100)}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate() = %v", err)
+	}
+	config.RemotePeers = 1
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "LocalityKey") {
+		t.Errorf("Validate() = %v with RemotePeers and no LocalityKey, want error mentioning LocalityKey", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *HyParViewConfig)
+		errMsg string
+	}{
+		{"zero shuffle interval", func(c *HyParViewConfig) { c.ShuffleInterval = 0 }, "ShuffleInterval"},
+		{"PRWL above ARWL", func(c *HyParViewConfig) { c.PRWL = c.ARWL + 1 }, "PRWL"},
+		{"Kp above passive view size", func(c *HyParViewConfig) { c.Kp = c.PassiveViewSize + 1 }, "Kp"},
+		{"Ka above active view size", func(c *HyParViewConfig) { c.Ka = c.Fanout + 2 }, "Ka"},
+		{"rate limit without burst", func(c *HyParViewConfig) { c.PeerRateLimit = RateLimit{Rate: 10} }, "Burst"},
+		{"negative shuffle interval", func(c *HyParViewConfig) { c.ShuffleInterval = -time.Second }, "ShuffleInterval"},
+	}
+	for _, tt := range tests {
+		config := DefaultConfig(100)
+		tt.modify(&config)
+		err := config.Validate()
+		if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+			t.Errorf("%s: Validate() = %v, want error mentioning %s", tt.name, err, tt.errMsg)
+		}
+	}
+}
@@ -1,171 +1,641 @@
 package hyparview
 
 import (
+	"errors"
+	"fmt"
 	"log"
-	"math"
+	"maps"
 	"math/rand"
+	"net"
 	"slices"
+	"sync"
 	"time"
 
 	"github.com/tamararankovic/hyparview/data"
+	"github.com/tamararankovic/hyparview/identity"
 	"github.com/tamararankovic/hyparview/transport"
 )
 
+var (
+	ErrNoActivePeers = errors.New("no peers in the active view")
+	ErrNoContactNode = errors.New("no contact node to join through")
+	ErrJoinRejected  = errors.New("join rejected")
+	// ErrProtocolViolation marks the handler errors that lower the reputation of the sender
+	ErrProtocolViolation = errors.New("protocol violation")
+)
+
+const (
+	// joinTimeout bounds the wait for the contact node to accept or reject the join
+	joinTimeout = 5 * time.Second
+	// maxRestoredJoins bounds the restored nodes Join tries before the contact node,
+	// each attempt may take up to the join timeout
+	maxRestoredJoins = 3
+	// rebalanceTimeout bounds the wait for the reply to a rebalancing neighbor msg
+	rebalanceTimeout = 5 * time.Second
+)
+
 type HyParView struct {
 	self        data.Node
 	config      HyParViewConfig
-	activeView  []Peer
-	passiveView []Peer
-	connManager transport.ConnManager
-	peerUp      chan Peer
-	peerDown    chan Peer
+	activeView  *view
+	passiveView *PassiveView
+	connManager *transport.ConnManager
+	identity    *identity.Identity
+	admission   Admission
+	reputation  *Reputation
+	rateLimiter *rateLimiter
+	metrics     *metrics
+	membership  *membershipPersister
+	// locality replaces the metadata based locality of the active view policy if set
+	locality Locality
+	// restored holds the persisted nodes Join tries first, the most recently seen first
+	restored []data.Node
+	// joins holds the outcomes awaited by the joins in progress, indexed by the conn to the contact node
+	joins map[transport.Conn]chan error
+	// rebalancing holds the deadlines of the rebalancing neighbor msgs awaiting a reply, indexed by node ID
+	rebalancing map[string]time.Time
+	// lock guards the views, the config and the restored nodes. The msg handlers,
+	// the shuffles, the peer replacements and the API calls hold it while they run,
+	// nothing is published to the subscribers and no node is dialed while it is held.
+	lock sync.Mutex
+	// dials counts the dials running in the background
+	dials sync.WaitGroup
+	// metadataLock guards the metadata of self updated at runtime
+	metadataLock sync.Mutex
+	// shuffleInterval passes the updated interval to the shuffle loop
+	shuffleInterval chan time.Duration
+	// left is closed once the node leaves the overlay
+	left        chan struct{}
+	leaveOnce   sync.Once
+	peerUp      *transport.Broadcast[Peer]
+	peerDown    *transport.Broadcast[Peer]
 	msgHandlers map[data.MessageType]func(received transport.MsgReceived) error
 }
 
-func NewHyParView(config HyParViewConfig, self data.Node, connManager transport.ConnManager) (HyParView, error) {
-	hv := HyParView{
+func NewHyParView(config HyParViewConfig, self data.Node, connManager *transport.ConnManager, opts ...Option) (*HyParView, error) {
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	if err := checkMetadata(data.Node{ID: self.ID, Metadata: self.Metadata}); err != nil {
+		return nil, err
+	}
+	hv := &HyParView{
 		self:        self,
 		config:      config,
-		activeView:  make([]Peer, 0),
-		passiveView: make([]Peer, 0),
-		peerUp:      make(chan Peer),
-		peerDown:    make(chan Peer),
+		activeView:  newView(),
+		passiveView: NewPassiveView(self.ID, config),
+		peerUp:      transport.NewBroadcast[Peer](),
+		peerDown:    transport.NewBroadcast[Peer](),
 		connManager: connManager,
+		reputation:  NewReputation(DefaultReputationConfig()),
+		rateLimiter: newRateLimiter(config.PeerRateLimit, config.MsgRateLimits),
+		metrics:     newMetrics(),
+		// buffered so that the update does not wait for an ongoing shuffle
+		shuffleInterval: make(chan time.Duration, 1),
+		left:            make(chan struct{}),
+		joins:           make(map[transport.Conn]chan error),
+		rebalancing:     make(map[string]time.Time),
+	}
+	hv.self.Metadata = maps.Clone(self.Metadata)
+	hv.self.MetadataVersion = nextMetadataVersion(self.MetadataVersion)
+	for _, opt := range opts {
+		opt(hv)
+	}
+	if err := hv.checkLocality(config); err != nil {
+		return nil, err
+	}
+	if hv.identity != nil && hv.identity.NodeID() != self.ID {
+		return nil, fmt.Errorf("node ID %s not derived from the identity, expected %s", self.ID, hv.identity.NodeID())
+	}
+	if hv.identity != nil {
+		connManager.SetAuthenticator(*hv.identity)
+	}
+	if hv.membership != nil {
+		if err := hv.restoreMembership(); err != nil {
+			log.Printf("failed to restore the view membership: %v\n", err)
+		}
+		hv.activeView.onChange = hv.viewsChanged
+		hv.passiveView.peers.onChange = hv.viewsChanged
+		go hv.membership.run(hv.left)
 	}
 	hv.msgHandlers = map[data.MessageType]func(received transport.MsgReceived) error{
 		data.JOIN:            hv.onJoin,
+		data.JOIN_REPLY:      hv.onJoinReply,
 		data.DISCONNECT:      hv.onDisconnect,
 		data.FORWARD_JOIN:    hv.onForwardJoin,
 		data.NEIGHTBOR:       hv.onNeighbor,
 		data.NEIGHTBOR_REPLY: hv.onNeighborReply,
 		data.SHUFFLE:         hv.onShuffle,
 		data.SHUFFLE_REPLY:   hv.onShuffleReply,
+		data.REJECT:          hv.onReject,
 	}
+	_ = connManager.OnReceive(hv.onReeive)
+	// the links are reported up while the lock is held, so the event is handled aside
+	_ = connManager.OnConnUp(func(conn transport.Conn) {
+		go hv.onConnUp(conn)
+	})
+	_ = connManager.OnConnDown(hv.onConnDown)
+	_ = connManager.OnMalformedMsg(hv.onMalformedMsg)
 	err := connManager.StartAcceptingConns()
 	go hv.shuffle()
 	return hv, err
 }
 
+// Join joins the overlay through the first reachable node among the most recently seen ones
+// restored from the membership store or, if there is none, through the contact node. An empty contact address
+// makes the node join only through the restored nodes.
 func (h *HyParView) Join(contactNodeAddress string) error {
+	h.lock.Lock()
+	restored := slices.Clone(h.restored[:min(len(h.restored), maxRestoredJoins)])
+	h.lock.Unlock()
+	var errs []error
+	for _, node := range restored {
+		err := h.join(node.ListenAddress)
+		if err == nil {
+			log.Printf("joined through the restored node %s\n", node.ID)
+			h.clearRestored()
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("restored node %s: %w", node.ID, err))
+	}
+	if contactNodeAddress == "" {
+		return errors.Join(append(errs, ErrNoContactNode)...)
+	}
+	err := h.join(contactNodeAddress)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	h.clearRestored()
+	return nil
+}
+
+func (h *HyParView) clearRestored() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.restored = nil
+}
+
+// join sends the join msg to the contact node and waits for it to be accepted or rejected,
+// the contact node is added to the active view once it accepts the join.
+func (h *HyParView) join(contactNodeAddress string) error {
 	conn, err := h.connManager.Connect(contactNodeAddress)
 	if err != nil {
 		return err
 	}
-	_ = h.connManager.OnReceive(h.onReeive)
+	if h.connBanned(conn) {
+		_ = h.connManager.Disconnect(conn)
+		return fmt.Errorf("contact node %s is banned", conn.GetRemoteNode().ID)
+	}
+	self := h.selfNode()
 	msg := data.Message{
 		Type: data.JOIN,
 		Payload: data.Join{
-			NodeID: h.self.ID,
+			NodeID:          self.ID,
+			ListenAddress:   self.ListenAddress,
+			Metadata:        self.Metadata,
+			MetadataVersion: self.MetadataVersion,
+			Credentials:     h.credentials(),
 		},
 	}
-	return conn.Send(msg)
+	outcome := make(chan error, 1)
+	h.lock.Lock()
+	h.joins[conn] = outcome
+	h.lock.Unlock()
+	err = h.send(conn, msg)
+	if err == nil {
+		select {
+		case err = <-outcome:
+		case <-time.After(joinTimeout):
+			err = fmt.Errorf("contact node %s did not reply to the join", conn.GetRemoteNode().ID)
+		}
+	}
+	h.lock.Lock()
+	delete(h.joins, conn)
+	// the outcome may have been decided while the wait timed out
+	select {
+	case err = <-outcome:
+	default:
+	}
+	accepted := err == nil || h.getPeer(conn) != nil
+	h.lock.Unlock()
+	if !accepted {
+		_ = h.connManager.Disconnect(conn)
+		return err
+	}
+	return nil
 }
 
-func (h *HyParView) GetPeers() []Peer {
-	return h.activeView
+// DisconnectPeer removes the node from the active view and closes the link to it,
+// the freed slot is filled from the passive view.
+func (h *HyParView) DisconnectPeer(nodeID string) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	peer := h.activeView.get(nodeID)
+	if peer == nil {
+		return fmt.Errorf("node %s not in the active view", nodeID)
+	}
+	err := h.disconnectPeer(*peer)
+	h.replacePeer([]string{nodeID})
+	return err
 }
 
-func (h *HyParView) OnPeerUp(handler func(peer Peer)) transport.Subscription {
-	return h.connManager.OnConnUp(func(conn transport.Conn) {
-		if peer := h.getPeer(conn); peer != nil {
-			handler(*peer)
+// Left is closed once the node leaves the overlay.
+func (h *HyParView) Left() <-chan struct{} {
+	return h.left
+}
+
+// Leave tells the active peers the node is leaving the overlay, stops the shuffles
+// and closes all the conns. The node can not rejoin after it left.
+func (h *HyParView) Leave() {
+	h.leave(true)
+}
+
+// Stop closes all the conns without telling the active peers,
+// the way the node disappears when it crashes.
+func (h *HyParView) Stop() {
+	h.leave(false)
+}
+
+func (h *HyParView) leave(graceful bool) {
+	h.leaveOnce.Do(func() {
+		h.lock.Lock()
+		close(h.left)
+		if graceful {
+			disconnectMsg := data.Message{
+				Type: data.DISCONNECT,
+				Payload: data.Disconnect{
+					NodeID: h.self.ID,
+				},
+			}
+			for _, peer := range h.activeView.peers {
+				err := h.send(peer.conn, disconnectMsg)
+				if err != nil {
+					log.Println(err)
+				}
+			}
+		}
+		h.activeView.clear()
+		h.lock.Unlock()
+		// the conns flush the queued msgs without holding up the msg handlers
+		h.connManager.Close()
+		if graceful {
+			log.Printf("node %s left the overlay\n", h.self.ID)
+		} else {
+			log.Printf("node %s stopped\n", h.self.ID)
 		}
 	})
 }
 
+func (h *HyParView) hasLeft() bool {
+	select {
+	case <-h.left:
+		return true
+	default:
+		return false
+	}
+}
+
+func (h *HyParView) Self() data.Node {
+	return h.selfNode()
+}
+
+func (h *HyParView) GetPeers() []Peer {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.activeView.list()
+}
+
+func (h *HyParView) GetPassivePeers() []Peer {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.passiveView.Peers()
+}
+
+// QueueDepths returns the send queue depth of every conn indexed by the remote node ID.
+func (h *HyParView) QueueDepths() map[string]int {
+	return h.connManager.QueueDepths()
+}
+
+// Metrics returns the protocol event counters indexed by name and label.
+func (h *HyParView) Metrics() map[string]map[string]uint64 {
+	return h.metrics.snapshot()
+}
+
+// Ban bans the node for the duration and removes it from the views,
+// a "host:" prefixed key, e.g. host:10.0.0.1, bans all the nodes on the host.
+func (h *HyParView) Ban(nodeID string, duration time.Duration) {
+	h.reputation.Ban(nodeID, duration)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.evict(nodeID)
+}
+
+func (h *HyParView) Unban(nodeID string) {
+	h.reputation.Unban(nodeID)
+}
+
+// Bans returns the ban expiry times of the currently banned nodes.
+func (h *HyParView) Bans() map[string]time.Time {
+	return h.reputation.Bans()
+}
+
+func (h *HyParView) OnPeerUp(handler func(peer Peer)) transport.Subscription {
+	return h.peerUp.Subscribe(handler)
+}
+
 func (h *HyParView) OnPeerDown(handler func(peer Peer)) transport.Subscription {
-	return h.connManager.OnConnDown(func(conn transport.Conn) {
-		if peer := h.getPeer(conn); peer != nil {
-			h.deletePeer(*peer)
-			go h.replacePeer([]string{})
-			go handler(*peer)
-		}
-	})
+	return h.peerDown.Subscribe(handler)
+}
+
+func (h *HyParView) onConnUp(conn transport.Conn) {
+	h.lock.Lock()
+	peer := h.getPeer(conn)
+	var up Peer
+	if peer != nil {
+		up = *peer
+	}
+	h.lock.Unlock()
+	if peer != nil {
+		h.peerUp.Publish(up)
+	}
+}
+
+// onConnDown replaces the active peer whose link failed.
+func (h *HyParView) onConnDown(conn transport.Conn) {
+	h.lock.Lock()
+	// a join waiting for the reply on the conn fails right away instead of timing out
+	if outcome, ok := h.joins[conn]; ok {
+		delete(h.joins, conn)
+		outcome <- fmt.Errorf("conn to contact node %s closed", conn.GetRemoteNode().ID)
+	}
+	peer := h.getPeer(conn)
+	if peer == nil {
+		h.lock.Unlock()
+		return
+	}
+	failed := *peer
+	h.deletePeer(failed)
+	h.replacePeer([]string{failed.node.ID})
+	h.lock.Unlock()
+	h.peerDown.Publish(failed)
 }
 
 func (h *HyParView) onReeive(received transport.MsgReceived) {
+	if h.hasLeft() {
+		return
+	}
+	senderID := received.Sender.GetRemoteNode().ID
+	if h.connBanned(received.Sender) {
+		log.Printf("msg from banned node %s dropped\n", senderID)
+		_ = h.connManager.Disconnect(received.Sender)
+		return
+	}
+	if !h.rateLimiter.allow(senderID, received.Msg.Type) {
+		log.Printf("msg %s from node %s dropped, rate limit exceeded\n", received.Msg.Type, senderID)
+		h.metrics.inc(metricRateLimited, received.Msg.Type.String())
+		h.lock.Lock()
+		h.penalize(h.offender(received.Sender), RateViolation)
+		h.lock.Unlock()
+		return
+	}
 	handler := h.msgHandlers[received.Msg.Type]
 	if handler == nil {
 		log.Printf("no handler found for message type %s", received.Msg.Type)
 		return
 	}
+	if h.identity != nil {
+		err := h.verify(received)
+		if err != nil {
+			log.Printf("msg from %s rejected: %v\n", received.Sender.GetAddress(), err)
+			return
+		}
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	// the views are cleared once the node leaves and stay empty
+	if h.hasLeft() {
+		return
+	}
 	err := handler(received)
 	if err != nil {
 		log.Println(err)
 	}
+	if errors.Is(err, ErrProtocolViolation) {
+		h.penalize(h.offender(received.Sender), ProtocolViolation)
+	}
+}
+
+func (h *HyParView) onMalformedMsg(msg transport.MalformedMsg) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.penalize(h.offender(msg.Sender), MalformedMsg)
+}
+
+// offender returns the key the misbehavior of the remote node of the conn is recorded under.
+// The node ID is only trusted if the identity authenticated it in the handshake, otherwise
+// any node could get another one banned by claiming its ID, so the remote host is penalized instead.
+func (h *HyParView) offender(conn transport.Conn) string {
+	if h.identity != nil {
+		return conn.GetRemoteNode().ID
+	}
+	return hostKey(conn.GetAddress())
 }
 
-func (h *HyParView) disconnectRandomPeer() error {
-	disconnectPeer := h.selectRandomPeer([]string{})
+// hostKey returns the reputation key of the host of the address.
+func hostKey(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	return "host:" + host
+}
+
+// banned reports whether the node or the host it listens on is banned.
+func (h *HyParView) banned(node data.Node) bool {
+	return h.reputation.IsBanned(node.ID) || h.reputation.IsBanned(hostKey(node.ListenAddress))
+}
+
+// connBanned reports whether the remote node of the conn or the host it comes from is banned.
+func (h *HyParView) connBanned(conn transport.Conn) bool {
+	return h.reputation.IsBanned(conn.GetRemoteNode().ID) || h.reputation.IsBanned(hostKey(conn.GetAddress()))
+}
+
+// penalize lowers the reputation of the offender and evicts it if it got banned.
+func (h *HyParView) penalize(offender string, misbehavior Misbehavior) {
+	if h.reputation.Penalize(offender, misbehavior) {
+		h.evict(offender)
+	}
+}
+
+// evict removes the banned node, or the nodes on the banned host, from the views and closes the links to them.
+func (h *HyParView) evict(key string) {
+	for _, peer := range h.passiveView.Peers() {
+		if peer.node.ID == key || hostKey(peer.node.ListenAddress) == key {
+			h.passiveView.Remove(peer.node.ID)
+		}
+	}
+	for _, peer := range h.activeView.list() {
+		if peer.node.ID != key && hostKey(peer.node.ListenAddress) != key && hostKey(peer.conn.GetAddress()) != key {
+			continue
+		}
+		h.deletePeer(peer)
+		err := h.connManager.Disconnect(peer.conn)
+		if err != nil {
+			log.Println(err)
+		}
+		h.replacePeer([]string{peer.node.ID})
+	}
+}
+
+// send signs the msg issued by this node if an identity is configured and sends it.
+// Msgs forwarded on behalf of other nodes are sent directly over the conn.
+func (h *HyParView) send(conn transport.Conn, msg data.Message) error {
+	if h.identity != nil {
+		var err error
+		msg, err = h.identity.Sign(msg)
+		if err != nil {
+			return err
+		}
+	}
+	return conn.Send(msg)
+}
+
+// verify checks the msg signature and that the msgs
+// not forwarded by other nodes come from their signer.
+func (h *HyParView) verify(received transport.MsgReceived) error {
+	err := identity.Verify(received.Msg)
+	if err != nil {
+		return err
+	}
+	if received.Msg.Type == data.FORWARD_JOIN || received.Msg.Type == data.SHUFFLE {
+		return nil
+	}
+	signer, err := identity.Signer(received.Msg)
+	if err != nil {
+		return err
+	}
+	if signer != received.Sender.GetRemoteNode().ID {
+		return fmt.Errorf("msg signed by %s received from %s", signer, received.Sender.GetRemoteNode().ID)
+	}
+	return nil
+}
+
+// disconnectRandomPeer makes room in the active view for the incoming node, nil if none.
+// With the locality policy the peer dropped keeps the mix of the active view on target.
+func (h *HyParView) disconnectRandomPeer(incoming *data.Node) error {
+	var disconnectPeer *Peer
+	if filter := h.evictionFilter(incoming); filter != nil {
+		disconnectPeer = h.selectRandomWhere(h.activeView, nil, filter)
+	}
+	if disconnectPeer == nil {
+		disconnectPeer = h.selectRandomPeer([]string{})
+	}
 	if disconnectPeer == nil {
 		return nil
 	}
-	h.deletePeer(*disconnectPeer)
+	return h.disconnectPeer(*disconnectPeer)
+}
+
+// disconnectPeer moves the peer from the active to the passive view,
+// tells it about the removal and closes the link.
+func (h *HyParView) disconnectPeer(peer Peer) error {
+	h.deletePeer(peer)
+	h.passiveView.Demote(peer.node)
 	disconnectMsg := data.Message{
 		Type: data.DISCONNECT,
 		Payload: data.Disconnect{
 			NodeID: h.self.ID,
 		},
 	}
-	err := disconnectPeer.conn.Send(disconnectMsg)
+	err := h.send(peer.conn, disconnectMsg)
 	if err != nil {
 		return err
 	}
-	err = h.connManager.Disconnect(disconnectPeer.conn)
+	err = h.connManager.Disconnect(peer.conn)
 	if err != nil {
 		log.Println(err)
 	}
 	return nil
 }
 
-func (h *HyParView) getPeer(conn transport.Conn) *Peer {
-	index := slices.IndexFunc(h.activeView, func(peer Peer) bool {
-		return peer.conn.GetAddress() == conn.GetAddress()
-	})
-	if index < 0 {
+func (h *HyParView) credentials() []byte {
+	if h.admission == nil {
 		return nil
 	}
-	return &h.activeView[index]
+	credentials, err := h.admission.Credentials(h.self)
+	if err != nil {
+		log.Println(err)
+	}
+	return credentials
 }
 
-func (h *HyParView) getPeerCandidate(id string) *Peer {
-	index := slices.IndexFunc(h.passiveView, func(peer Peer) bool {
-		return peer.node.ID == id
-	})
-	if index < 0 {
+func (h *HyParView) admit(msgType data.MessageType, node data.Node, credentials []byte) error {
+	if err := checkMetadata(node); err != nil {
+		return err
+	}
+	if h.admission == nil {
 		return nil
 	}
-	return &h.passiveView[index]
+	return h.admission.Admit(msgType, node, credentials)
 }
 
-func (h *HyParView) deletePeer(peer Peer) {
-	h.delete(peer, h.activeView)
-}
-
-func (h *HyParView) deletePeerCandidate(peer Peer) {
-	h.delete(peer, h.passiveView)
+// reject tells the node its msg was refused and closes the conn.
+func (h *HyParView) reject(conn transport.Conn, msgType data.MessageType, reason error) error {
+	log.Printf("node %s rejected: %v\n", conn.GetRemoteNode().ID, reason)
+	rejectMsg := data.Message{
+		Type: data.REJECT,
+		Payload: data.Reject{
+			NodeID:  h.self.ID,
+			MsgType: msgType,
+			Reason:  reason.Error(),
+		},
+	}
+	err := h.send(conn, rejectMsg)
+	if err != nil {
+		log.Println(err)
+	}
+	return h.connManager.Disconnect(conn)
 }
 
-func (h *HyParView) delete(peer Peer, peers []Peer) {
-	index := slices.IndexFunc(peers, func(p Peer) bool {
-		return p.node.ID == peer.node.ID
-	})
-	if index < 0 {
+func (h *HyParView) addPeer(peer Peer) {
+	h.deletePeerCandidate(peer)
+	h.activeView.add(peer)
+	log.Printf("peer [ID=%s, address=%s] added to active view\n", peer.node.ID, peer.conn.GetAddress())
+	err := h.connManager.Link(peer.conn)
+	if errors.Is(err, transport.ErrConnClosed) {
+		// no conn down is reported for the conn that closed before it was linked
+		log.Printf("conn to %s closed before it was linked\n", peer.node.ID)
+		h.deletePeer(peer)
+		h.replacePeer([]string{peer.node.ID})
 		return
 	}
-	peers = slices.Delete(peers, index, index+1)
+	if err != nil {
+		log.Println(err)
+	}
 }
 
-func (h *HyParView) activeViewSize() int {
-	return h.config.Fanout + 1
+func (h *HyParView) getPeer(conn transport.Conn) *Peer {
+	return h.activeView.get(conn.GetRemoteNode().ID)
 }
 
-func (h *HyParView) activeViewFull() bool {
-	return len(h.activeView) == h.activeViewSize()
+func (h *HyParView) getPeerCandidate(id string) *Peer {
+	return h.passiveView.get(id)
+}
+
+func (h *HyParView) deletePeer(peer Peer) {
+	h.activeView.remove(peer.node.ID)
+}
+
+func (h *HyParView) deletePeerCandidate(peer Peer) {
+	h.passiveView.Remove(peer.node.ID)
 }
 
-func (h *HyParView) passiveViewFull() bool {
-	return len(h.passiveView) == h.config.PassiveViewSize
+func (h *HyParView) activeViewSize() int {
+	return h.config.ActiveViewSize()
+}
+
+func (h *HyParView) activeViewFull() bool {
+	return h.activeView.size() >= h.activeViewSize()
 }
 
 func (h *HyParView) selectRandomPeer(nodeIdBlacklist []string) *Peer {
@@ -173,116 +643,156 @@ func (h *HyParView) selectRandomPeer(nodeIdBlacklist []string) *Peer {
 }
 
 func (h *HyParView) selectRandomPeerCandidate(nodeIdBlacklist []string) *Peer {
-	return h.selectRandom(h.passiveView, nodeIdBlacklist)
+	return h.selectRandom(h.passiveView.peers, nodeIdBlacklist)
 }
 
-func (h *HyParView) selectRandom(peers []Peer, nodeIdBlacklist []string) *Peer {
-	filteredPeers := make([]Peer, 0)
-	for _, peer := range peers {
-		if !slices.ContainsFunc(nodeIdBlacklist, func(id string) bool { return id == peer.node.ID }) {
-			filteredPeers = append(filteredPeers, peer)
-		}
+func (h *HyParView) selectRandom(peers *view, nodeIdBlacklist []string) *Peer {
+	return h.selectRandomWhere(peers, nodeIdBlacklist, func(peer Peer) bool { return true })
+}
+
+func (h *HyParView) selectRandomWhere(peers *view, nodeIdBlacklist []string, filter func(peer Peer) bool) *Peer {
+	return peers.random(func(peer Peer) bool {
+		return !slices.Contains(nodeIdBlacklist, peer.node.ID) && !h.banned(peer.node) && filter(peer)
+	})
+}
+
+// replacePeer asks a random passive view node to become a neighbor,
+// it returns the ID of the node asked or an empty string. The node is dialed in the background
+// and another one is asked if it can not be reached.
+// With the locality policy the nodes of the class the active view lacks are asked first.
+func (h *HyParView) replacePeer(nodeIdBlacklist []string) string {
+	if h.hasLeft() {
+		return ""
 	}
-	if len(filteredPeers) == 0 {
-		return nil
+	var candidate *Peer
+	if filter := h.candidateFilter(); filter != nil {
+		candidate = h.selectRandomWhere(h.passiveView.peers, nodeIdBlacklist, filter)
+	}
+	if candidate == nil {
+		candidate = h.selectRandomPeerCandidate(nodeIdBlacklist)
 	}
-	index := rand.Intn(len(filteredPeers))
-	return &filteredPeers[index]
+	if candidate == nil {
+		log.Println("no peer candidates to replace the failed peer")
+		return ""
+	}
+	nodeIdBlacklist = slices.Clone(nodeIdBlacklist)
+	h.requestNeighbor(*candidate, func() {
+		h.replacePeer(nodeIdBlacklist)
+	})
+	return candidate.node.ID
 }
 
-func (h *HyParView) replacePeer(nodeIdBlacklist []string) {
-	for {
-		candidate := h.selectRandomPeerCandidate(nodeIdBlacklist)
-		if candidate == nil {
-			log.Println("no peer candidates to replace the failed peer")
-			break
+// requestNeighbor sends a NEIGHBOR to the passive view node once it is dialed,
+// the node is dropped from the passive view and failed is called if it can not be reached.
+func (h *HyParView) requestNeighbor(candidate Peer, failed func()) {
+	h.dial(candidate.node.ListenAddress, func(conn transport.Conn, err error) {
+		if err == nil {
+			self := h.selfNode()
+			neighborMsg := data.Message{
+				Type: data.NEIGHTBOR,
+				Payload: data.Neighbor{
+					NodeID:          self.ID,
+					ListenAddress:   self.ListenAddress,
+					Metadata:        self.Metadata,
+					MetadataVersion: self.MetadataVersion,
+					HighPriority:    h.activeView.size() == 0,
+					Credentials:     h.credentials(),
+				},
+			}
+			err = h.send(conn, neighborMsg)
+			if err != nil {
+				h.connManager.Release(conn)
+			}
 		}
-		conn, err := h.connManager.Connect(candidate.node.ListenAddress)
 		if err != nil {
 			log.Println(err)
-			h.deletePeerCandidate(*candidate)
-			continue
+			h.deletePeerCandidate(candidate)
+			failed()
 		}
-		candidate.conn = conn
-		neighborMsg := data.Message{
-			Type: data.NEIGHTBOR,
-			Payload: data.Neighbor{
-				NodeID:        h.self.ID,
-				ListenAddress: h.self.ListenAddress,
-				HighPriority:  len(h.activeView) == 0,
-			},
-		}
-		err = candidate.conn.Send(neighborMsg)
-		if err != nil {
-			log.Println(err)
-			h.deletePeerCandidate(*candidate)
-			continue
+	})
+}
+
+// dial connects to the address in the background, so a slow or unreachable node holds up
+// neither the msg handlers nor the API calls. The handler is called with the lock held
+// unless the node left meanwhile, the views may have changed during the dial.
+func (h *HyParView) dial(address string, handler func(conn transport.Conn, err error)) {
+	h.dials.Add(1)
+	go func() {
+		defer h.dials.Done()
+		conn, err := h.connManager.ConnectEphemeral(address)
+		h.lock.Lock()
+		defer h.lock.Unlock()
+		if h.hasLeft() {
+			if err == nil {
+				h.connManager.Release(conn)
+			}
+			return
 		}
-		break
-	}
+		handler(conn, err)
+	}()
 }
 
 func (h *HyParView) shuffle() {
-	ticker := time.NewTicker(time.Duration(h.config.ShuffleInterval) * time.Second)
-	for range ticker.C {
-		log.Println("shuffle triggered")
-		activeViewMaxIndex := int(math.Min(float64(h.config.Ka), float64(len(h.activeView))))
-		passiveViewMaxIndex := int(math.Min(float64(h.config.Kp), float64(len(h.passiveView))))
-		peers := append(h.activeView[:activeViewMaxIndex], h.passiveView[:passiveViewMaxIndex]...)
-		nodes := make([]data.Node, len(peers))
-		for i, peer := range peers {
-			nodes[i] = peer.node
-		}
-		shuffleMsg := data.Message{
-			Type: data.SHUFFLE,
-			Payload: data.Shuffle{
-				NodeID:        h.self.ID,
-				ListenAddress: h.self.ListenAddress,
-				Nodes:         nodes,
-				TTL:           h.config.ARWL,
-			},
-		}
-		peer := h.selectRandomPeer([]string{})
-		if peer != nil {
-			log.Println("no peers in active view to perform shuffle")
+	ticker := time.NewTicker(h.config.ShuffleInterval)
+	for {
+		select {
+		case interval := <-h.shuffleInterval:
+			ticker.Reset(interval)
 			continue
+		case <-h.left:
+			ticker.Stop()
+			return
+		case <-ticker.C:
 		}
-		err := peer.conn.Send(shuffleMsg)
+		log.Println("shuffle triggered")
+		h.lock.Lock()
+		err := h.sendShuffle()
 		if err != nil {
 			log.Println(err)
 		}
+		h.rebalance()
+		h.lock.Unlock()
 	}
 }
 
-func (h *HyParView) integrateNodesIntoPartialView(nodes []data.Node, deleteCandidates []data.Node) {
-	nodes = slices.DeleteFunc(nodes, func(node data.Node) bool {
-		return node.ID == h.self.ID || slices.ContainsFunc(append(h.activeView, h.passiveView...), func(peer Peer) bool {
-			return peer.node.ID == node.ID
-		})
-	})
-	discardedCandidates := make([]data.Node, 0)
-	for _, node := range nodes {
-		if h.passiveViewFull() {
-			deleteCandidates = slices.DeleteFunc(deleteCandidates, func(node data.Node) bool {
-				return slices.ContainsFunc(discardedCandidates, func(discarded data.Node) bool {
-					return discarded.ID == node.ID
-				})
-			})
-			passiveViewLen := len(h.passiveView)
-			for _, deleteCandidate := range deleteCandidates {
-				h.passiveView = slices.DeleteFunc(h.passiveView, func(peer Peer) bool {
-					return peer.node.ID == deleteCandidate.ID
-				})
-				discardedCandidates = append(discardedCandidates, deleteCandidate)
-				if len(h.passiveView) < passiveViewLen {
-					break
-				}
-			}
-			if len(h.passiveView) == passiveViewLen {
-				peer := h.selectRandomPeerCandidate([]string{})
-				h.deletePeerCandidate(*peer)
-			}
-		}
-		h.passiveView = append(h.passiveView, Peer{node: node})
+// Shuffle sends a sample of the views to a random active peer
+// without waiting for the shuffle interval.
+func (h *HyParView) Shuffle() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.sendShuffle()
+}
+
+func (h *HyParView) sendShuffle() error {
+	peers := slices.Concat(h.activeView.sample(h.config.Ka, rand.Intn), h.passiveView.sample(h.config.Kp))
+	nodes := make([]data.Node, len(peers))
+	for i, peer := range peers {
+		nodes[i] = peer.node
 	}
+	self := h.selfNode()
+	shuffleMsg := data.Message{
+		Type: data.SHUFFLE,
+		Payload: data.Shuffle{
+			NodeID:          self.ID,
+			ListenAddress:   self.ListenAddress,
+			Metadata:        self.Metadata,
+			MetadataVersion: self.MetadataVersion,
+			Nodes:           nodes,
+			TTL:             h.config.ARWL,
+		},
+	}
+	peer := h.selectRandomPeer([]string{})
+	if peer == nil {
+		return ErrNoActivePeers
+	}
+	return h.send(peer.conn, shuffleMsg)
+}
+
+// integrateNodesIntoPartialView takes the nodes passed on by other nodes into the passive view,
+// their node info does not replace the one of the nodes already known.
+func (h *HyParView) integrateNodesIntoPartialView(nodes []data.Node, deleteCandidates []data.Node, origin string) {
+	nodes = slices.DeleteFunc(slices.Clone(nodes), func(node data.Node) bool {
+		return h.banned(node) || h.activeView.contains(node.ID) || checkMetadata(node) != nil
+	})
+	h.passiveView.Integrate(nodes, deleteCandidates, origin)
 }
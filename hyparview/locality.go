@@ -0,0 +1,170 @@
+package hyparview
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/tamararankovic/hyparview/data"
+)
+
+// Locality reports whether the node is local to self, e.g. runs in the same zone.
+type Locality func(self, node data.Node) bool
+
+// SameMetadata treats the nodes advertising the same non empty metadata value
+// under the key as local, e.g. SameMetadata("zone").
+func SameMetadata(key string) Locality {
+	return func(self, node data.Node) bool {
+		value, ok := self.Metadata[key]
+		return ok && value != "" && node.Metadata[key] == value
+	}
+}
+
+// LatencyBelow treats the nodes estimated to be closer than the threshold as local,
+// the nodes the estimate knows nothing about are remote.
+func LatencyBelow(estimate func(node data.Node) (time.Duration, bool), threshold time.Duration) Locality {
+	return func(self, node data.Node) bool {
+		latency, ok := estimate(node)
+		return ok && latency < threshold
+	}
+}
+
+// localityPolicy returns the locality the active view mix is kept by,
+// nil if the policy is disabled.
+func (h *HyParView) localityPolicy() Locality {
+	if h.config.RemotePeers == 0 {
+		return nil
+	}
+	if h.locality != nil {
+		return h.locality
+	}
+	if h.config.LocalityKey != "" {
+		return SameMetadata(h.config.LocalityKey)
+	}
+	return nil
+}
+
+// checkLocality rejects a locality policy with no locality to tell the local peers from the remote ones.
+func (h *HyParView) checkLocality(config HyParViewConfig) error {
+	if config.RemotePeers > 0 && config.LocalityKey == "" && h.locality == nil {
+		return fmt.Errorf("invalid config: RemotePeers %d needs a LocalityKey or WithLocality", config.RemotePeers)
+	}
+	return nil
+}
+
+// activeMix counts the local and the remote active peers.
+func (h *HyParView) activeMix(locality Locality) (local, remote int) {
+	self := h.selfNode()
+	for _, peer := range h.activeView.peers {
+		if locality(self, peer.node) {
+			local++
+		} else {
+			remote++
+		}
+	}
+	return local, remote
+}
+
+// remoteTarget returns the number of remote peers the active view is kept at.
+func (h *HyParView) remoteTarget() int {
+	return min(h.config.RemotePeers, h.activeViewSize())
+}
+
+// wantedClass reports whether the active view lacks local or remote peers,
+// ok is false if the policy is disabled or the mix is on target.
+func (h *HyParView) wantedClass() (local bool, ok bool) {
+	locality := h.localityPolicy()
+	if locality == nil {
+		return false, false
+	}
+	localCount, remoteCount := h.activeMix(locality)
+	if remoteCount < h.remoteTarget() {
+		return false, true
+	}
+	if localCount < h.activeViewSize()-h.remoteTarget() {
+		return true, true
+	}
+	return false, false
+}
+
+// wanted reports whether a full active view takes the node in, a local node is taken
+// while the remote peers are above the target and a remote one while they are below it.
+func (h *HyParView) wanted(node data.Node) bool {
+	locality := h.localityPolicy()
+	if locality == nil {
+		return false
+	}
+	_, remote := h.activeMix(locality)
+	if locality(h.selfNode(), node) {
+		return remote > h.remoteTarget()
+	}
+	return remote < h.remoteTarget()
+}
+
+// evictionFilter returns the filter of the active peers to drop to make room for the incoming node,
+// the remote peers while they are above the target and the local ones while they are below it.
+// On target the peers of the class of the incoming node are dropped, the local ones if there is none.
+// It returns nil if the policy is disabled.
+func (h *HyParView) evictionFilter(incoming *data.Node) func(peer Peer) bool {
+	locality := h.localityPolicy()
+	if locality == nil {
+		return nil
+	}
+	_, remote := h.activeMix(locality)
+	self := h.selfNode()
+	evictLocal := remote < h.remoteTarget()
+	if remote == h.remoteTarget() {
+		evictLocal = incoming == nil || locality(self, *incoming)
+	}
+	return func(peer Peer) bool {
+		return locality(self, peer.node) == evictLocal
+	}
+}
+
+// candidateFilter returns the filter of the passive view nodes of the class the active view lacks,
+// nil if the policy is disabled or the mix is on target.
+func (h *HyParView) candidateFilter() func(peer Peer) bool {
+	local, ok := h.wantedClass()
+	if !ok {
+		return nil
+	}
+	locality := h.localityPolicy()
+	self := h.selfNode()
+	return func(peer Peer) bool {
+		return locality(self, peer.node) == local
+	}
+}
+
+// rebalance asks a remote passive view node to become a neighbor while the active view
+// lacks remote peers, a local peer is dropped if the active view is full once the node accepts.
+// The lacking local peers are only made up for by the replacements, the remote links
+// are sought actively as the localities are connected through them. The requests
+// awaiting a reply count towards the remote target and their nodes are not asked again.
+func (h *HyParView) rebalance() {
+	local, ok := h.wantedClass()
+	if !ok || local {
+		return
+	}
+	now := time.Now()
+	pending := make([]string, 0, len(h.rebalancing))
+	for id, deadline := range h.rebalancing {
+		if now.After(deadline) {
+			delete(h.rebalancing, id)
+			continue
+		}
+		pending = append(pending, id)
+	}
+	if _, remote := h.activeMix(h.localityPolicy()); remote+len(pending) >= h.remoteTarget() {
+		return
+	}
+	candidate := h.selectRandomWhere(h.passiveView.peers, pending, h.candidateFilter())
+	if candidate == nil {
+		return
+	}
+	log.Printf("rebalancing the active view through node %s\n", candidate.node.ID)
+	nodeID := candidate.node.ID
+	h.rebalancing[nodeID] = now.Add(rebalanceTimeout)
+	h.requestNeighbor(*candidate, func() {
+		delete(h.rebalancing, nodeID)
+	})
+}
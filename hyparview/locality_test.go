@@ -0,0 +1,207 @@
+package hyparview
+
+import (
+	"fmt"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/tamararankovic/hyparview/data"
+	"github.com/tamararankovic/hyparview/transport"
+)
+
+func zoneNode(id, zone string) data.Node {
+	node := testNode(id)
+	node.Metadata = map[string]string{"zone": zone}
+	return node
+}
+
+// newLocalityTestNode returns a node in zone a with a full active view of local peers
+// but active-0 in zone b, keeping a single remote peer.
+func newLocalityTestNode(t *testing.T) *HyParView {
+	h := newHandlerTestNode(t, 4)
+	h.config.RemotePeers = 1
+	h.config.LocalityKey = "zone"
+	if err := h.SetMetadata(map[string]string{"zone": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	for i, peer := range h.activeView.peers {
+		zone := "a"
+		if peer.node.ID == "active-0" {
+			zone = "b"
+		}
+		h.activeView.peers[i].node.Metadata = map[string]string{"zone": zone}
+	}
+	if !h.activeViewFull() {
+		t.Fatalf("active view %d/%d, want it full", h.activeView.size(), h.activeViewSize())
+	}
+	return h
+}
+
+func TestLocality(t *testing.T) {
+	self := zoneNode("self", "a")
+	if !SameMetadata("zone")(self, zoneNode("n", "a")) || SameMetadata("zone")(self, zoneNode("n", "b")) {
+		t.Error("SameMetadata does not tell the zones apart")
+	}
+	if SameMetadata("zone")(testNode("self"), testNode("n")) {
+		t.Error("SameMetadata treats the nodes without the key as local")
+	}
+	estimate := func(node data.Node) (time.Duration, bool) {
+		latency, ok := map[string]time.Duration{"near": time.Millisecond, "far": 80 * time.Millisecond}[node.ID]
+		return latency, ok
+	}
+	local := LatencyBelow(estimate, 10*time.Millisecond)
+	if !local(self, testNode("near")) || local(self, testNode("far")) || local(self, testNode("unknown")) {
+		t.Error("LatencyBelow does not tell the near nodes from the far and unknown ones")
+	}
+}
+
+func TestJoinKeepsRemotePeer(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		h := newLocalityTestNode(t)
+		joining := zoneNode(fmt.Sprintf("new-%d", i), "a")
+		handle(t, h, joining.ID, data.Message{Type: data.JOIN, Payload: data.Join{NodeID: joining.ID, ListenAddress: joining.ListenAddress, Metadata: joining.Metadata, MetadataVersion: 1}})
+		if !h.activeView.contains("active-0") {
+			t.Fatal("the only remote peer dropped to make room for a local node")
+		}
+		if !h.activeView.contains(joining.ID) {
+			t.Fatal("joining node not added to the active view")
+		}
+	}
+}
+
+func TestNeighborOfLackingClassAccepted(t *testing.T) {
+	h := newLocalityTestNode(t)
+	h.config.RemotePeers = 2
+	local := zoneNode("local", "a")
+	handle(t, h, local.ID, data.Message{Type: data.NEIGHTBOR, Payload: data.Neighbor{NodeID: local.ID, ListenAddress: local.ListenAddress, Metadata: local.Metadata, MetadataVersion: 1}})
+	if h.activeView.contains(local.ID) {
+		t.Error("low priority local neighbor accepted at a full active view lacking remote peers")
+	}
+	remote := zoneNode("remote", "b")
+	handle(t, h, remote.ID, data.Message{Type: data.NEIGHTBOR, Payload: data.Neighbor{NodeID: remote.ID, ListenAddress: remote.ListenAddress, Metadata: remote.Metadata, MetadataVersion: 1}})
+	if !h.activeView.contains(remote.ID) || !h.activeView.contains("active-0") {
+		t.Fatal("low priority remote neighbor refused or the other remote peer dropped")
+	}
+	if local, remote := h.activeMix(h.localityPolicy()); local != 2 || remote != 2 {
+		t.Errorf("active view holds %d local and %d remote peers, want 2 and 2", local, remote)
+	}
+	// on target a remote node is only taken in at high priority and in place of a remote peer
+	extra := zoneNode("extra", "b")
+	handle(t, h, extra.ID, data.Message{Type: data.NEIGHTBOR, Payload: data.Neighbor{NodeID: extra.ID, ListenAddress: extra.ListenAddress, Metadata: extra.Metadata, MetadataVersion: 1}})
+	if h.activeView.contains(extra.ID) {
+		t.Error("low priority remote neighbor accepted at a full active view on target")
+	}
+	handle(t, h, extra.ID, data.Message{Type: data.NEIGHTBOR, Payload: data.Neighbor{NodeID: extra.ID, ListenAddress: extra.ListenAddress, Metadata: extra.Metadata, MetadataVersion: 1, HighPriority: true}})
+	if !h.activeView.contains(extra.ID) {
+		t.Fatal("high priority remote neighbor refused")
+	}
+	if local, remote := h.activeMix(h.localityPolicy()); local != 2 || remote != 2 {
+		t.Errorf("active view holds %d local and %d remote peers after the high priority neighbor, want 2 and 2", local, remote)
+	}
+}
+
+func TestRemotePeersNeedLocality(t *testing.T) {
+	config := DefaultConfig(1000)
+	config.RemotePeers = 1
+	self := testNode("self")
+	network := transport.NewMemNetwork(transport.DefaultConnConfig())
+	connManager := transport.NewConnManager(self, network.NewConnFn(self.ListenAddress), network.AcceptConnsFn(self.ListenAddress))
+	if _, err := NewHyParView(config, self, connManager); err == nil {
+		t.Error("node created with RemotePeers and no locality")
+	}
+	h, err := NewHyParView(config, self, connManager, WithLocality(SameMetadata("zone")))
+	if err != nil {
+		t.Fatalf("node with RemotePeers and WithLocality not created: %v", err)
+	}
+	h.Stop()
+	if err := newHandlerTestNode(t, 0).UpdateConfig(config); err == nil {
+		t.Error("config with RemotePeers and no locality applied")
+	}
+}
+
+func TestReplacementPrefersLackingClass(t *testing.T) {
+	h := newLocalityTestNode(t)
+	h.activeView.remove("active-0")
+	for i := range h.passiveView.peers.peers {
+		h.passiveView.peers.peers[i].node.Metadata = map[string]string{"zone": "a"}
+	}
+	h.passiveView.Add(zoneNode("remote", "b"), "")
+	filter := h.candidateFilter()
+	if filter == nil {
+		t.Fatal("no candidate filter while the active view lacks a remote peer")
+	}
+	for i := 0; i < 20; i++ {
+		if candidate := h.selectRandomWhere(h.passiveView.peers, nil, filter); candidate == nil || candidate.node.ID != "remote" {
+			t.Fatalf("candidate %v, want the only remote node", candidate)
+		}
+	}
+	h.config.RemotePeers = 0
+	if h.candidateFilter() != nil || h.evictionFilter(nil) != nil {
+		t.Error("locality policy applied with RemotePeers 0")
+	}
+}
+
+func TestRebalanceTracksPendingRequests(t *testing.T) {
+	network := transport.NewMemNetwork(transport.DefaultConnConfig())
+	h := newLocalityTestNode(t)
+	h.connManager = transport.NewConnManager(h.self, network.NewConnFn(h.self.ListenAddress), nil)
+	h.activeView.remove("active-0")
+	for i := range h.passiveView.peers.peers {
+		h.passiveView.peers.peers[i].node.Metadata = map[string]string{"zone": "a"}
+	}
+	// the remote nodes complete the handshake but never reply to the neighbor msgs
+	for _, id := range []string{"remote-0", "remote-1"} {
+		node := zoneNode(id, "b")
+		cm := transport.NewConnManager(node, network.NewConnFn(node.ListenAddress), network.AcceptConnsFn(node.ListenAddress))
+		if err := cm.StartAcceptingConns(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(cm.Close)
+		h.passiveView.Add(node, "")
+	}
+
+	// pending returns the nodes asked by the rebalancing requests awaiting a reply
+	pending := func() map[string]time.Time {
+		h.lock.Lock()
+		defer h.lock.Unlock()
+		return maps.Clone(h.rebalancing)
+	}
+	rebalance := func() {
+		h.lock.Lock()
+		defer h.lock.Unlock()
+		h.rebalance()
+	}
+	rebalance()
+	if len(pending()) != 1 {
+		t.Fatalf("%d rebalancing requests pending, want 1", len(pending()))
+	}
+	var first string
+	for id := range pending() {
+		first = id
+	}
+	rebalance()
+	if len(pending()) != 1 {
+		t.Fatalf("%d rebalancing requests pending after the next tick, want the pending one to count towards the target", len(pending()))
+	}
+	h.lock.Lock()
+	h.config.RemotePeers = 2
+	h.lock.Unlock()
+	rebalance()
+	if len(pending()) != 2 {
+		t.Fatalf("rebalancing requests %v, want the other remote node asked", pending())
+	}
+	handle(t, h, first, data.Message{Type: data.NEIGHTBOR_REPLY, Payload: data.NeighborReply{NodeID: first, ListenAddress: first + ":7000", Accepted: false}})
+	if _, ok := pending()[first]; ok {
+		t.Errorf("refused rebalancing request to %s still pending", first)
+	}
+	h.lock.Lock()
+	for id := range h.rebalancing {
+		h.rebalancing[id] = time.Now().Add(-time.Second)
+	}
+	h.lock.Unlock()
+	rebalance()
+	if len(pending()) != 1 {
+		t.Errorf("rebalancing requests %v, want the timed out ones dropped and a new one sent", pending())
+	}
+}
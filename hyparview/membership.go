@@ -0,0 +1,234 @@
+package hyparview
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/tamararankovic/hyparview/data"
+)
+
+// Member is a persisted view entry. LastSeen is the last time the node
+// was known to be alive: the time of the save for the active peers,
+// the time the entry was added to the passive view for the passive ones.
+type Member struct {
+	Node     data.Node
+	Active   bool
+	LastSeen time.Time
+}
+
+// MembershipStore persists the view membership so a restarted node
+// can rejoin through the peers it knew before the restart.
+// Save replaces all the previously saved members.
+type MembershipStore interface {
+	Load() ([]Member, error)
+	Save(members []Member) error
+}
+
+// FileStore keeps the members in a JSON file. The file is replaced atomically,
+// a crash during a save leaves the previous members in place.
+type FileStore struct {
+	path string
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+type fileMember struct {
+	ID              string            `json:"id"`
+	ListenAddress   string            `json:"listen_address"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	MetadataVersion uint64            `json:"metadata_version,omitempty"`
+	Active          bool              `json:"active"`
+	LastSeen        time.Time         `json:"last_seen"`
+}
+
+// Load returns the saved members, none if nothing was saved yet.
+func (s *FileStore) Load() ([]Member, error) {
+	content, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var fileMembers []fileMember
+	if err := json.Unmarshal(content, &fileMembers); err != nil {
+		return nil, err
+	}
+	members := make([]Member, len(fileMembers))
+	for i, m := range fileMembers {
+		members[i] = Member{
+			Node:     data.Node{ID: m.ID, ListenAddress: m.ListenAddress, Metadata: m.Metadata, MetadataVersion: m.MetadataVersion},
+			Active:   m.Active,
+			LastSeen: m.LastSeen,
+		}
+	}
+	return members, nil
+}
+
+func (s *FileStore) Save(members []Member) error {
+	fileMembers := make([]fileMember, len(members))
+	for i, m := range members {
+		fileMembers[i] = fileMember{
+			ID:              m.Node.ID,
+			ListenAddress:   m.Node.ListenAddress,
+			Metadata:        m.Node.Metadata,
+			MetadataVersion: m.Node.MetadataVersion,
+			Active:          m.Active,
+			LastSeen:        m.LastSeen,
+		}
+	}
+	content, err := json.MarshalIndent(fileMembers, "", "  ")
+	if err != nil {
+		return err
+	}
+	// the temp file is renamed over the old one once its content is on disk
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	// the rename itself is only durable once the directory is on disk
+	dir, err := os.Open(filepath.Dir(s.path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// membershipPersister saves the latest view membership in the background,
+// right after every change or, with a positive interval, once per interval.
+type membershipPersister struct {
+	store    MembershipStore
+	interval time.Duration
+	lock     sync.Mutex
+	// pending holds the members not saved yet, nil if there are none
+	pending []Member
+	// changed wakes the persister up, buffered so the update does not wait for a save
+	changed chan struct{}
+}
+
+func newMembershipPersister(store MembershipStore, interval time.Duration) *membershipPersister {
+	return &membershipPersister{
+		store:    store,
+		interval: interval,
+		changed:  make(chan struct{}, 1),
+	}
+}
+
+// update replaces the members waiting to be saved.
+func (p *membershipPersister) update(members []Member) {
+	p.lock.Lock()
+	p.pending = members
+	p.lock.Unlock()
+	select {
+	case p.changed <- struct{}{}:
+	default:
+	}
+}
+
+// run saves the pending members until the stop channel is closed,
+// the members pending at that point are saved before it returns.
+func (p *membershipPersister) run(stop <-chan struct{}) {
+	var tick <-chan time.Time
+	if p.interval > 0 {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-stop:
+			p.save()
+			return
+		case <-p.changed:
+			if p.interval > 0 {
+				continue
+			}
+		case <-tick:
+		}
+		p.save()
+	}
+}
+
+func (p *membershipPersister) save() {
+	p.lock.Lock()
+	members := p.pending
+	p.pending = nil
+	p.lock.Unlock()
+	if members == nil {
+		return
+	}
+	if err := p.store.Save(members); err != nil {
+		log.Printf("failed to save the view membership: %v\n", err)
+	}
+}
+
+// members returns the entries of both views.
+func (h *HyParView) members() []Member {
+	now := time.Now()
+	members := make([]Member, 0, h.activeView.size()+h.passiveView.Size())
+	for _, peer := range h.activeView.peers {
+		members = append(members, Member{Node: peer.node, Active: true, LastSeen: now})
+	}
+	for _, peer := range h.passiveView.peers.peers {
+		members = append(members, Member{Node: peer.node, LastSeen: peer.lastSeen})
+	}
+	return members
+}
+
+// viewsChanged hands the changed membership over to the persister,
+// the views emptied when the node leaves are not persisted.
+func (h *HyParView) viewsChanged() {
+	if h.membership == nil || h.hasLeft() {
+		return
+	}
+	h.membership.update(h.members())
+}
+
+// restoreMembership adds the persisted members to the passive view, the most recently seen first,
+// and remembers them as the nodes Join tries before the contact node.
+func (h *HyParView) restoreMembership() error {
+	members, err := h.membership.store.Load()
+	if err != nil {
+		return err
+	}
+	slices.SortStableFunc(members, func(a, b Member) int {
+		return b.LastSeen.Compare(a.LastSeen)
+	})
+	for _, member := range members {
+		if h.passiveView.Full() {
+			break
+		}
+		if !h.passiveView.Add(member.Node, member.Node.ID) {
+			continue
+		}
+		h.passiveView.get(member.Node.ID).lastSeen = member.LastSeen
+		h.restored = append(h.restored, member.Node)
+	}
+	log.Printf("restored %d of %d persisted members\n", len(h.restored), len(members))
+	return nil
+}
@@ -0,0 +1,190 @@
+package hyparview
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tamararankovic/hyparview/data"
+	"github.com/tamararankovic/hyparview/transport"
+)
+
+type memoryStore struct {
+	lock    sync.Mutex
+	members []Member
+	saves   int
+}
+
+func (s *memoryStore) Load() ([]Member, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.members, nil
+}
+
+func (s *memoryStore) Save(members []Member) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.members = members
+	s.saves++
+	return nil
+}
+
+func (s *memoryStore) saved() ([]Member, int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.members, s.saves
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	store := NewFileStore(filepath.Join(dir, "members.json"))
+	members, err := store.Load()
+	if err != nil || members != nil {
+		t.Fatalf("Load() before the first save = %v, %v, want no members", members, err)
+	}
+	lastSeen := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	saved := []Member{
+		{Node: data.Node{ID: "a", ListenAddress: "10.0.0.1:7000", Metadata: map[string]string{"zone": "eu-1"}, MetadataVersion: 3}, Active: true, LastSeen: lastSeen},
+		{Node: data.Node{ID: "b", ListenAddress: "10.0.0.2:7000"}, LastSeen: lastSeen.Add(-time.Hour)},
+	}
+	for _, want := range [][]Member{saved, saved[1:]} {
+		if err := store.Save(want); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Load() = %v, want %v", got, want)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("%d files left in the store dir, want only the members file", len(entries))
+	}
+}
+
+func TestMembershipSavedOnChange(t *testing.T) {
+	store := &memoryStore{}
+	h := newMemNetworkTestNode(t, transport.NewMemNetwork(transport.DefaultConnConfig()), "self", WithMembershipStore(store, 0))
+	h.integrateNodesIntoPartialView([]data.Node{testNode("a"), testNode("b")}, nil, "origin")
+	deadline := time.Now().Add(time.Second)
+	for {
+		members, _ := store.saved()
+		if len(members) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("saved members %v, want a and b", members)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMembershipSavedOncePerInterval(t *testing.T) {
+	store := &memoryStore{}
+	h := newMemNetworkTestNode(t, transport.NewMemNetwork(transport.DefaultConnConfig()), "self", WithMembershipStore(store, time.Hour))
+	h.integrateNodesIntoPartialView([]data.Node{testNode("a"), testNode("b")}, nil, "origin")
+	time.Sleep(50 * time.Millisecond)
+	if _, saves := store.saved(); saves != 0 {
+		t.Errorf("membership saved %d times before the interval passed", saves)
+	}
+	// the pending changes are saved once the node stops
+	h.Stop()
+	deadline := time.Now().Add(time.Second)
+	for {
+		members, _ := store.saved()
+		if len(members) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("saved members %v after the stop, want a and b", members)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMembershipRestored(t *testing.T) {
+	now := time.Now()
+	store := &memoryStore{members: []Member{
+		{Node: testNode("old"), LastSeen: now.Add(-time.Hour)},
+		{Node: testNode("recent"), Active: true, LastSeen: now},
+		{Node: testNode("self"), LastSeen: now},
+	}}
+	h := newMemNetworkTestNode(t, transport.NewMemNetwork(transport.DefaultConnConfig()), "self", WithMembershipStore(store, time.Hour))
+	if want := []data.Node{testNode("recent"), testNode("old")}; !reflect.DeepEqual(h.restored, want) {
+		t.Errorf("restored nodes %v, want the most recently seen first %v", h.restored, want)
+	}
+	if peer := h.passiveView.get("old"); peer == nil || !peer.lastSeen.Equal(now.Add(-time.Hour)) {
+		t.Errorf("restored passive view peer %v, want it last seen an hour ago", peer)
+	}
+	if h.activeView.size() != 0 {
+		t.Errorf("active view size %d, the restored nodes are not connected", h.activeView.size())
+	}
+}
+
+func TestJoinTriesRestoredNodesFirst(t *testing.T) {
+	network := transport.NewMemNetwork(transport.DefaultConnConfig())
+	alive := newMemNetworkTestNode(t, network, "alive")
+	now := time.Now()
+	store := &memoryStore{members: []Member{
+		{Node: data.Node{ID: "alive", ListenAddress: "alive"}, LastSeen: now.Add(-time.Minute)},
+		{Node: data.Node{ID: "dead", ListenAddress: "dead"}, LastSeen: now},
+	}}
+	h := newMemNetworkTestNode(t, network, "restarted", WithMembershipStore(store, 0))
+	if err := h.Join(""); err != nil {
+		t.Fatal(err)
+	}
+	if h.activeView.get("alive") == nil {
+		t.Errorf("restarted node did not join through the reachable restored node")
+	}
+	if h.restored != nil {
+		t.Errorf("restored nodes %v kept after the join", h.restored)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(alive.GetPeers()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("restored node did not add the restarted node to its active view")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestJoinTriesMostRecentRestoredNodes(t *testing.T) {
+	network := transport.NewMemNetwork(transport.DefaultConnConfig())
+	newMemNetworkTestNode(t, network, "alive")
+	now := time.Now()
+	store := &memoryStore{members: []Member{{Node: data.Node{ID: "alive", ListenAddress: "alive"}, LastSeen: now.Add(-time.Hour)}}}
+	for i := 0; i < maxRestoredJoins; i++ {
+		id := fmt.Sprintf("dead-%d", i)
+		store.members = append(store.members, Member{Node: data.Node{ID: id, ListenAddress: id}, LastSeen: now})
+	}
+	h := newMemNetworkTestNode(t, network, "restarted", WithMembershipStore(store, 0))
+	if err := h.Join(""); !errors.Is(err, ErrNoContactNode) {
+		t.Errorf("Join() = %v, want %v after the most recently seen restored nodes", err, ErrNoContactNode)
+	}
+	if h.activeView.get("alive") != nil {
+		t.Error("restarted node joined through a restored node past the limit")
+	}
+}
+
+func TestJoinWithoutReachableNodes(t *testing.T) {
+	network := transport.NewMemNetwork(transport.DefaultConnConfig())
+	store := &memoryStore{members: []Member{{Node: data.Node{ID: "dead", ListenAddress: "dead"}, LastSeen: time.Now()}}}
+	h := newMemNetworkTestNode(t, network, "restarted", WithMembershipStore(store, 0))
+	if err := h.Join(""); !errors.Is(err, ErrNoContactNode) {
+		t.Errorf("Join() = %v, want %v", err, ErrNoContactNode)
+	}
+	if err := h.Join("unknown"); err == nil || errors.Is(err, ErrNoContactNode) {
+		t.Errorf("Join(unknown) = %v, want the dial error", err)
+	}
+}
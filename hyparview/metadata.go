@@ -0,0 +1,111 @@
+package hyparview
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/tamararankovic/hyparview/data"
+	"github.com/tamararankovic/hyparview/transport"
+)
+
+const (
+	// MaxMetadataSize caps the total length of the metadata keys and values a node advertises,
+	// the node info carrying larger metadata is dropped by the receivers.
+	MaxMetadataSize = 4096
+	// MaxMetadataKeys caps the number of metadata entries a node advertises
+	MaxMetadataKeys = 64
+	// maxMetadataSkew bounds how far ahead of the local clock a received metadata version can be,
+	// a forged version far in the future would shadow all the later updates of the node.
+	maxMetadataSkew = time.Hour
+)
+
+var ErrInvalidMetadata = errors.New("invalid metadata")
+
+// SetMetadata replaces the metadata the node advertises. The update spreads gradually:
+// the nodes get it along with the msgs this node sends them, the copies passed on
+// by other nodes only fill in the entries of the nodes not known yet.
+// Metadata over the limits the receivers enforce is refused with ErrInvalidMetadata.
+func (h *HyParView) SetMetadata(metadata map[string]string) error {
+	if err := checkMetadata(data.Node{ID: h.self.ID, Metadata: metadata}); err != nil {
+		return err
+	}
+	h.metadataLock.Lock()
+	defer h.metadataLock.Unlock()
+	h.self.Metadata = maps.Clone(metadata)
+	h.self.MetadataVersion = nextMetadataVersion(h.self.MetadataVersion)
+	return nil
+}
+
+// selfNode returns the node info of this node along with its current metadata.
+func (h *HyParView) selfNode() data.Node {
+	h.metadataLock.Lock()
+	defer h.metadataLock.Unlock()
+	self := h.self
+	self.Metadata = maps.Clone(h.self.Metadata)
+	return self
+}
+
+// nextMetadataVersion returns a version above the current one, it is derived from the clock
+// so the metadata set after a restart replaces the copies advertised before it.
+func nextMetadataVersion(current uint64) uint64 {
+	return max(current+1, uint64(time.Now().UnixNano()))
+}
+
+// GetPeersWhere returns the active peers whose node info is accepted by the filter.
+func (h *HyParView) GetPeersWhere(filter func(node data.Node) bool) []Peer {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return slices.DeleteFunc(h.activeView.list(), func(peer Peer) bool {
+		return !filter(peer.node)
+	})
+}
+
+// MatchTags returns a filter accepting the nodes whose metadata holds all the tags.
+func MatchTags(tags map[string]string) func(node data.Node) bool {
+	return func(node data.Node) bool {
+		for key, value := range tags {
+			if v, ok := node.Metadata[key]; !ok || v != value {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// refreshNode replaces the metadata of the view entries with the newer version received.
+// Only the node info received from the node itself can be trusted to be current,
+// so the callers pass the node info sent over the conn of the node or signed by it.
+func (h *HyParView) refreshNode(node data.Node) {
+	if err := checkMetadata(node); err != nil {
+		return
+	}
+	h.activeView.refresh(node)
+	h.passiveView.peers.refresh(node)
+}
+
+// firstHand reports whether the node info of the node is sent by the node itself:
+// with an identity all the msgs are signed by the node they are sent on behalf of.
+func (h *HyParView) firstHand(received transport.MsgReceived, nodeID string) bool {
+	return h.identity != nil || received.Sender.GetRemoteNode().ID == nodeID
+}
+
+// checkMetadata rejects the node info with oversized metadata or a version far ahead of the local clock.
+func checkMetadata(node data.Node) error {
+	if len(node.Metadata) > MaxMetadataKeys {
+		return fmt.Errorf("%w: node %s advertises %d metadata keys, limit is %d", ErrInvalidMetadata, node.ID, len(node.Metadata), MaxMetadataKeys)
+	}
+	size := 0
+	for key, value := range node.Metadata {
+		size += len(key) + len(value)
+	}
+	if size > MaxMetadataSize {
+		return fmt.Errorf("%w: node %s advertises %d bytes of metadata, limit is %d", ErrInvalidMetadata, node.ID, size, MaxMetadataSize)
+	}
+	if limit := uint64(time.Now().Add(maxMetadataSkew).UnixNano()); node.MetadataVersion > limit {
+		return fmt.Errorf("%w: node %s advertises metadata version %d ahead of the clock", ErrInvalidMetadata, node.ID, node.MetadataVersion)
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package hyparview
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tamararankovic/hyparview/data"
+	"github.com/tamararankovic/hyparview/transport"
+)
+
+func TestSetMetadata(t *testing.T) {
+	h := newHandlerTestNode(t, 1)
+	before := h.Self().MetadataVersion
+	metadata := map[string]string{"zone": "eu-1"}
+	if err := h.SetMetadata(metadata); err != nil {
+		t.Fatal(err)
+	}
+	metadata["zone"] = "changed"
+	self := h.Self()
+	if self.Metadata["zone"] != "eu-1" {
+		t.Errorf("self metadata %v, want the zone set before the caller changed its map", self.Metadata)
+	}
+	if self.MetadataVersion <= before {
+		t.Errorf("metadata version %d, want above %d", self.MetadataVersion, before)
+	}
+	if err := h.Shuffle(); err != nil {
+		t.Fatal(err)
+	}
+	sent := h.activeView.peers[0].conn.(*testConn).sent
+	if len(sent) != 1 {
+		t.Fatalf("%d msgs sent, want the shuffle", len(sent))
+	}
+	if shuffle := sent[0].Payload.(data.Shuffle); shuffle.Metadata["zone"] != "eu-1" || shuffle.MetadataVersion != self.MetadataVersion {
+		t.Errorf("shuffle carries metadata %v version %d, want the current one", shuffle.Metadata, shuffle.MetadataVersion)
+	}
+}
+
+func TestSetMetadataLimits(t *testing.T) {
+	h := newHandlerTestNode(t, 1)
+	if err := h.SetMetadata(map[string]string{"zone": "eu-1"}); err != nil {
+		t.Fatal(err)
+	}
+	tooManyKeys := make(map[string]string)
+	for i := 0; i <= MaxMetadataKeys; i++ {
+		tooManyKeys[fmt.Sprintf("key-%d", i)] = "v"
+	}
+	for name, metadata := range map[string]map[string]string{
+		"oversized":     {"zone": strings.Repeat("x", MaxMetadataSize)},
+		"too many keys": tooManyKeys,
+	} {
+		if err := h.SetMetadata(metadata); !errors.Is(err, ErrInvalidMetadata) {
+			t.Errorf("%s metadata set with %v, want %v", name, err, ErrInvalidMetadata)
+		}
+		// the receivers would drop the node info the same way
+		if err := checkMetadata(data.Node{ID: "n", Metadata: metadata}); err == nil {
+			t.Errorf("%s metadata accepted by the receivers", name)
+		}
+	}
+	if zone := h.Self().Metadata["zone"]; zone != "eu-1" {
+		t.Errorf("zone %q after the refused updates, want eu-1", zone)
+	}
+}
+
+func TestRefreshAcceptsNewerVersions(t *testing.T) {
+	h := newHandlerTestNode(t, 3)
+	shuffle := func(zone string, version uint64) {
+		handle(t, h, "active-1", data.Message{Type: data.SHUFFLE, Payload: data.Shuffle{
+			NodeID:          "active-1",
+			ListenAddress:   "active-1:7000",
+			Metadata:        map[string]string{"zone": zone},
+			MetadataVersion: version,
+			TTL:             1,
+		}})
+	}
+	shuffle("eu-1", 2)
+	shuffle("us-1", 1)
+	if zone := h.activeView.get("active-1").node.Metadata["zone"]; zone != "eu-1" {
+		t.Errorf("active peer zone %q, want the newer eu-1", zone)
+	}
+}
+
+func TestRefreshIgnoresThirdPartyNodeInfo(t *testing.T) {
+	h := newHandlerTestNode(t, 3)
+	forged := map[string]string{"zone": "forged"}
+	handle(t, h, "active-0", data.Message{Type: data.SHUFFLE, Payload: data.Shuffle{
+		NodeID:          "origin",
+		ListenAddress:   "origin:7000",
+		Metadata:        map[string]string{"zone": "eu-1"},
+		MetadataVersion: 1,
+		TTL:             1,
+		Nodes: []data.Node{
+			{ID: "passive-0", ListenAddress: "passive-0:7000", Metadata: forged, MetadataVersion: math.MaxUint64},
+			{ID: "active-1", ListenAddress: "active-1:7000", Metadata: forged, MetadataVersion: math.MaxUint64},
+		},
+	}})
+	if zone := h.passiveView.get("passive-0").node.Metadata["zone"]; zone == "forged" {
+		t.Error("passive peer metadata replaced by a node passing it on")
+	}
+	if zone := h.activeView.get("active-1").node.Metadata["zone"]; zone == "forged" {
+		t.Error("active peer metadata replaced by a node passing it on")
+	}
+	// the node itself still updates its metadata
+	handle(t, h, "active-1", data.Message{Type: data.SHUFFLE, Payload: data.Shuffle{
+		NodeID:          "active-1",
+		ListenAddress:   "active-1:7000",
+		Metadata:        map[string]string{"zone": "eu-1"},
+		MetadataVersion: 1,
+		TTL:             1,
+	}})
+	if zone := h.activeView.get("active-1").node.Metadata["zone"]; zone != "eu-1" {
+		t.Errorf("active peer zone %q, want eu-1 set by the node itself", zone)
+	}
+}
+
+func TestInvalidMetadataDropped(t *testing.T) {
+	h := newHandlerTestNode(t, 3)
+	oversized := map[string]string{"zone": strings.Repeat("x", MaxMetadataSize)}
+	future := uint64(time.Now().Add(24 * time.Hour).UnixNano())
+	for _, node := range []data.Node{
+		{ID: "active-1", ListenAddress: "active-1:7000", Metadata: map[string]string{"zone": "eu-1"}, MetadataVersion: future},
+		{ID: "active-1", ListenAddress: "active-1:7000", Metadata: oversized, MetadataVersion: 1},
+	} {
+		handle(t, h, "active-1", data.Message{Type: data.SHUFFLE, Payload: data.Shuffle{
+			NodeID:          node.ID,
+			ListenAddress:   node.ListenAddress,
+			Metadata:        node.Metadata,
+			MetadataVersion: node.MetadataVersion,
+			TTL:             1,
+		}})
+		if h.activeView.get("active-1").node.MetadataVersion != 0 {
+			t.Errorf("metadata version %d of %d bytes accepted", node.MetadataVersion, len(node.Metadata["zone"]))
+		}
+	}
+	handle(t, h, "active-0", data.Message{Type: data.SHUFFLE, Payload: data.Shuffle{
+		NodeID:        "origin",
+		ListenAddress: "origin:7000",
+		TTL:           1,
+		Nodes:         []data.Node{{ID: "new", ListenAddress: "new:7000", Metadata: oversized}},
+	}})
+	if h.passiveView.Contains("new") {
+		t.Error("node with oversized metadata taken into the passive view")
+	}
+	join := &testConn{remote: testNode("joining")}
+	err := h.onJoin(transport.MsgReceived{Sender: join, Msg: data.Message{Type: data.JOIN, Payload: data.Join{
+		NodeID:        "joining",
+		ListenAddress: "joining:7000",
+		Metadata:      oversized,
+	}}})
+	if h.activeView.contains("joining") {
+		t.Errorf("node joining with oversized metadata accepted: %v", err)
+	}
+}
+
+func TestGetPeersWhere(t *testing.T) {
+	h := newHandlerTestNode(t, 3)
+	h.activeView.get("active-0").node.Metadata = map[string]string{"zone": "eu-1", "rack": "r1"}
+	h.activeView.get("active-1").node.Metadata = map[string]string{"zone": "eu-1", "rack": "r2"}
+	for _, tc := range []struct {
+		tags map[string]string
+		want int
+	}{
+		{nil, 3},
+		{map[string]string{"zone": "eu-1"}, 2},
+		{map[string]string{"zone": "eu-1", "rack": "r2"}, 1},
+		{map[string]string{"zone": "us-1"}, 0},
+	} {
+		if peers := h.GetPeersWhere(MatchTags(tc.tags)); len(peers) != tc.want {
+			t.Errorf("GetPeersWhere(%v) returned %d peers, want %d", tc.tags, len(peers), tc.want)
+		}
+	}
+}
+
+func TestMetadataSpreads(t *testing.T) {
+	network := transport.NewMemNetwork(transport.DefaultConnConfig())
+	a := newMemNetworkTestNode(t, network, "a")
+	b := newMemNetworkTestNode(t, network, "b")
+	if err := b.Join("a"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(a.GetPeers()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("a did not add b to its active view")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := a.SetMetadata(map[string]string{"zone": "eu-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Shuffle(); err != nil {
+		t.Fatal(err)
+	}
+	deadline = time.Now().Add(time.Second)
+	for len(b.GetPeersWhere(MatchTags(map[string]string{"zone": "eu-1"}))) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("b active view %v, want a with the zone set after the join", b.GetPeers())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
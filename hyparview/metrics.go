@@ -0,0 +1,39 @@
+package hyparview
+
+import "sync"
+
+const metricRateLimited = "rate_limited_total"
+
+// metrics holds the counters of the notable protocol events indexed by name and label.
+type metrics struct {
+	counters map[string]map[string]uint64
+	lock     sync.Mutex
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		counters: make(map[string]map[string]uint64),
+	}
+}
+
+func (m *metrics) inc(name, label string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.counters[name] == nil {
+		m.counters[name] = make(map[string]uint64)
+	}
+	m.counters[name][label]++
+}
+
+func (m *metrics) snapshot() map[string]map[string]uint64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	snapshot := make(map[string]map[string]uint64, len(m.counters))
+	for name, counters := range m.counters {
+		snapshot[name] = make(map[string]uint64, len(counters))
+		for label, value := range counters {
+			snapshot[name][label] = value
+		}
+	}
+	return snapshot
+}
@@ -3,7 +3,6 @@ package hyparview
 import (
 	"fmt"
 	"log"
-	"math"
 
 	"github.com/tamararankovic/hyparview/data"
 	"github.com/tamararankovic/hyparview/transport"
@@ -12,31 +11,49 @@ import (
 func (h *HyParView) onJoin(received transport.MsgReceived) error {
 	msg, ok := received.Msg.Payload.(data.Join)
 	if !ok {
-		return fmt.Errorf("msg %v not a join msg", received.Msg.Payload)
+		return violation("msg %v not a join msg", received.Msg.Payload)
+	}
+	joiningNode := data.Node{ID: msg.NodeID, ListenAddress: msg.ListenAddress, Metadata: msg.Metadata, MetadataVersion: msg.MetadataVersion}
+	if err := h.checkSender(received.Sender, msg.NodeID); err != nil {
+		return err
+	}
+	if err := h.admit(data.JOIN, joiningNode, msg.Credentials); err != nil {
+		return h.reject(received.Sender, data.JOIN, err)
+	}
+	h.refreshNode(joiningNode)
+	if h.getPeer(received.Sender) != nil {
+		log.Printf("node %s already in active view, join ignored\n", msg.NodeID)
+		return h.acceptJoin(received.Sender)
 	}
 	if h.activeViewFull() {
-		err := h.disconnectRandomPeer()
+		// the dropped peer is out of the active view even if it could not be told
+		err := h.disconnectRandomPeer(&joiningNode)
 		if err != nil {
-			return err
+			log.Println(err)
 		}
 	}
 	newPeer := Peer{
-		node: data.Node{
-			ID:            msg.NodeID,
-			ListenAddress: msg.ListenAddress,
-		},
+		node: joiningNode,
 		conn: received.Sender,
 	}
-	h.activeView = append(h.activeView, newPeer)
-	log.Printf("peer [ID=%s, address=%d] added to active view\n", newPeer.node.ID, newPeer.conn.GetAddress())
+	h.addPeer(newPeer)
+	if err := h.acceptJoin(received.Sender); err != nil {
+		log.Println(err)
+	}
 	forwardJoinMsg := data.Message{
 		Type: data.FORWARD_JOIN,
 		Payload: data.ForwardJoin{
-			NodeID: msg.NodeID,
-			TTL:    h.config.ARWL,
+			NodeID:          msg.NodeID,
+			ListenAddress:   msg.ListenAddress,
+			Metadata:        msg.Metadata,
+			MetadataVersion: msg.MetadataVersion,
+			TTL:             h.config.ARWL,
+			Credentials:     msg.Credentials,
+			// the join signature is carried so the receivers can verify the joining node
+			Signature: msg.Signature,
 		},
 	}
-	for _, peer := range h.activeView {
+	for _, peer := range h.activeView.peers {
 		if peer.node.ID == newPeer.node.ID {
 			continue
 		}
@@ -51,153 +68,285 @@ func (h *HyParView) onJoin(received transport.MsgReceived) error {
 func (h *HyParView) onDisconnect(received transport.MsgReceived) error {
 	msg, ok := received.Msg.Payload.(data.Disconnect)
 	if !ok {
-		return fmt.Errorf("msg %v not a disconnect msg", received.Msg.Payload)
+		return violation("msg %v not a disconnect msg", received.Msg.Payload)
 	}
 	peer := h.getPeer(received.Sender)
 	if peer == nil {
 		log.Printf("peer %s not in active view\n", received.Sender.GetAddress())
+		return nil
 	}
 	if peer.node.ID != msg.NodeID {
 		log.Printf("node trying to disconnect not matching the message info: %v - %s\n", msg, received.Sender.GetAddress())
 		return nil
 	}
-	h.deletePeer(*peer)
-	return h.connManager.Disconnect(peer.conn)
+	disconnected := *peer
+	h.deletePeer(disconnected)
+	h.passiveView.Demote(disconnected.node)
+	err := h.connManager.Disconnect(disconnected.conn)
+	if err != nil {
+		log.Println(err)
+	}
+	h.replacePeer([]string{disconnected.node.ID})
+	return nil
+}
+
+// acceptJoin tells the joining node it was taken into the active view.
+func (h *HyParView) acceptJoin(conn transport.Conn) error {
+	self := h.selfNode()
+	return h.send(conn, data.Message{
+		Type: data.JOIN_REPLY,
+		Payload: data.JoinReply{
+			NodeID:          self.ID,
+			ListenAddress:   self.ListenAddress,
+			Metadata:        self.Metadata,
+			MetadataVersion: self.MetadataVersion,
+		},
+	})
+}
+
+func (h *HyParView) onJoinReply(received transport.MsgReceived) error {
+	msg, ok := received.Msg.Payload.(data.JoinReply)
+	if !ok {
+		return violation("msg %v not a join reply msg", received.Msg.Payload)
+	}
+	if err := h.checkSender(received.Sender, msg.NodeID); err != nil {
+		return err
+	}
+	contactNode := data.Node{ID: msg.NodeID, ListenAddress: msg.ListenAddress, Metadata: msg.Metadata, MetadataVersion: msg.MetadataVersion}
+	if err := checkMetadata(contactNode); err != nil {
+		return violation("%w", err)
+	}
+	outcome, ok := h.joins[received.Sender]
+	if !ok {
+		return fmt.Errorf("join reply from node %s received without a join in progress", msg.NodeID)
+	}
+	delete(h.joins, received.Sender)
+	h.refreshNode(contactNode)
+	if h.getPeer(received.Sender) == nil {
+		if h.activeViewFull() {
+			err := h.disconnectRandomPeer(&contactNode)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+		h.addPeer(Peer{node: contactNode, conn: received.Sender})
+	}
+	outcome <- nil
+	return nil
 }
 
 func (h *HyParView) onForwardJoin(received transport.MsgReceived) error {
 	msg, ok := received.Msg.Payload.(data.ForwardJoin)
 	if !ok {
-		return fmt.Errorf("msg %v not a forward join msg", received.Msg.Payload)
+		return violation("msg %v not a forward join msg", received.Msg.Payload)
 	}
-	newPeer := Peer{
-		node: data.Node{
-			ID:            msg.NodeID,
-			ListenAddress: msg.ListenAddress,
-		},
-		conn: nil,
+	joiningNode := data.Node{ID: msg.NodeID, ListenAddress: msg.ListenAddress, Metadata: msg.Metadata, MetadataVersion: msg.MetadataVersion}
+	if joiningNode.ID == h.self.ID {
+		return nil
 	}
-	addedToActiveView := false
-	if msg.TTL == 0 || len(h.activeView) == 1 {
-		conn, err := h.connManager.Connect(msg.ListenAddress)
-		if err != nil {
-			return err
-		}
-		newPeer.conn = conn
-		h.activeView = append(h.activeView, newPeer)
-		addedToActiveView = true
-	} else if msg.TTL == h.config.PRWL {
-		h.passiveView = append(h.passiveView, newPeer)
+	if err := h.admit(data.FORWARD_JOIN, joiningNode, msg.Credentials); err != nil {
+		return fmt.Errorf("forward join of node %s dropped: %w", msg.NodeID, err)
+	}
+	if h.firstHand(received, joiningNode.ID) {
+		h.refreshNode(joiningNode)
+	}
+	if msg.TTL <= 0 || h.activeView.size() == 1 {
+		h.acceptForwardJoin(joiningNode)
+		return nil
+	}
+	senderID := received.Sender.GetRemoteNode().ID
+	if msg.TTL == h.config.PRWL {
+		h.integrateNodesIntoPartialView([]data.Node{joiningNode}, []data.Node{}, senderID)
 	}
 	msg.TTL--
-	if !addedToActiveView {
-		senderPeer := h.getPeer(received.Sender)
-		nodeIdBlacklist := make([]string, 0)
-		if senderPeer != nil {
-			nodeIdBlacklist = append(nodeIdBlacklist, senderPeer.node.ID)
-		}
-		randomPeer := h.selectRandomPeer(nodeIdBlacklist)
-		return randomPeer.conn.Send(data.Message{
-			Type:    data.FORWARD_JOIN,
-			Payload: msg,
-		})
+	randomPeer := h.selectRandomPeer([]string{senderID, joiningNode.ID})
+	if randomPeer == nil {
+		// the random walk can not go on, it ends here
+		h.acceptForwardJoin(joiningNode)
+		return nil
 	}
-	return nil
+	return randomPeer.conn.Send(data.Message{
+		Type:    data.FORWARD_JOIN,
+		Payload: msg,
+	})
+}
+
+// acceptForwardJoin adds the joining node to the active view at the end of the random walk
+// and asks it to add this node to its active view as well. The node is dialed in the background.
+func (h *HyParView) acceptForwardJoin(joiningNode data.Node) {
+	if h.activeView.contains(joiningNode.ID) {
+		return
+	}
+	h.dial(joiningNode.ListenAddress, func(conn transport.Conn, err error) {
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if conn.GetRemoteNode().ID != joiningNode.ID {
+			h.connManager.Release(conn)
+			log.Printf("node %s listening on %s, expected node %s\n", conn.GetRemoteNode().ID, joiningNode.ListenAddress, joiningNode.ID)
+			return
+		}
+		// the node may have been added by another msg during the dial
+		if h.activeView.contains(joiningNode.ID) {
+			return
+		}
+		if h.activeViewFull() {
+			err := h.disconnectRandomPeer(&joiningNode)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+		h.addPeer(Peer{node: joiningNode, conn: conn})
+		self := h.selfNode()
+		neighborMsg := data.Message{
+			Type: data.NEIGHTBOR,
+			Payload: data.Neighbor{
+				NodeID:          self.ID,
+				ListenAddress:   self.ListenAddress,
+				Metadata:        self.Metadata,
+				MetadataVersion: self.MetadataVersion,
+				HighPriority:    true,
+				Credentials:     h.credentials(),
+			},
+		}
+		if err := h.send(conn, neighborMsg); err != nil {
+			log.Println(err)
+		}
+	})
 }
 
 func (h *HyParView) onNeighbor(received transport.MsgReceived) error {
 	msg, ok := received.Msg.Payload.(data.Neighbor)
 	if !ok {
-		return fmt.Errorf("msg %v not a neighbor msg", received.Msg.Payload)
+		return violation("msg %v not a neighbor msg", received.Msg.Payload)
+	}
+	neighbor := data.Node{ID: msg.NodeID, ListenAddress: msg.ListenAddress, Metadata: msg.Metadata, MetadataVersion: msg.MetadataVersion}
+	if err := h.checkSender(received.Sender, msg.NodeID); err != nil {
+		return err
 	}
-	accept := msg.HighPriority || !h.activeViewFull()
-	if accept {
+	if err := h.admit(data.NEIGHTBOR, neighbor, msg.Credentials); err != nil {
+		return h.reject(received.Sender, data.NEIGHTBOR, err)
+	}
+	h.refreshNode(neighbor)
+	// the link to a node already in the active view is kept as it is
+	// with the locality policy a full active view also takes the nodes keeping its mix on target
+	known := h.getPeer(received.Sender) != nil
+	accept := known || msg.HighPriority || !h.activeViewFull() || h.wanted(neighbor)
+	if accept && !known {
 		if h.activeViewFull() {
-			err := h.disconnectRandomPeer()
+			err := h.disconnectRandomPeer(&neighbor)
 			if err != nil {
-				return err
+				log.Println(err)
 			}
 		}
-		newPeer := Peer{
-			node: data.Node{
-				ID:            msg.NodeID,
-				ListenAddress: msg.ListenAddress,
-			},
-			conn: received.Sender,
-		}
-		h.activeView = append(h.activeView, newPeer)
-		log.Printf("peer [ID=%s, address=%d] added to active view\n", newPeer.node.ID, newPeer.conn.GetAddress())
+		h.addPeer(Peer{node: neighbor, conn: received.Sender})
 	}
+	self := h.selfNode()
 	neighborReplyMsg := data.Message{
 		Type: data.NEIGHTBOR_REPLY,
 		Payload: data.NeighborReply{
-			NodeID:        msg.NodeID,
-			ListenAddress: msg.ListenAddress,
-			Accepted:      accept,
+			NodeID:          self.ID,
+			ListenAddress:   self.ListenAddress,
+			Metadata:        self.Metadata,
+			MetadataVersion: self.MetadataVersion,
+			Accepted:        accept,
 		},
 	}
-	return received.Sender.Send(neighborReplyMsg)
+	return h.send(received.Sender, neighborReplyMsg)
 }
 
 func (h *HyParView) onNeighborReply(received transport.MsgReceived) error {
 	msg, ok := received.Msg.Payload.(data.NeighborReply)
 	if !ok {
-		return fmt.Errorf("msg %v not a neighbor reply msg", received.Msg.Payload)
+		return violation("msg %v not a neighbor reply msg", received.Msg.Payload)
+	}
+	if err := h.checkSender(received.Sender, msg.NodeID); err != nil {
+		return err
+	}
+	delete(h.rebalancing, msg.NodeID)
+	node := data.Node{ID: msg.NodeID, ListenAddress: msg.ListenAddress, Metadata: msg.Metadata, MetadataVersion: msg.MetadataVersion}
+	if err := checkMetadata(node); err != nil {
+		h.connManager.Release(received.Sender)
+		return violation("%w", err)
 	}
+	h.refreshNode(node)
 	if !msg.Accepted {
-		h.replacePeer([]string{msg.NodeID})
-	} else {
-		peer := h.getPeerCandidate(msg.NodeID)
-		if peer == nil {
-			return fmt.Errorf("peer [ID=%s] not found in passive view", msg.NodeID)
+		h.connManager.Release(received.Sender)
+		// a rebalancing request refused at a full active view needs no replacement
+		if !h.activeViewFull() {
+			h.replacePeer([]string{msg.NodeID})
 		}
-		h.deletePeerCandidate(*peer)
-		peer.conn = received.Sender
-		h.activeView = append(h.activeView, *peer)
-		log.Printf("peer [ID=%s, address=%d] added to active view\n", peer.node.ID, peer.conn.GetAddress())
+		return nil
 	}
+	if h.getPeer(received.Sender) != nil {
+		// the reply to the neighbor msg of acceptForwardJoin
+		return nil
+	}
+	// the node accepted the link, so it is taken even if a shuffle dropped it from the passive view meanwhile
+	peer := Peer{node: node}
+	if candidate := h.getPeerCandidate(msg.NodeID); candidate != nil {
+		peer = *candidate
+		h.deletePeerCandidate(peer)
+	}
+	if h.activeViewFull() {
+		err := h.disconnectRandomPeer(&node)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	peer.conn = received.Sender
+	h.addPeer(peer)
 	return nil
 }
 
 func (h *HyParView) onShuffle(received transport.MsgReceived) error {
 	msg, ok := received.Msg.Payload.(data.Shuffle)
 	if !ok {
-		return fmt.Errorf("msg %v not a shuffle msg", received.Msg.Payload)
+		return violation("msg %v not a shuffle msg", received.Msg.Payload)
+	}
+	if msg.NodeID == h.self.ID {
+		return nil
+	}
+	if h.firstHand(received, msg.NodeID) {
+		h.refreshNode(data.Node{ID: msg.NodeID, ListenAddress: msg.ListenAddress, Metadata: msg.Metadata, MetadataVersion: msg.MetadataVersion})
 	}
 	msg.TTL--
-	if msg.TTL > 0 && len(h.activeView) > 1 {
-		peer := h.selectRandomPeer([]string{msg.NodeID})
-		if peer == nil {
-			return fmt.Errorf("cannot find a peer to forward the shuffle msg")
-		}
-		return peer.conn.Send(received.Msg)
+	var peer *Peer
+	if msg.TTL > 0 && h.activeView.size() > 1 {
+		peer = h.selectRandomPeer([]string{msg.NodeID, received.Sender.GetRemoteNode().ID})
+	}
+	if peer != nil {
+		return peer.conn.Send(data.Message{
+			Type:    data.SHUFFLE,
+			Payload: msg,
+		})
 	} else {
-		passiveViewMaxIndex := int(math.Min(float64(len(msg.Nodes)), float64(len(h.passiveView))))
-		peers := h.passiveView[:passiveViewMaxIndex]
+		peers := h.passiveView.sample(len(msg.Nodes))
 		nodes := make([]data.Node, len(peers))
 		for i, peer := range peers {
 			nodes[i] = peer.node
 		}
-		conn, err := h.connManager.Connect(msg.ListenAddress)
-		if err != nil {
-			return err
-		}
+		// the received nodes are kept even if the origin can not be reached
+		h.integrateNodesIntoPartialView(msg.Nodes, []data.Node{}, h.source(received, msg.NodeID))
 		shuffleReplyMsg := data.Message{
 			Type: data.SHUFFLE_REPLY,
 			Payload: data.ShuffleReply{
+				NodeID:        h.self.ID,
 				ReceivedNodes: msg.Nodes,
 				Nodes:         nodes,
 			},
 		}
-		err = conn.Send(shuffleReplyMsg)
-		if err != nil {
-			log.Println(err)
-		}
-		err = h.connManager.Disconnect(conn)
-		if err != nil {
-			log.Println(err)
-		}
-		h.integrateNodesIntoPartialView(msg.Nodes, []data.Node{})
+		h.dial(msg.ListenAddress, func(conn transport.Conn, err error) {
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			if err := h.send(conn, shuffleReplyMsg); err != nil {
+				log.Println(err)
+			}
+			h.connManager.Release(conn)
+		})
 		return nil
 	}
 }
@@ -205,8 +354,44 @@ func (h *HyParView) onShuffle(received transport.MsgReceived) error {
 func (h *HyParView) onShuffleReply(received transport.MsgReceived) error {
 	msg, ok := received.Msg.Payload.(data.ShuffleReply)
 	if !ok {
-		return fmt.Errorf("msg %v not a shuffle reply msg", received.Msg.Payload)
+		return violation("msg %v not a shuffle reply msg", received.Msg.Payload)
+	}
+	h.integrateNodesIntoPartialView(msg.Nodes, msg.ReceivedNodes, h.source(received, msg.NodeID))
+	return nil
+}
+
+func (h *HyParView) onReject(received transport.MsgReceived) error {
+	msg, ok := received.Msg.Payload.(data.Reject)
+	if !ok {
+		return violation("msg %v not a reject msg", received.Msg.Payload)
+	}
+	log.Printf("node %s rejected our msg of type %s: %s\n", msg.NodeID, msg.MsgType, msg.Reason)
+	if outcome, ok := h.joins[received.Sender]; ok && msg.MsgType == data.JOIN {
+		delete(h.joins, received.Sender)
+		outcome <- fmt.Errorf("%w by node %s: %s", ErrJoinRejected, msg.NodeID, msg.Reason)
+	}
+	if msg.MsgType == data.NEIGHTBOR {
+		delete(h.rebalancing, received.Sender.GetRemoteNode().ID)
+		if peer := h.getPeerCandidate(msg.NodeID); peer != nil {
+			h.deletePeerCandidate(*peer)
+		}
+		h.replacePeer([]string{msg.NodeID})
+	}
+	return nil
+}
+
+// violation returns the handler error caused by a msg no correct node sends.
+func violation(format string, args ...any) error {
+	return fmt.Errorf("%w: "+format, append([]any{ErrProtocolViolation}, args...)...)
+}
+
+// checkSender makes sure the msg about the node comes over the conn to that node.
+func (h *HyParView) checkSender(sender transport.Conn, nodeID string) error {
+	if nodeID == h.self.ID {
+		return violation("msg from %s claims to be sent by this node", sender.GetAddress())
+	}
+	if senderID := sender.GetRemoteNode().ID; senderID != nodeID {
+		return violation("msg about node %s received from node %s", nodeID, senderID)
 	}
-	h.integrateNodesIntoPartialView(msg.Nodes, msg.ReceivedNodes)
 	return nil
 }
@@ -0,0 +1,463 @@
+package hyparview
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tamararankovic/hyparview/data"
+	"github.com/tamararankovic/hyparview/transport"
+)
+
+// testConn is a conn to a remote node that records the sent msgs,
+// the conn manager does not know it so linking and disconnecting it fail.
+type testConn struct {
+	transport.Conn
+	remote data.Node
+	// address is the address the conn comes from, the listen address of the remote node if empty
+	address string
+	sent    []data.Message
+}
+
+func (c *testConn) GetAddress() string {
+	if c.address != "" {
+		return c.address
+	}
+	return c.remote.ListenAddress
+}
+
+func (c *testConn) GetRemoteNode() data.Node {
+	return c.remote
+}
+
+func (c *testConn) GetQueueDepth() int {
+	return 0
+}
+
+func (c *testConn) Send(msg data.Message) error {
+	c.sent = append(c.sent, msg)
+	return nil
+}
+
+func testNode(id string) data.Node {
+	return data.Node{ID: id, ListenAddress: id + ":7000"}
+}
+
+// newFakeClock returns the clock standing still at a fixed time
+// along with the function moving it forward.
+func newFakeClock() (func() time.Time, func(d time.Duration)) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return func() time.Time { return now }, func(d time.Duration) { now = now.Add(d) }
+}
+
+// newMemNetworkTestNode starts a node with the options on the in-process network,
+// the node listens on its ID and does not shuffle on its own.
+func newMemNetworkTestNode(t testing.TB, network *transport.MemNetwork, id string, opts ...Option) *HyParView {
+	t.Helper()
+	self := data.Node{ID: id, ListenAddress: id}
+	connManager := transport.NewConnManager(self, network.NewConnFn(id), network.AcceptConnsFn(id))
+	config := DefaultConfig(100)
+	config.ShuffleInterval = time.Hour
+	h, err := NewHyParView(config, self, connManager, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.Stop)
+	return h
+}
+
+// newHandlerTestNode returns a node with the active view holding activePeers peers
+// and a half full passive view, all dials of the node fail.
+func newHandlerTestNode(t testing.TB, activePeers int) *HyParView {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(out) })
+	config := DefaultConfig(1000)
+	config.ShuffleInterval = time.Hour
+	self := testNode("self")
+	connManager := transport.NewConnManager(self, func(address string) (transport.Conn, error) {
+		return nil, errors.New("unreachable")
+	}, func(stopCh chan struct{}, handler func(conn transport.Conn)) error {
+		return nil
+	})
+	h, err := NewHyParView(config, self, connManager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.Stop)
+	for i := 0; i < min(activePeers, config.ActiveViewSize()); i++ {
+		node := testNode(fmt.Sprintf("active-%d", i))
+		h.activeView.add(Peer{node: node, conn: &testConn{remote: node}})
+	}
+	for i := 0; i < config.PassiveViewSize/2; i++ {
+		h.passiveView.Add(testNode(fmt.Sprintf("passive-%d", i)), "")
+	}
+	return h
+}
+
+// sender returns the conn of the active peer with the ID or a new conn to the node.
+func sender(h *HyParView, id string) transport.Conn {
+	for _, peer := range h.activeView.peers {
+		if peer.node.ID == id {
+			return peer.conn
+		}
+	}
+	return &testConn{remote: testNode(id)}
+}
+
+func testNodes(ids string) []data.Node {
+	nodes := make([]data.Node, 0)
+	for _, id := range strings.Split(ids, ",") {
+		if id != "" {
+			nodes = append(nodes, testNode(id))
+		}
+	}
+	return nodes
+}
+
+// checkViews fails the test if the views of the node break the protocol invariants.
+func checkViews(t testing.TB, h *HyParView) {
+	t.Helper()
+	if h.activeView.size() > h.activeViewSize() {
+		t.Errorf("active view size %d exceeds the capacity %d", h.activeView.size(), h.activeViewSize())
+	}
+	if h.passiveView.Size() > h.config.PassiveViewSize {
+		t.Errorf("passive view size %d exceeds the capacity %d", h.passiveView.Size(), h.config.PassiveViewSize)
+	}
+	seen := make(map[string]string)
+	for view, peers := range map[string][]Peer{"active": h.activeView.peers, "passive": h.passiveView.peers.peers} {
+		for _, peer := range peers {
+			if peer.node.ID == h.self.ID {
+				t.Errorf("self in the %s view", view)
+			}
+			if other, ok := seen[peer.node.ID]; ok {
+				t.Errorf("node %s in the %s and the %s view", peer.node.ID, other, view)
+			}
+			seen[peer.node.ID] = view
+		}
+	}
+	for _, peer := range h.activeView.peers {
+		if peer.conn == nil {
+			t.Errorf("active peer %s without a conn", peer.node.ID)
+		}
+	}
+}
+
+// handle passes the msg from the sender to its handler and checks the views afterwards,
+// the handler errors are expected for the invalid msgs. The lock is held as in onReeive
+// since the handler may dial nodes in the background.
+func handle(t testing.TB, h *HyParView, senderID string, msg data.Message) {
+	t.Helper()
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	received := transport.MsgReceived{Msg: msg, Sender: sender(h, senderID)}
+	_ = h.msgHandlers[msg.Type](received)
+	checkViews(t, h)
+}
+
+func FuzzOnJoin(f *testing.F) {
+	f.Add("new", "new", "new:7000", uint8(1))
+	f.Add("self", "self", "self:7000", uint8(6))
+	f.Add("active-0", "active-0", "active-0:7000", uint8(6))
+	f.Add("passive-0", "passive-0", "passive-0:7000", uint8(0))
+	f.Fuzz(func(t *testing.T, senderID, nodeID, address string, activePeers uint8) {
+		h := newHandlerTestNode(t, int(activePeers))
+		handle(t, h, senderID, data.Message{Type: data.JOIN, Payload: data.Join{NodeID: nodeID, ListenAddress: address}})
+	})
+}
+
+func FuzzOnJoinReply(f *testing.F) {
+	f.Add("active-0", "active-0", uint8(1))
+	f.Add("new", "new", uint8(6))
+	f.Add("new", "other", uint8(0))
+	f.Fuzz(func(t *testing.T, senderID, nodeID string, activePeers uint8) {
+		h := newHandlerTestNode(t, int(activePeers))
+		conn := sender(h, senderID)
+		h.joins[conn] = make(chan error, 1)
+		received := transport.MsgReceived{Sender: conn, Msg: data.Message{Type: data.JOIN_REPLY, Payload: data.JoinReply{NodeID: nodeID, ListenAddress: nodeID + ":7000"}}}
+		_ = h.onJoinReply(received)
+		checkViews(t, h)
+	})
+}
+
+func FuzzOnForwardJoin(f *testing.F) {
+	f.Add("active-0", "new", "new:7000", 0, uint8(1))
+	f.Add("active-0", "new", "new:7000", 3, uint8(1))
+	f.Add("stranger", "new", "new:7000", 5, uint8(0))
+	f.Add("active-0", "passive-0", "passive-0:7000", 3, uint8(4))
+	f.Add("active-0", "self", "self:7000", 3, uint8(4))
+	f.Fuzz(func(t *testing.T, senderID, nodeID, address string, ttl int, activePeers uint8) {
+		h := newHandlerTestNode(t, int(activePeers))
+		handle(t, h, senderID, data.Message{Type: data.FORWARD_JOIN, Payload: data.ForwardJoin{NodeID: nodeID, ListenAddress: address, TTL: ttl}})
+	})
+}
+
+func FuzzOnDisconnect(f *testing.F) {
+	f.Add("active-0", "active-0", uint8(3))
+	f.Add("stranger", "stranger", uint8(3))
+	f.Add("active-0", "active-1", uint8(3))
+	f.Fuzz(func(t *testing.T, senderID, nodeID string, activePeers uint8) {
+		h := newHandlerTestNode(t, int(activePeers))
+		handle(t, h, senderID, data.Message{Type: data.DISCONNECT, Payload: data.Disconnect{NodeID: nodeID}})
+	})
+}
+
+func FuzzOnNeighbor(f *testing.F) {
+	f.Add("new", "new", "new:7000", false, uint8(6))
+	f.Add("new", "new", "new:7000", true, uint8(6))
+	f.Add("active-0", "active-0", "active-0:7000", true, uint8(2))
+	f.Add("passive-0", "passive-0", "passive-0:7000", false, uint8(0))
+	f.Fuzz(func(t *testing.T, senderID, nodeID, address string, highPriority bool, activePeers uint8) {
+		h := newHandlerTestNode(t, int(activePeers))
+		handle(t, h, senderID, data.Message{Type: data.NEIGHTBOR, Payload: data.Neighbor{NodeID: nodeID, ListenAddress: address, HighPriority: highPriority}})
+	})
+}
+
+func FuzzOnNeighborReply(f *testing.F) {
+	f.Add("passive-0", "passive-0", true, uint8(2))
+	f.Add("passive-0", "passive-0", true, uint8(6))
+	f.Add("passive-0", "passive-0", false, uint8(2))
+	f.Add("active-0", "active-0", true, uint8(2))
+	f.Fuzz(func(t *testing.T, senderID, nodeID string, accepted bool, activePeers uint8) {
+		h := newHandlerTestNode(t, int(activePeers))
+		handle(t, h, senderID, data.Message{Type: data.NEIGHTBOR_REPLY, Payload: data.NeighborReply{NodeID: nodeID, Accepted: accepted}})
+	})
+}
+
+func FuzzOnShuffle(f *testing.F) {
+	f.Add("active-0", "origin", "origin:7000", "a,b,c", 1, uint8(3))
+	f.Add("active-0", "origin", "origin:7000", "a,b,c", 5, uint8(3))
+	f.Add("active-0", "origin", "origin:7000", "self,active-1,passive-0,a,a", 5, uint8(1))
+	f.Fuzz(func(t *testing.T, senderID, nodeID, address, nodes string, ttl int, activePeers uint8) {
+		h := newHandlerTestNode(t, int(activePeers))
+		handle(t, h, senderID, data.Message{Type: data.SHUFFLE, Payload: data.Shuffle{NodeID: nodeID, ListenAddress: address, Nodes: testNodes(nodes), TTL: ttl}})
+	})
+}
+
+func FuzzOnShuffleReply(f *testing.F) {
+	f.Add("origin", "a,b,c", "passive-0,passive-1", uint8(3))
+	f.Add("origin", "self,active-1,passive-0,a,a", "", uint8(3))
+	f.Add("origin", "a,b,c,d,e,f,g,h,i,j,k,l,m,n", "x,y", uint8(3))
+	f.Fuzz(func(t *testing.T, senderID, nodes, receivedNodes string, activePeers uint8) {
+		h := newHandlerTestNode(t, int(activePeers))
+		handle(t, h, senderID, data.Message{Type: data.SHUFFLE_REPLY, Payload: data.ShuffleReply{NodeID: senderID, Nodes: testNodes(nodes), ReceivedNodes: testNodes(receivedNodes)}})
+	})
+}
+
+func FuzzOnReject(f *testing.F) {
+	f.Add("passive-0", int8(data.NEIGHTBOR), uint8(2))
+	f.Add("active-0", int8(data.JOIN), uint8(2))
+	f.Fuzz(func(t *testing.T, senderID string, msgType int8, activePeers uint8) {
+		h := newHandlerTestNode(t, int(activePeers))
+		handle(t, h, senderID, data.Message{Type: data.REJECT, Payload: data.Reject{NodeID: senderID, MsgType: data.MessageType(msgType)}})
+	})
+}
+
+// randomMsg returns a random msg from one of the nodes, the few node IDs
+// make the msgs refer to the nodes already in the views.
+func randomMsg(r *rand.Rand, nodeIDs []string) (string, data.Message) {
+	pick := func() string { return nodeIDs[r.Intn(len(nodeIDs))] }
+	pickNodes := func() []data.Node {
+		nodes := make([]data.Node, r.Intn(8))
+		for i := range nodes {
+			nodes[i] = testNode(pick())
+		}
+		return nodes
+	}
+	senderID, nodeID := pick(), pick()
+	if r.Intn(2) == 0 {
+		nodeID = senderID
+	}
+	switch data.MessageType(r.Intn(int(data.SHUFFLE_REPLY) + 1)) {
+	case data.JOIN:
+		return senderID, data.Message{Type: data.JOIN, Payload: data.Join{NodeID: nodeID, ListenAddress: nodeID + ":7000"}}
+	case data.FORWARD_JOIN:
+		return senderID, data.Message{Type: data.FORWARD_JOIN, Payload: data.ForwardJoin{NodeID: nodeID, ListenAddress: nodeID + ":7000", TTL: r.Intn(8)}}
+	case data.DISCONNECT:
+		return senderID, data.Message{Type: data.DISCONNECT, Payload: data.Disconnect{NodeID: nodeID}}
+	case data.NEIGHTBOR:
+		return senderID, data.Message{Type: data.NEIGHTBOR, Payload: data.Neighbor{NodeID: nodeID, ListenAddress: nodeID + ":7000", HighPriority: r.Intn(2) == 0}}
+	case data.NEIGHTBOR_REPLY:
+		return senderID, data.Message{Type: data.NEIGHTBOR_REPLY, Payload: data.NeighborReply{NodeID: nodeID, Accepted: r.Intn(2) == 0}}
+	case data.SHUFFLE:
+		return senderID, data.Message{Type: data.SHUFFLE, Payload: data.Shuffle{NodeID: nodeID, ListenAddress: nodeID + ":7000", Nodes: pickNodes(), TTL: r.Intn(8)}}
+	default:
+		return senderID, data.Message{Type: data.SHUFFLE_REPLY, Payload: data.ShuffleReply{NodeID: senderID, Nodes: pickNodes(), ReceivedNodes: pickNodes()}}
+	}
+}
+
+func TestHandlersKeepViewInvariants(t *testing.T) {
+	nodeIDs := []string{"self", "new-0", "new-1", "new-2", "new-3", "new-4"}
+	for i := 0; i < 4; i++ {
+		nodeIDs = append(nodeIDs, fmt.Sprintf("active-%d", i), fmt.Sprintf("passive-%d", i))
+	}
+	for seed := int64(0); seed < 200; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		h := newHandlerTestNode(t, r.Intn(5))
+		for i := 0; i < 100; i++ {
+			senderID, msg := randomMsg(r, nodeIDs)
+			handle(t, h, senderID, msg)
+			if t.Failed() {
+				t.Fatalf("seed %d, msg %d: %s from %s: %+v", seed, i, msg.Type, senderID, msg.Payload)
+			}
+		}
+	}
+}
+
+func TestShuffleForwardDecrementsTTL(t *testing.T) {
+	h := newHandlerTestNode(t, 3)
+	handle(t, h, "active-0", data.Message{Type: data.SHUFFLE, Payload: data.Shuffle{NodeID: "origin", ListenAddress: "origin:7000", TTL: 5}})
+	var forwarded []data.Message
+	for _, peer := range h.activeView.peers {
+		forwarded = append(forwarded, peer.conn.(*testConn).sent...)
+	}
+	if len(forwarded) != 1 {
+		t.Fatalf("shuffle forwarded %d times, want once", len(forwarded))
+	}
+	if ttl := forwarded[0].Payload.(data.Shuffle).TTL; ttl != 4 {
+		t.Errorf("shuffle forwarded with TTL %d, want 4", ttl)
+	}
+	if slices.ContainsFunc(h.activeView.peers, func(peer Peer) bool {
+		return peer.node.ID == "active-0" && len(peer.conn.(*testConn).sent) > 0
+	}) {
+		t.Error("shuffle forwarded back to the sender")
+	}
+}
+
+func TestShufflesSampleTheViews(t *testing.T) {
+	h := newHandlerTestNode(t, 1)
+	samples := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		if err := h.Shuffle(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, msg := range h.activeView.peers[0].conn.(*testConn).sent {
+		ids := make([]string, 0)
+		for _, node := range msg.Payload.(data.Shuffle).Nodes {
+			ids = append(ids, node.ID)
+		}
+		if len(ids) != 1+h.config.Kp {
+			t.Fatalf("shuffle sent %d nodes, want %d", len(ids), 1+h.config.Kp)
+		}
+		slices.Sort(ids)
+		if len(slices.Compact(ids)) != len(ids) {
+			t.Fatalf("shuffle sent duplicate nodes %v", ids)
+		}
+		samples[strings.Join(ids, ",")] = true
+	}
+	if len(samples) < 2 {
+		t.Errorf("20 shuffles sent the same sample %v", samples)
+	}
+}
+
+func TestNeighborReplyLinksNodeGoneFromPassiveView(t *testing.T) {
+	h := newHandlerTestNode(t, 1)
+	handle(t, h, "gone", data.Message{Type: data.NEIGHTBOR_REPLY, Payload: data.NeighborReply{NodeID: "gone", ListenAddress: "gone:7000", Accepted: true}})
+	if !h.activeView.contains("gone") {
+		t.Error("node that accepted the link not added to the active view after leaving the passive view")
+	}
+}
+
+func TestSlowDialHoldsUpNoOtherCall(t *testing.T) {
+	h := newHandlerTestNode(t, 2)
+	dialing := make(chan struct{}, 1)
+	release := make(chan struct{})
+	// the origin of the shuffle does not answer the dial until released
+	h.connManager = transport.NewConnManager(h.self, func(address string) (transport.Conn, error) {
+		dialing <- struct{}{}
+		<-release
+		return nil, errors.New("unreachable")
+	}, nil)
+	defer h.dials.Wait()
+	defer close(release)
+
+	handled := make(chan struct{})
+	go func() {
+		h.onReeive(transport.MsgReceived{
+			Sender: sender(h, "active-0"),
+			Msg:    data.Message{Type: data.SHUFFLE, Payload: data.Shuffle{NodeID: "origin", ListenAddress: "origin:7000", TTL: 1}},
+		})
+		close(handled)
+	}()
+	for name, done := range map[string]chan struct{}{"origin dialed": dialing, "shuffle handled": handled} {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("%s not within a second", name)
+		}
+	}
+	peers := make(chan []Peer, 1)
+	go func() {
+		peers <- h.GetPeers()
+	}()
+	select {
+	case <-peers:
+	case <-time.After(time.Second):
+		t.Fatal("GetPeers held up by the dial of the shuffle origin")
+	}
+}
+
+// discardConn is a testConn that does not record the sent msgs.
+type discardConn struct {
+	testConn
+}
+
+func (c *discardConn) Send(msg data.Message) error {
+	return nil
+}
+
+// BenchmarkJoinStorm makes the node with a full active view
+// handle joins from many new nodes.
+func BenchmarkJoinStorm(b *testing.B) {
+	for _, size := range benchmarkPassiveViewSizes {
+		b.Run(fmt.Sprintf("passive=%d", size), func(b *testing.B) {
+			h := newBenchmarkNode(b, size)
+			joins := make([]transport.MsgReceived, 1024)
+			for i := range joins {
+				node := testNode(fmt.Sprintf("joining-%d", i))
+				joins[i] = transport.MsgReceived{
+					Msg:    data.Message{Type: data.JOIN, Payload: data.Join{NodeID: node.ID, ListenAddress: node.ListenAddress}},
+					Sender: &discardConn{testConn{remote: node}},
+				}
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_ = h.onJoin(joins[i%len(joins)])
+			}
+		})
+	}
+}
+
+// BenchmarkShuffleReply integrates the shuffle samples of new nodes into the full passive view.
+func BenchmarkShuffleReply(b *testing.B) {
+	for _, size := range benchmarkPassiveViewSizes {
+		b.Run(fmt.Sprintf("passive=%d", size), func(b *testing.B) {
+			h := newBenchmarkNode(b, size)
+			replies := make([]transport.MsgReceived, 1024)
+			for i := range replies {
+				nodes := make([]data.Node, h.config.Ka+h.config.Kp)
+				for j := range nodes {
+					nodes[j] = testNode(fmt.Sprintf("shuffled-%d-%d", i, j))
+				}
+				sender := testNode(fmt.Sprintf("sender-%d", i))
+				replies[i] = transport.MsgReceived{
+					Msg:    data.Message{Type: data.SHUFFLE_REPLY, Payload: data.ShuffleReply{NodeID: sender.ID, Nodes: nodes, ReceivedNodes: nodes[:h.config.Kp]}},
+					Sender: &discardConn{testConn{remote: sender}},
+				}
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_ = h.onShuffleReply(replies[i%len(replies)])
+			}
+		})
+	}
+}
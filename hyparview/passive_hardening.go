@@ -0,0 +1,96 @@
+package hyparview
+
+import (
+	"net"
+
+	"github.com/tamararankovic/hyparview/data"
+	"github.com/tamararankovic/hyparview/transport"
+)
+
+const (
+	defaultPrefixLengthV4 = 24
+	defaultPrefixLengthV6 = 48
+)
+
+// source returns the node the passive view entries received in the msg on behalf of the node are counted against:
+// the node itself if it sent or signed the msg, otherwise the sender that could have made the entries up.
+func (h *HyParView) source(received transport.MsgReceived, nodeID string) string {
+	if h.firstHand(received, nodeID) {
+		return nodeID
+	}
+	return received.Sender.GetRemoteNode().ID
+}
+
+// admit checks the node learned from the origin against
+// the per source and per address prefix caps.
+func (p *PassiveView) admit(node data.Node, origin string) bool {
+	if p.config.MaxPassivePerSource > 0 {
+		fromSource := 0
+		for _, peer := range p.peers.peers {
+			if peer.origin == origin {
+				fromSource++
+			}
+		}
+		if fromSource >= p.config.MaxPassivePerSource {
+			return false
+		}
+	}
+	if p.config.MaxPassivePerPrefix > 0 {
+		prefix := p.addressPrefix(node.ListenAddress)
+		inPrefix := 0
+		for _, peer := range p.peers.peers {
+			if p.addressPrefix(peer.node.ListenAddress) == prefix {
+				inPrefix++
+			}
+		}
+		if inPrefix >= p.config.MaxPassivePerPrefix {
+			return false
+		}
+	}
+	return true
+}
+
+// diverseEvictionIndex picks a random passive view entry among the ones
+// learned from the most represented origin, it returns -1 if the view is empty.
+func (p *PassiveView) diverseEvictionIndex() int {
+	if p.Size() == 0 {
+		return -1
+	}
+	byOrigin := make(map[string][]int)
+	for i, peer := range p.peers.peers {
+		byOrigin[peer.origin] = append(byOrigin[peer.origin], i)
+	}
+	// the ties go to the origin of the first entry in the view so that a seeded intn picks the same entries
+	var candidates []int
+	for _, peer := range p.peers.peers {
+		if indices := byOrigin[peer.origin]; len(indices) > len(candidates) {
+			candidates = indices
+		}
+	}
+	return candidates[p.intn(len(candidates))]
+}
+
+// addressPrefix returns the network block of the address host,
+// host names are treated as blocks of their own.
+func (p *PassiveView) addressPrefix(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		bits := p.config.PrefixLengthV4
+		if bits <= 0 {
+			bits = defaultPrefixLengthV4
+		}
+		return ip4.Mask(net.CIDRMask(bits, 32)).String()
+	}
+	bits := p.config.PrefixLengthV6
+	if bits <= 0 {
+		bits = defaultPrefixLengthV6
+	}
+	return ip.Mask(net.CIDRMask(bits, 128)).String()
+}
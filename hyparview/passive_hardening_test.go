@@ -0,0 +1,130 @@
+package hyparview
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/tamararankovic/hyparview/data"
+	"github.com/tamararankovic/hyparview/transport"
+)
+
+// newPassiveViewTestNode returns the node along with the seeded random source its passive view evicts with.
+func newPassiveViewTestNode(config HyParViewConfig, seed int64) (*HyParView, *rand.Rand) {
+	r := rand.New(rand.NewSource(seed))
+	h := &HyParView{
+		self:        data.Node{ID: "self", ListenAddress: "192.168.0.1:7000"},
+		config:      config,
+		activeView:  newView(),
+		passiveView: NewPassiveView("self", config),
+		reputation:  NewReputation(DefaultReputationConfig()),
+	}
+	h.passiveView.intn = r.Intn
+	return h, r
+}
+
+// simulateEclipse interleaves shuffles from honest nodes with shuffles
+// from sybil identities flooding attacker addresses and returns
+// the share of the passive view taken by the attacker.
+func simulateEclipse(h *HyParView, r *rand.Rand, rounds int) float64 {
+	honest := make([]data.Node, 100)
+	for i := range honest {
+		honest[i] = data.Node{ID: fmt.Sprintf("honest-%d", i), ListenAddress: fmt.Sprintf("10.%d.%d.1:7000", i/250, i%250)}
+	}
+	attackerNodes := 0
+	for round := 0; round < rounds; round++ {
+		source := honest[r.Intn(len(honest))]
+		nodes := make([]data.Node, 3)
+		for i := range nodes {
+			nodes[i] = honest[r.Intn(len(honest))]
+		}
+		h.integrateNodesIntoPartialView(nodes, nil, source.ID)
+
+		sybil := fmt.Sprintf("sybil-%d", r.Intn(10))
+		nodes = make([]data.Node, 10)
+		for i := range nodes {
+			nodes[i] = data.Node{ID: fmt.Sprintf("attacker-%d", attackerNodes), ListenAddress: fmt.Sprintf("66.6.%d.%d:7000", attackerNodes%4, attackerNodes%250)}
+			attackerNodes++
+		}
+		h.integrateNodesIntoPartialView(nodes, nil, sybil)
+	}
+	attackerEntries := 0
+	for _, peer := range h.passiveView.peers.peers {
+		if strings.HasPrefix(peer.node.ID, "attacker") {
+			attackerEntries++
+		}
+	}
+	return float64(attackerEntries) / float64(h.passiveView.Size())
+}
+
+func TestPassiveViewEclipseResistance(t *testing.T) {
+	config := HyParViewConfig{
+		PassiveViewSize: 20,
+	}
+	h, r := newPassiveViewTestNode(config, 1)
+	unhardened := simulateEclipse(h, r, 200)
+
+	config.MaxPassivePerSource = 3
+	config.MaxPassivePerPrefix = 2
+	config.PreferDiverseOrigins = true
+	h, r = newPassiveViewTestNode(config, 1)
+	hardened := simulateEclipse(h, r, 200)
+
+	t.Logf("attacker share of the passive view: unhardened %.2f, hardened %.2f", unhardened, hardened)
+	if unhardened <= 0.5 {
+		t.Errorf("expected the attacker to dominate the unhardened passive view, got share %.2f", unhardened)
+	}
+	// four attacker /24 blocks with at most two entries each
+	if maxShare := 8.0 / float64(config.PassiveViewSize); hardened > maxShare {
+		t.Errorf("attacker share of the hardened passive view %.2f exceeds %.2f", hardened, maxShare)
+	}
+	if h.passiveView.Size() > config.PassiveViewSize {
+		t.Errorf("passive view size %d exceeds %d", h.passiveView.Size(), config.PassiveViewSize)
+	}
+}
+
+func TestAddressPrefix(t *testing.T) {
+	h, _ := newPassiveViewTestNode(HyParViewConfig{}, 1)
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"10.0.0.1:7000", "10.0.0.200:7001", true},
+		{"10.0.0.1:7000", "10.0.1.1:7000", false},
+		{"[2001:db8:1::1]:7000", "[2001:db8:1:ffff::1]:7000", true},
+		{"[2001:db8:1::1]:7000", "[2001:db8:2::1]:7000", false},
+		{"node-a:7000", "node-b:7000", false},
+	}
+	for _, tt := range tests {
+		if same := h.passiveView.addressPrefix(tt.a) == h.passiveView.addressPrefix(tt.b); same != tt.same {
+			t.Errorf("addressPrefix(%s) == addressPrefix(%s) = %v, want %v", tt.a, tt.b, same, tt.same)
+		}
+	}
+}
+
+func TestPassiveCapKeyedOnSender(t *testing.T) {
+	h := newHandlerTestNode(t, 2)
+	h.passiveView.config.MaxPassivePerSource = 2
+	// without an identity the node IDs the sender claims to reply on behalf of are not counted
+	for i := 0; i < 5; i++ {
+		h.onReeive(transport.MsgReceived{
+			Sender: sender(h, "active-0"),
+			Msg: data.Message{Type: data.SHUFFLE_REPLY, Payload: data.ShuffleReply{
+				NodeID: fmt.Sprintf("claimed-%d", i),
+				Nodes:  testNodes(fmt.Sprintf("sybil-%d-a,sybil-%d-b", i, i)),
+			}},
+		})
+	}
+	fromSender := 0
+	for _, peer := range h.passiveView.peers.peers {
+		if peer.origin == "active-0" {
+			fromSender++
+		} else if peer.origin != "" {
+			t.Errorf("passive entry %s counted against %s, want the sender", peer.node.ID, peer.origin)
+		}
+	}
+	if fromSender != 2 {
+		t.Errorf("%d passive entries from the sender, want the cap of 2", fromSender)
+	}
+}
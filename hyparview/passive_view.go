@@ -0,0 +1,124 @@
+package hyparview
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/tamararankovic/hyparview/data"
+)
+
+// PassiveView holds the backup nodes the active view is repaired from.
+// It keeps the rules of the paper: the view never holds the node itself or
+// a node twice and never grows over its size, a full view evicts an entry
+// to make room for a new one. The nodes sent in a shuffle are evicted first,
+// then a random entry or, if diverse origins are preferred, an entry learned
+// from the most represented origin. It is not safe for concurrent use,
+// a HyParView node accesses it under its lock.
+type PassiveView struct {
+	self   string
+	config HyParViewConfig
+	peers  *view
+	// intn picks the evicted entries
+	intn func(n int) int
+}
+
+func NewPassiveView(self string, config HyParViewConfig) *PassiveView {
+	return &PassiveView{
+		self:   self,
+		config: config,
+		peers:  newView(),
+		intn:   rand.Intn,
+	}
+}
+
+func (p *PassiveView) Size() int {
+	return p.peers.size()
+}
+
+func (p *PassiveView) Capacity() int {
+	return p.config.PassiveViewSize
+}
+
+func (p *PassiveView) Full() bool {
+	return p.Size() >= p.Capacity()
+}
+
+func (p *PassiveView) Contains(nodeID string) bool {
+	return p.peers.contains(nodeID)
+}
+
+// Peers returns a copy of the entries.
+func (p *PassiveView) Peers() []Peer {
+	return p.peers.list()
+}
+
+// Add adds the node learned from the origin, evicting an entry if the view is full.
+// It returns false if the node is this node, is already in the view
+// or is refused by the per source and per address prefix caps.
+func (p *PassiveView) Add(node data.Node, origin string) bool {
+	return p.Integrate([]data.Node{node}, nil, origin) == 1
+}
+
+// Demote adds the node dropped from the active view,
+// the node is its own origin since this node knew it first hand.
+func (p *PassiveView) Demote(node data.Node) bool {
+	return p.Add(node, node.ID)
+}
+
+// Integrate adds the nodes received in a shuffle from the origin and returns
+// how many were added. The nodes this node sent in the shuffle make room
+// for the received ones before any other entries are evicted.
+func (p *PassiveView) Integrate(nodes, sent []data.Node, origin string) int {
+	if p.Capacity() < 1 {
+		return 0
+	}
+	added := 0
+	// the sent nodes before evicted were already tried
+	evicted := 0
+	for _, node := range nodes {
+		if node.ID == p.self || p.Contains(node.ID) || !p.admit(node, origin) {
+			continue
+		}
+		if p.Full() {
+			removed := false
+			for ; evicted < len(sent) && !removed; evicted++ {
+				_, removed = p.peers.remove(sent[evicted].ID)
+			}
+			if !removed {
+				p.peers.removeAt(p.evictionIndex())
+			}
+		}
+		p.peers.add(Peer{node: node, origin: origin, lastSeen: time.Now()})
+		added++
+	}
+	return added
+}
+
+func (p *PassiveView) Remove(nodeID string) (Peer, bool) {
+	return p.peers.remove(nodeID)
+}
+
+// setConfig applies the updated config and evicts the entries over the new size.
+func (p *PassiveView) setConfig(config HyParViewConfig) {
+	p.config = config
+	for p.Size() > p.Capacity() {
+		p.peers.removeAt(p.evictionIndex())
+	}
+}
+
+func (p *PassiveView) get(nodeID string) *Peer {
+	return p.peers.get(nodeID)
+}
+
+// sample returns copies of up to n random entries.
+func (p *PassiveView) sample(n int) []Peer {
+	return p.peers.sample(n, p.intn)
+}
+
+// evictionIndex picks the entry to evict from the non-empty view.
+func (p *PassiveView) evictionIndex() int {
+	if p.config.PreferDiverseOrigins {
+		return p.diverseEvictionIndex()
+	}
+	return p.intn(p.Size())
+}
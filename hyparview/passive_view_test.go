@@ -0,0 +1,166 @@
+package hyparview
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/tamararankovic/hyparview/data"
+)
+
+func passiveViewIDs(p *PassiveView) map[string]bool {
+	ids := make(map[string]bool)
+	for _, peer := range p.Peers() {
+		ids[peer.node.ID] = true
+	}
+	return ids
+}
+
+func TestPassiveViewAdd(t *testing.T) {
+	p := NewPassiveView("self", HyParViewConfig{PassiveViewSize: 4})
+	if p.Add(testNode("self"), "origin") {
+		t.Error("the node added itself to its passive view")
+	}
+	if !p.Add(testNode("a"), "origin") {
+		t.Error("node a not added to the empty passive view")
+	}
+	if p.Add(testNode("a"), "other") || p.Size() != 1 {
+		t.Errorf("node a added twice, passive view size %d", p.Size())
+	}
+	// a full view evicts an entry to make room for the new node
+	for i := 0; i < 10; i++ {
+		node := testNode(fmt.Sprintf("node-%d", i))
+		if !p.Add(node, "origin") {
+			t.Errorf("node %s not added to the passive view", node.ID)
+		}
+		if !p.Contains(node.ID) {
+			t.Errorf("node %s added but not in the passive view", node.ID)
+		}
+		if p.Size() > p.Capacity() {
+			t.Fatalf("passive view size %d exceeds the capacity %d", p.Size(), p.Capacity())
+		}
+	}
+	if !p.Full() {
+		t.Errorf("passive view with %d of %d entries not full", p.Size(), p.Capacity())
+	}
+}
+
+func TestPassiveViewWithoutCapacity(t *testing.T) {
+	p := NewPassiveView("self", HyParViewConfig{PassiveViewSize: 0})
+	if p.Add(testNode("a"), "origin") || p.Demote(testNode("b")) || p.Size() != 0 {
+		t.Errorf("passive view of size 0 holds %d entries", p.Size())
+	}
+}
+
+func TestPassiveViewIntegrateEvictsSentNodesFirst(t *testing.T) {
+	p := NewPassiveView("self", HyParViewConfig{PassiveViewSize: 4})
+	p.Integrate(testNodes("a,b,c,d"), nil, "origin")
+	// the unknown sent node x can not make room, b is the next one tried
+	added := p.Integrate(testNodes("e,f"), testNodes("x,a,b"), "peer")
+	if added != 2 {
+		t.Errorf("integrated %d nodes, want 2", added)
+	}
+	ids := passiveViewIDs(p)
+	for _, id := range []string{"c", "d", "e", "f"} {
+		if !ids[id] {
+			t.Errorf("node %s not in the passive view %v", id, ids)
+		}
+	}
+	if ids["a"] || ids["b"] {
+		t.Errorf("sent nodes a and b not evicted from the passive view %v", ids)
+	}
+}
+
+func TestPassiveViewIntegrateSkipsSelfAndDuplicates(t *testing.T) {
+	p := NewPassiveView("self", HyParViewConfig{PassiveViewSize: 4})
+	p.Add(testNode("a"), "origin")
+	// nothing is evicted for the skipped nodes
+	added := p.Integrate(testNodes("self,a,b,b"), testNodes("a"), "peer")
+	if added != 1 || p.Size() != 2 || !p.Contains("a") || !p.Contains("b") {
+		t.Errorf("integrated %d nodes into the passive view %v, want a and b", added, passiveViewIDs(p))
+	}
+}
+
+func TestPassiveViewDiverseEviction(t *testing.T) {
+	p := NewPassiveView("self", HyParViewConfig{PassiveViewSize: 4, PreferDiverseOrigins: true})
+	p.Integrate(testNodes("a,b,c"), nil, "flooder")
+	p.Add(testNode("d"), "honest")
+	p.Integrate(testNodes("e,f"), nil, "other")
+	if !p.Contains("d") {
+		t.Errorf("the only entry from the honest origin evicted from the passive view %v", passiveViewIDs(p))
+	}
+	fromFlooder := 0
+	for _, peer := range p.Peers() {
+		if peer.origin == "flooder" {
+			fromFlooder++
+		}
+	}
+	if fromFlooder != 1 {
+		t.Errorf("%d entries from the flooder left, want the two evicted", fromFlooder)
+	}
+}
+
+func TestPassiveViewSetConfig(t *testing.T) {
+	p := NewPassiveView("self", HyParViewConfig{PassiveViewSize: 8})
+	p.Integrate(testNodes("a,b,c,d,e,f,g,h"), nil, "origin")
+	p.setConfig(HyParViewConfig{PassiveViewSize: 3})
+	if p.Size() != 3 || p.Capacity() != 3 {
+		t.Errorf("passive view %d/%d after the shrink, want 3/3", p.Size(), p.Capacity())
+	}
+}
+
+func TestDisconnectRandomPeerDemotesToPassiveView(t *testing.T) {
+	h := newHandlerTestNode(t, 3)
+	if err := h.disconnectRandomPeer(nil); err != nil {
+		t.Fatal(err)
+	}
+	if h.activeView.size() != 2 {
+		t.Fatalf("active view size %d, want 2", h.activeView.size())
+	}
+	for i := 0; i < 3; i++ {
+		id := fmt.Sprintf("active-%d", i)
+		if !h.activeView.contains(id) && !h.passiveView.Contains(id) {
+			t.Errorf("disconnected peer %s not demoted to the passive view", id)
+		}
+	}
+	checkViews(t, h)
+}
+
+func TestOnDisconnectDemotesToPassiveView(t *testing.T) {
+	h := newHandlerTestNode(t, 3)
+	handle(t, h, "active-0", data.Message{Type: data.DISCONNECT, Payload: data.Disconnect{NodeID: "active-0"}})
+	if h.activeView.contains("active-0") {
+		t.Fatal("disconnected peer still in the active view")
+	}
+	// the failed dials to the other candidates empty the rest of the passive view
+	if !h.passiveView.Contains("active-0") {
+		t.Error("disconnected peer not demoted to the passive view")
+	}
+}
+
+func TestPromotionRemovesFromPassiveView(t *testing.T) {
+	h := newHandlerTestNode(t, 1)
+	node := testNode("passive-0")
+	h.addPeer(Peer{node: node, conn: &testConn{remote: node}})
+	if h.passiveView.Contains(node.ID) {
+		t.Error("promoted node still in the passive view")
+	}
+	checkViews(t, h)
+}
+
+func TestForwardJoinAddsToPassiveViewAtPRWL(t *testing.T) {
+	tests := []struct {
+		ttl     func(config HyParViewConfig) int
+		passive bool
+	}{
+		{func(config HyParViewConfig) int { return config.PRWL }, true},
+		{func(config HyParViewConfig) int { return config.PRWL + 1 }, false},
+	}
+	for _, tt := range tests {
+		h := newHandlerTestNode(t, 3)
+		ttl := tt.ttl(h.config)
+		handle(t, h, "active-0", data.Message{Type: data.FORWARD_JOIN, Payload: data.ForwardJoin{NodeID: "new", ListenAddress: "new:7000", TTL: ttl}})
+		if passive := h.passiveView.Contains("new"); passive != tt.passive {
+			t.Errorf("forward join with TTL %d (PRWL %d): joining node in the passive view %v, want %v", ttl, h.config.PRWL, passive, tt.passive)
+		}
+	}
+}
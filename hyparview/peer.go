@@ -1,11 +1,26 @@
 package hyparview
 
 import (
-	"github.com/tamararankovic/hyparview/transport"
+	"time"
+
 	"github.com/tamararankovic/hyparview/data"
+	"github.com/tamararankovic/hyparview/transport"
 )
 
 type Peer struct {
 	node data.Node
 	conn transport.Conn
+	// origin is the ID of the node the peer was learned from
+	origin string
+	// lastSeen is the time the passive view peer was added or restored
+	lastSeen time.Time
+}
+
+func (p Peer) Node() data.Node {
+	return p.node
+}
+
+// Conn returns the conn to the peer, nil for the passive view peers.
+func (p Peer) Conn() transport.Conn {
+	return p.conn
 }
@@ -0,0 +1,103 @@
+package hyparview
+
+import (
+	"sync"
+	"time"
+
+	"github.com/tamararankovic/hyparview/data"
+)
+
+// allMsgTypes is the bucket key of the limit applied to all the msgs of a peer.
+const allMsgTypes data.MessageType = -1
+
+const bucketPruneInterval = time.Minute
+
+type bucketKey struct {
+	nodeID  string
+	msgType data.MessageType
+}
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// rateLimiter applies the token bucket limits per remote peer and per msg type.
+type rateLimiter struct {
+	peerLimit RateLimit
+	msgLimits map[data.MessageType]RateLimit
+	buckets   map[bucketKey]*tokenBucket
+	prunedAt  time.Time
+	now       func() time.Time
+	lock      sync.Mutex
+}
+
+func newRateLimiter(peerLimit RateLimit, msgLimits map[data.MessageType]RateLimit) *rateLimiter {
+	return &rateLimiter{
+		peerLimit: peerLimit,
+		msgLimits: msgLimits,
+		buckets:   make(map[bucketKey]*tokenBucket),
+		prunedAt:  time.Now(),
+		now:       time.Now,
+	}
+}
+
+// setLimits replaces the limits, the tokens left in the buckets are kept
+// and capped by the new bursts on the next refill.
+func (r *rateLimiter) setLimits(peerLimit RateLimit, msgLimits map[data.MessageType]RateLimit) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.peerLimit = peerLimit
+	r.msgLimits = msgLimits
+}
+
+// allow takes a token from the buckets of the peer, it returns false
+// if any of the limits applying to the msg is exceeded.
+func (r *rateLimiter) allow(nodeID string, msgType data.MessageType) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	now := r.now()
+	if now.Sub(r.prunedAt) > bucketPruneInterval {
+		r.prune(now)
+	}
+	peerBucket := r.refill(bucketKey{nodeID: nodeID, msgType: allMsgTypes}, r.peerLimit, now)
+	msgBucket := r.refill(bucketKey{nodeID: nodeID, msgType: msgType}, r.msgLimits[msgType], now)
+	if (peerBucket != nil && peerBucket.tokens < 1) || (msgBucket != nil && msgBucket.tokens < 1) {
+		return false
+	}
+	if peerBucket != nil {
+		peerBucket.tokens--
+	}
+	if msgBucket != nil {
+		msgBucket.tokens--
+	}
+	return true
+}
+
+func (r *rateLimiter) refill(key bucketKey, limit RateLimit, now time.Time) *tokenBucket {
+	if limit.Rate <= 0 {
+		return nil
+	}
+	bucket, ok := r.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), updatedAt: now}
+		r.buckets[key] = bucket
+	}
+	bucket.tokens = min(float64(limit.Burst), bucket.tokens+now.Sub(bucket.updatedAt).Seconds()*limit.Rate)
+	bucket.updatedAt = now
+	return bucket
+}
+
+// prune drops the buckets that would be full by now, e.g. the ones of the disconnected peers.
+func (r *rateLimiter) prune(now time.Time) {
+	for key, bucket := range r.buckets {
+		limit := r.peerLimit
+		if key.msgType != allMsgTypes {
+			limit = r.msgLimits[key.msgType]
+		}
+		if limit.Rate <= 0 || bucket.tokens+now.Sub(bucket.updatedAt).Seconds()*limit.Rate >= float64(limit.Burst) {
+			delete(r.buckets, key)
+		}
+	}
+	r.prunedAt = now
+}
@@ -0,0 +1,107 @@
+package hyparview
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tamararankovic/hyparview/data"
+	"github.com/tamararankovic/hyparview/transport"
+)
+
+// newTestRateLimiter returns the rate limiter along with the function moving its clock forward.
+func newTestRateLimiter(peerLimit RateLimit, msgLimits map[data.MessageType]RateLimit) (*rateLimiter, func(d time.Duration)) {
+	now, advance := newFakeClock()
+	r := newRateLimiter(peerLimit, msgLimits)
+	r.now = now
+	r.prunedAt = now()
+	return r, advance
+}
+
+// allowed returns how many of the n msgs the rate limiter lets through.
+func allowed(r *rateLimiter, nodeID string, msgType data.MessageType, n int) int {
+	count := 0
+	for i := 0; i < n; i++ {
+		if r.allow(nodeID, msgType) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestRateLimitBurst(t *testing.T) {
+	r, _ := newTestRateLimiter(RateLimit{Rate: 1, Burst: 5}, nil)
+	if n := allowed(r, "a", data.SHUFFLE, 10); n != 5 {
+		t.Errorf("%d msgs of a burst allowed, want 5", n)
+	}
+}
+
+func TestRateLimitRefill(t *testing.T) {
+	r, advance := newTestRateLimiter(RateLimit{Rate: 2, Burst: 4}, nil)
+	allowed(r, "a", data.SHUFFLE, 4)
+	advance(time.Second)
+	if n := allowed(r, "a", data.SHUFFLE, 10); n != 2 {
+		t.Errorf("%d msgs allowed a second after the burst, want 2", n)
+	}
+	advance(500 * time.Millisecond)
+	if n := allowed(r, "a", data.SHUFFLE, 10); n != 1 {
+		t.Errorf("%d msgs allowed half a second later, want 1", n)
+	}
+	// the refill is capped by the burst
+	advance(time.Hour)
+	if n := allowed(r, "a", data.SHUFFLE, 10); n != 4 {
+		t.Errorf("%d msgs allowed after an idle hour, want the burst of 4", n)
+	}
+}
+
+func TestRateLimitPerPeer(t *testing.T) {
+	r, _ := newTestRateLimiter(RateLimit{Rate: 1, Burst: 3}, nil)
+	allowed(r, "a", data.SHUFFLE, 10)
+	if n := allowed(r, "b", data.SHUFFLE, 10); n != 3 {
+		t.Errorf("%d msgs of b allowed after a exceeded its limit, want 3", n)
+	}
+}
+
+func TestRateLimitPerMsgType(t *testing.T) {
+	r, _ := newTestRateLimiter(RateLimit{Rate: 1, Burst: 10}, map[data.MessageType]RateLimit{
+		data.JOIN: {Rate: 1, Burst: 2},
+	})
+	if n := allowed(r, "a", data.JOIN, 5); n != 2 {
+		t.Errorf("%d joins allowed, want 2", n)
+	}
+	// the msgs of the other types only count against the peer limit, the denied joins took no token
+	if n := allowed(r, "a", data.SHUFFLE, 20); n != 8 {
+		t.Errorf("%d shuffles allowed, want the 8 tokens left of the peer limit", n)
+	}
+}
+
+func TestRateLimitPrunesFullBuckets(t *testing.T) {
+	r, advance := newTestRateLimiter(RateLimit{Rate: 1, Burst: 3}, nil)
+	allowed(r, "a", data.SHUFFLE, 3)
+	advance(bucketPruneInterval + time.Second)
+	allowed(r, "b", data.SHUFFLE, 1)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.buckets[bucketKey{nodeID: "a", msgType: allMsgTypes}]; ok {
+		t.Error("refilled bucket of an idle peer kept")
+	}
+}
+
+func TestRateLimitExceededPenalized(t *testing.T) {
+	h := newHandlerTestNode(t, 2)
+	now := time.Now()
+	h.reputation.now = func() time.Time { return now }
+	h.rateLimiter.now = func() time.Time { return now }
+	h.rateLimiter.setLimits(RateLimit{Rate: 1, Burst: 1}, nil)
+	shuffle := transport.MsgReceived{
+		Sender: sender(h, "active-0"),
+		Msg:    data.Message{Type: data.SHUFFLE, Payload: data.Shuffle{NodeID: "active-0", ListenAddress: "active-0:7000", TTL: 1}},
+	}
+	h.onReeive(shuffle)
+	if score := h.reputation.Score(hostKey("active-0:7000")); score != 0 {
+		t.Fatalf("score %g within the rate limit, want 0", score)
+	}
+	h.onReeive(shuffle)
+	if score := h.reputation.Score(hostKey("active-0:7000")); score != penalties[RateViolation] {
+		t.Errorf("score %g after exceeding the rate limit, want %g", score, penalties[RateViolation])
+	}
+}
@@ -0,0 +1,76 @@
+package hyparview
+
+import (
+	"fmt"
+	"log"
+)
+
+// Config returns the protocol config currently in use.
+func (h *HyParView) Config() HyParViewConfig {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.config
+}
+
+// UpdateConfig applies the config without restarting the node.
+// Surplus active peers are disconnected and surplus passive view entries dropped
+// when the views shrink, the active view is filled from the passive view when it grows
+// and the shuffle timer is restarted when the interval changes.
+func (h *HyParView) UpdateConfig(config HyParViewConfig) error {
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if err := h.checkLocality(config); err != nil {
+		return err
+	}
+	old := h.config
+	h.config = config
+	h.rateLimiter.setLimits(config.PeerRateLimit, config.MsgRateLimits)
+	if config.ShuffleInterval != old.ShuffleInterval {
+		// drop the interval the shuffle loop has not picked up yet
+		select {
+		case <-h.shuffleInterval:
+		default:
+		}
+		h.shuffleInterval <- config.ShuffleInterval
+	}
+	// the passive view shrinks first to make room for the demoted peers
+	h.passiveView.setConfig(config)
+	h.shrinkActiveView()
+	h.growActiveView()
+	log.Printf("config updated, active view %d/%d, passive view %d/%d\n",
+		h.activeView.size(), h.activeViewSize(), h.passiveView.Size(), config.PassiveViewSize)
+	return nil
+}
+
+// shrinkActiveView disconnects random peers until the active view fits its size.
+func (h *HyParView) shrinkActiveView() {
+	for h.activeView.size() > h.activeViewSize() {
+		size := h.activeView.size()
+		err := h.disconnectRandomPeer(nil)
+		if err != nil {
+			log.Println(err)
+		}
+		if h.activeView.size() == size {
+			return
+		}
+	}
+}
+
+// growActiveView asks a distinct passive view node to become a neighbor
+// for each free slot in the active view.
+func (h *HyParView) growActiveView() {
+	blacklist := make([]string, 0)
+	for _, peer := range h.activeView.peers {
+		blacklist = append(blacklist, peer.node.ID)
+	}
+	for free := h.activeViewSize() - h.activeView.size(); free > 0; free-- {
+		nodeID := h.replacePeer(blacklist)
+		if nodeID == "" {
+			return
+		}
+		blacklist = append(blacklist, nodeID)
+	}
+}
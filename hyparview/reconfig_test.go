@@ -0,0 +1,64 @@
+package hyparview
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tamararankovic/hyparview/data"
+	"github.com/tamararankovic/hyparview/transport"
+)
+
+func TestUpdateConfig(t *testing.T) {
+	config := DefaultConfig(100)
+	h, _ := newPassiveViewTestNode(config, 1)
+	h.rateLimiter = newRateLimiter(config.PeerRateLimit, config.MsgRateLimits)
+	h.shuffleInterval = make(chan time.Duration, 1)
+	var dialedLock sync.Mutex
+	dialed := make(map[string]bool)
+	h.connManager = transport.NewConnManager(h.self, func(address string) (transport.Conn, error) {
+		dialedLock.Lock()
+		defer dialedLock.Unlock()
+		dialed[address] = true
+		return nil, errors.New("unreachable")
+	}, nil)
+	for i := 0; i < config.PassiveViewSize; i++ {
+		h.passiveView.Add(data.Node{ID: fmt.Sprintf("node-%d", i), ListenAddress: fmt.Sprintf("10.0.0.%d:7000", i)}, "")
+	}
+
+	updated := config
+	updated.PassiveViewSize = 5
+	updated.Kp = 4
+	updated.ShuffleInterval = time.Second
+	if err := h.UpdateConfig(updated); err != nil {
+		t.Fatal(err)
+	}
+	// the empty active view is filled from the trimmed passive view,
+	// the unreachable candidates get dropped
+	h.dials.Wait()
+	if len(dialed) != updated.PassiveViewSize {
+		t.Errorf("%d distinct candidates dialed, want %d", len(dialed), updated.PassiveViewSize)
+	}
+	if h.passiveView.Size() != 0 {
+		t.Errorf("passive view size %d, want the unreachable candidates dropped", h.passiveView.Size())
+	}
+	select {
+	case interval := <-h.shuffleInterval:
+		if interval != time.Second {
+			t.Errorf("shuffle interval %s, want 1s", interval)
+		}
+	default:
+		t.Error("shuffle interval change not passed to the shuffle loop")
+	}
+
+	invalid := updated
+	invalid.Fanout = 0
+	if err := h.UpdateConfig(invalid); err == nil {
+		t.Error("expected the invalid config to be rejected")
+	}
+	if h.Config().Fanout != updated.Fanout {
+		t.Error("rejected config applied")
+	}
+}
@@ -0,0 +1,170 @@
+package hyparview
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// Misbehavior is a kind of peer behavior that lowers its reputation.
+type Misbehavior int8
+
+const (
+	MalformedMsg Misbehavior = iota
+	// ProtocolViolation is a msg no correct node sends, e.g. one sent on behalf of another node.
+	// The handler errors caused by this node, e.g. an unreachable origin, are not penalized.
+	ProtocolViolation
+	RateViolation
+)
+
+var penalties = map[Misbehavior]float64{
+	MalformedMsg:      20,
+	ProtocolViolation: 5,
+	RateViolation:     2,
+}
+
+// minScore is the penalty score under which the score of a node is forgotten
+const minScore = 0.5
+
+type ReputationConfig struct {
+	// BanThreshold is the penalty score at which a peer gets banned for BanDuration.
+	BanThreshold float64
+	BanDuration  time.Duration
+	// ScoreHalfLife is the time it takes for a penalty score to halve.
+	ScoreHalfLife time.Duration
+}
+
+func DefaultReputationConfig() ReputationConfig {
+	return ReputationConfig{
+		BanThreshold:  100,
+		BanDuration:   10 * time.Minute,
+		ScoreHalfLife: time.Minute,
+	}
+}
+
+type score struct {
+	value     float64
+	updatedAt time.Time
+}
+
+// Reputation keeps the penalty scores of the peers and the list of the banned ones.
+type Reputation struct {
+	config ReputationConfig
+	scores map[string]score
+	// bans holds the ban expiry times indexed by node ID
+	bans map[string]time.Time
+	// prunedAt is when the decayed scores and the expired bans were last dropped
+	prunedAt time.Time
+	now      func() time.Time
+	lock     sync.Mutex
+}
+
+func NewReputation(config ReputationConfig) *Reputation {
+	return &Reputation{
+		config: config,
+		scores: make(map[string]score),
+		bans:   make(map[string]time.Time),
+		now:    time.Now,
+	}
+}
+
+// Penalize raises the penalty score of the node and bans it once the score
+// crosses the threshold. It returns true if the node got banned.
+func (r *Reputation) Penalize(nodeID string, misbehavior Misbehavior) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if nodeID == "" || r.isBanned(nodeID) {
+		return false
+	}
+	now := r.now()
+	r.prune(now)
+	s := r.decayed(r.scores[nodeID], now)
+	s.value += penalties[misbehavior]
+	if s.value < r.config.BanThreshold {
+		r.scores[nodeID] = s
+		return false
+	}
+	delete(r.scores, nodeID)
+	r.bans[nodeID] = now.Add(r.config.BanDuration)
+	log.Printf("node %s banned until %s\n", nodeID, r.bans[nodeID].Format(time.RFC3339))
+	return true
+}
+
+// Score returns the current penalty score of the node.
+func (r *Reputation) Score(nodeID string) float64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.decayed(r.scores[nodeID], r.now()).value
+}
+
+func (r *Reputation) Ban(nodeID string, duration time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.scores, nodeID)
+	r.bans[nodeID] = r.now().Add(duration)
+}
+
+func (r *Reputation) Unban(nodeID string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.bans, nodeID)
+}
+
+func (r *Reputation) IsBanned(nodeID string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.isBanned(nodeID)
+}
+
+// Bans returns the ban expiry times of the currently banned nodes.
+func (r *Reputation) Bans() map[string]time.Time {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	bans := make(map[string]time.Time, len(r.bans))
+	for nodeID := range r.bans {
+		if r.isBanned(nodeID) {
+			bans[nodeID] = r.bans[nodeID]
+		}
+	}
+	return bans
+}
+
+func (r *Reputation) isBanned(nodeID string) bool {
+	until, ok := r.bans[nodeID]
+	if !ok {
+		return false
+	}
+	if r.now().After(until) {
+		delete(r.bans, nodeID)
+		return false
+	}
+	return true
+}
+
+// prune drops the scores decayed under minScore and the expired bans,
+// it runs at most once per score half-life so the penalties stay cheap.
+func (r *Reputation) prune(now time.Time) {
+	if now.Sub(r.prunedAt) < r.config.ScoreHalfLife {
+		return
+	}
+	r.prunedAt = now
+	for nodeID, s := range r.scores {
+		if r.decayed(s, now).value < minScore {
+			delete(r.scores, nodeID)
+		}
+	}
+	for nodeID, until := range r.bans {
+		if now.After(until) {
+			delete(r.bans, nodeID)
+		}
+	}
+}
+
+func (r *Reputation) decayed(s score, now time.Time) score {
+	if s.value == 0 || r.config.ScoreHalfLife <= 0 {
+		return score{value: s.value, updatedAt: now}
+	}
+	halfLives := now.Sub(s.updatedAt).Seconds() / r.config.ScoreHalfLife.Seconds()
+	return score{value: s.value * math.Pow(0.5, halfLives), updatedAt: now}
+}
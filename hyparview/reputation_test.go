@@ -0,0 +1,155 @@
+package hyparview
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/tamararankovic/hyparview/data"
+	"github.com/tamararankovic/hyparview/transport"
+)
+
+// newTestReputation returns the reputation along with the function moving its clock forward.
+func newTestReputation() (*Reputation, func(d time.Duration)) {
+	now, advance := newFakeClock()
+	r := NewReputation(DefaultReputationConfig())
+	r.now = now
+	return r, advance
+}
+
+func TestPenaltiesBan(t *testing.T) {
+	r, advance := newTestReputation()
+	penalties := 0
+	for !r.Penalize("a", MalformedMsg) {
+		penalties++
+		if penalties > 10 {
+			t.Fatal("node not banned after 10 malformed msgs")
+		}
+	}
+	if penalties != 4 {
+		t.Errorf("banned after %d penalties, want 5", penalties+1)
+	}
+	if !r.IsBanned("a") || r.IsBanned("b") {
+		t.Error("ban not limited to the penalized node")
+	}
+	if r.Penalize("a", MalformedMsg) {
+		t.Error("banned node banned again")
+	}
+	advance(DefaultReputationConfig().BanDuration + time.Second)
+	if r.IsBanned("a") {
+		t.Error("ban did not expire")
+	}
+	if score := r.Score("a"); score != 0 {
+		t.Errorf("score %g after the ban, want the score reset", score)
+	}
+}
+
+func TestScoreDecays(t *testing.T) {
+	r, advance := newTestReputation()
+	r.Penalize("a", MalformedMsg)
+	advance(DefaultReputationConfig().ScoreHalfLife)
+	if score := r.Score("a"); math.Abs(score-penalties[MalformedMsg]/2) > 1e-9 {
+		t.Errorf("score %g after a half-life, want %g", score, penalties[MalformedMsg]/2)
+	}
+	// the penalties spread out in time never add up to a ban
+	for i := 0; i < 100; i++ {
+		if r.Penalize("a", MalformedMsg) {
+			t.Fatalf("node banned after %d penalties a half-life apart", i+1)
+		}
+		advance(DefaultReputationConfig().ScoreHalfLife)
+	}
+}
+
+func TestScoresPruned(t *testing.T) {
+	r, advance := newTestReputation()
+	r.Penalize("a", RateViolation)
+	r.Ban("b", time.Minute)
+	advance(10 * DefaultReputationConfig().ScoreHalfLife)
+	r.Penalize("c", RateViolation)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.scores["a"]; ok {
+		t.Error("decayed score kept")
+	}
+	if _, ok := r.bans["b"]; ok {
+		t.Error("expired ban kept")
+	}
+	if _, ok := r.scores["c"]; !ok {
+		t.Error("current score dropped")
+	}
+}
+
+func TestBanUnban(t *testing.T) {
+	r, advance := newTestReputation()
+	r.Penalize("a", MalformedMsg)
+	r.Ban("a", time.Hour)
+	if !r.IsBanned("a") {
+		t.Fatal("node not banned")
+	}
+	if until := r.Bans()["a"]; !until.Equal(r.now().Add(time.Hour)) {
+		t.Errorf("ban expires at %s, want in an hour", until)
+	}
+	r.Unban("a")
+	if r.IsBanned("a") || len(r.Bans()) != 0 {
+		t.Error("node still banned")
+	}
+	if score := r.Score("a"); score != 0 {
+		t.Errorf("score %g after the ban, want the score reset", score)
+	}
+	r.Ban("b", time.Minute)
+	advance(2 * time.Minute)
+	if len(r.Bans()) != 0 {
+		t.Errorf("bans %v, want the expired ban left out", r.Bans())
+	}
+}
+
+func TestOnlyProtocolViolationsPenalized(t *testing.T) {
+	h := newHandlerTestNode(t, 2)
+	now := time.Now()
+	h.reputation.now = func() time.Time { return now }
+	// the origin of the shuffle can not be reached, the forwarding peer is not to blame
+	h.onReeive(transport.MsgReceived{
+		Sender: sender(h, "active-0"),
+		Msg:    data.Message{Type: data.SHUFFLE, Payload: data.Shuffle{NodeID: "origin", ListenAddress: "origin:7000", TTL: 1}},
+	})
+	if score := h.reputation.Score(hostKey("active-0:7000")); score != 0 {
+		t.Errorf("score %g after forwarding a shuffle of an unreachable origin, want 0", score)
+	}
+	// a neighbor msg sent on behalf of another node is
+	h.onReeive(transport.MsgReceived{
+		Sender: sender(h, "active-0"),
+		Msg:    data.Message{Type: data.NEIGHTBOR, Payload: data.Neighbor{NodeID: "active-1", ListenAddress: "active-1:7000"}},
+	})
+	if score := h.reputation.Score(hostKey("active-0:7000")); score != penalties[ProtocolViolation] {
+		t.Errorf("score %g after a neighbor msg on behalf of another node, want %g", score, penalties[ProtocolViolation])
+	}
+}
+
+func TestImpostorCanNotBanNode(t *testing.T) {
+	h := newHandlerTestNode(t, 2)
+	// without an identity any node can claim the ID of active-0 in the handshake
+	impostor := &testConn{remote: testNode("active-0"), address: "10.6.6.6:41000"}
+	for i := 0; i < 10; i++ {
+		h.onMalformedMsg(transport.MalformedMsg{Sender: impostor})
+	}
+	if !h.reputation.IsBanned(hostKey(impostor.address)) {
+		t.Error("host of the impostor not banned")
+	}
+	if h.banned(testNode("active-0")) || !h.activeView.contains("active-0") {
+		t.Fatal("node banned for the malformed msgs of the impostor")
+	}
+	h.onReeive(transport.MsgReceived{
+		Sender: sender(h, "active-0"),
+		Msg:    data.Message{Type: data.SHUFFLE_REPLY, Payload: data.ShuffleReply{NodeID: "active-0", Nodes: testNodes("new")}},
+	})
+	if !h.passiveView.Contains("new") {
+		t.Error("msg of the node dropped after the impostor got banned")
+	}
+	h.onReeive(transport.MsgReceived{
+		Sender: impostor,
+		Msg:    data.Message{Type: data.SHUFFLE_REPLY, Payload: data.ShuffleReply{NodeID: "active-0", Nodes: testNodes("sybil")}},
+	})
+	if h.passiveView.Contains("sybil") {
+		t.Error("msg of the banned impostor handled")
+	}
+}
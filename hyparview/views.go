@@ -0,0 +1,160 @@
+package hyparview
+
+import (
+	"maps"
+	"math/rand"
+
+	"github.com/tamararankovic/hyparview/data"
+)
+
+// randomAttempts is the number of random picks tried before
+// the view is scanned for the peers accepted by the filter.
+const randomAttempts = 8
+
+// view is the active or the passive view. The peers are indexed by node ID
+// for constant time lookup and removal and kept in a slice for random sampling,
+// the removed peer is swapped with the last one so the order is not preserved.
+// It is not safe for concurrent use, the HyParView lock guards both views.
+type view struct {
+	peers []Peer
+	index map[string]int
+	// onChange is called after every change of the view if set
+	onChange func()
+}
+
+func newView() *view {
+	return &view{
+		peers: make([]Peer, 0),
+		index: make(map[string]int),
+	}
+}
+
+func (v *view) size() int {
+	return len(v.peers)
+}
+
+func (v *view) contains(id string) bool {
+	_, ok := v.index[id]
+	return ok
+}
+
+// get returns the peer with the node ID or nil,
+// the peer is valid until the view changes.
+func (v *view) get(id string) *Peer {
+	i, ok := v.index[id]
+	if !ok {
+		return nil
+	}
+	return &v.peers[i]
+}
+
+// add adds the peer unless its node is already in the view.
+func (v *view) add(peer Peer) bool {
+	if v.contains(peer.node.ID) {
+		return false
+	}
+	v.index[peer.node.ID] = len(v.peers)
+	v.peers = append(v.peers, peer)
+	v.changed()
+	return true
+}
+
+// refresh replaces the metadata of the node entry if the node info carries a newer version.
+func (v *view) refresh(node data.Node) {
+	i, ok := v.index[node.ID]
+	if !ok || node.MetadataVersion <= v.peers[i].node.MetadataVersion {
+		return
+	}
+	v.peers[i].node.Metadata = maps.Clone(node.Metadata)
+	v.peers[i].node.MetadataVersion = node.MetadataVersion
+	v.changed()
+}
+
+func (v *view) remove(id string) (Peer, bool) {
+	i, ok := v.index[id]
+	if !ok {
+		return Peer{}, false
+	}
+	return v.removeAt(i), true
+}
+
+func (v *view) removeAt(i int) Peer {
+	peer := v.peers[i]
+	last := len(v.peers) - 1
+	if i != last {
+		v.peers[i] = v.peers[last]
+		v.index[v.peers[i].node.ID] = i
+	}
+	v.peers[last] = Peer{}
+	v.peers = v.peers[:last]
+	delete(v.index, peer.node.ID)
+	v.changed()
+	return peer
+}
+
+func (v *view) clear() {
+	v.peers = make([]Peer, 0)
+	v.index = make(map[string]int)
+	v.changed()
+}
+
+func (v *view) changed() {
+	if v.onChange != nil {
+		v.onChange()
+	}
+}
+
+// list returns a copy of the peers.
+func (v *view) list() []Peer {
+	peers := make([]Peer, len(v.peers))
+	copy(peers, v.peers)
+	return peers
+}
+
+// sample returns copies of up to n distinct peers picked uniformly at random.
+// It runs a partial Fisher-Yates shuffle over the indexes, only the swapped
+// ones are recorded so a sample costs O(n) whatever the size of the view.
+func (v *view) sample(n int, intn func(n int) int) []Peer {
+	n = min(n, len(v.peers))
+	peers := make([]Peer, n)
+	swapped := make(map[int]int, n)
+	for i := 0; i < n; i++ {
+		j := i + intn(len(v.peers)-i)
+		picked, ok := swapped[j]
+		if !ok {
+			picked = j
+		}
+		if current, ok := swapped[i]; ok {
+			swapped[j] = current
+		} else {
+			swapped[j] = i
+		}
+		peers[i] = v.peers[picked]
+	}
+	return peers
+}
+
+// random returns a copy of a random peer accepted by the filter or nil if there is none.
+// The view is only scanned if the random picks keep hitting the peers the filter rejects.
+func (v *view) random(accept func(peer Peer) bool) *Peer {
+	if len(v.peers) == 0 {
+		return nil
+	}
+	for attempt := 0; attempt < randomAttempts; attempt++ {
+		peer := v.peers[rand.Intn(len(v.peers))]
+		if accept(peer) {
+			return &peer
+		}
+	}
+	accepted := make([]int, 0)
+	for i, peer := range v.peers {
+		if accept(peer) {
+			accepted = append(accepted, i)
+		}
+	}
+	if len(accepted) == 0 {
+		return nil
+	}
+	peer := v.peers[accepted[rand.Intn(len(accepted))]]
+	return &peer
+}
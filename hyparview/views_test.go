@@ -0,0 +1,134 @@
+package hyparview
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestView(t *testing.T) {
+	v := newView()
+	for i := 0; i < 5; i++ {
+		if !v.add(Peer{node: testNode(fmt.Sprintf("node-%d", i))}) {
+			t.Fatalf("node-%d not added", i)
+		}
+	}
+	if v.add(Peer{node: testNode("node-3")}) {
+		t.Error("duplicate node added")
+	}
+	if _, ok := v.remove("node-1"); !ok {
+		t.Fatal("node-1 not removed")
+	}
+	if _, ok := v.remove("node-1"); ok {
+		t.Error("node-1 removed twice")
+	}
+	if v.size() != 4 || v.contains("node-1") {
+		t.Fatalf("view %v after removing node-1", v.peers)
+	}
+	// the last peer took the place of the removed one
+	for _, id := range []string{"node-0", "node-2", "node-3", "node-4"} {
+		peer := v.get(id)
+		if peer == nil || peer.node.ID != id {
+			t.Errorf("get(%s) = %v", id, peer)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		peer := v.random(func(peer Peer) bool { return peer.node.ID == "node-4" })
+		if peer == nil || peer.node.ID != "node-4" {
+			t.Fatalf("random picked %v, want node-4", peer)
+		}
+	}
+	if peer := v.random(func(peer Peer) bool { return false }); peer != nil {
+		t.Errorf("random picked %v with all the peers rejected", peer)
+	}
+	if sample := v.sample(10, rand.Intn); len(sample) != 4 {
+		t.Errorf("sample returned %d peers, want 4", len(sample))
+	}
+	sampled := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		sample := v.sample(2, rand.Intn)
+		if len(sample) != 2 || sample[0].node.ID == sample[1].node.ID {
+			t.Fatalf("sample returned %v, want 2 distinct peers", sample)
+		}
+		for _, peer := range sample {
+			sampled[peer.node.ID]++
+		}
+	}
+	if len(sampled) != 4 {
+		t.Errorf("100 samples of 2 peers only returned %v", sampled)
+	}
+	v.clear()
+	if v.size() != 0 || v.random(func(peer Peer) bool { return true }) != nil {
+		t.Error("view not empty after clear")
+	}
+}
+
+var benchmarkPassiveViewSizes = []int{100, 1000, 10000}
+
+// newBenchmarkNode returns a node with a full active view
+// and the passive view filled up to its size.
+func newBenchmarkNode(b *testing.B, passiveViewSize int) *HyParView {
+	h := newHandlerTestNode(b, 0)
+	h.config.PassiveViewSize = passiveViewSize
+	for i := 0; i < h.activeViewSize(); i++ {
+		node := testNode(fmt.Sprintf("active-%d", i))
+		h.addPeer(Peer{node: node, conn: &discardConn{testConn{remote: node}}})
+	}
+	h.passiveView = NewPassiveView(h.self.ID, h.config)
+	for i := 0; i < passiveViewSize; i++ {
+		h.passiveView.Add(testNode(fmt.Sprintf("passive-%d", i)), "")
+	}
+	return h
+}
+
+func BenchmarkSelectRandomPeerCandidate(b *testing.B) {
+	for _, size := range benchmarkPassiveViewSizes {
+		b.Run(fmt.Sprintf("passive=%d", size), func(b *testing.B) {
+			h := newBenchmarkNode(b, size)
+			blacklist := []string{"passive-0"}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if h.selectRandomPeerCandidate(blacklist) == nil {
+					b.Fatal("no candidate selected")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGetPeerCandidate(b *testing.B) {
+	for _, size := range benchmarkPassiveViewSizes {
+		b.Run(fmt.Sprintf("passive=%d", size), func(b *testing.B) {
+			h := newBenchmarkNode(b, size)
+			ids := make([]string, size)
+			for i := range ids {
+				ids[i] = fmt.Sprintf("passive-%d", i)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if h.getPeerCandidate(ids[i%size]) == nil {
+					b.Fatal("candidate not found")
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkPassiveViewChurn removes a random passive view entry and adds it back,
+// the way the shuffles and the failed dials change the view.
+func BenchmarkPassiveViewChurn(b *testing.B) {
+	for _, size := range benchmarkPassiveViewSizes {
+		b.Run(fmt.Sprintf("passive=%d", size), func(b *testing.B) {
+			h := newBenchmarkNode(b, size)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				candidate := h.selectRandomPeerCandidate(nil)
+				h.deletePeerCandidate(*candidate)
+				h.passiveView.Add(candidate.node, "")
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const pemBlockType = "PRIVATE KEY"
+
+// Identity is the Ed25519 keypair of a node, the node ID is derived from the public key.
+type Identity struct {
+	privateKey ed25519.PrivateKey
+}
+
+func Generate() (Identity, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return Identity{}, err
+	}
+	return Identity{privateKey: privateKey}, nil
+}
+
+// FromSeed deterministically derives the identity from a 32 byte seed,
+// useful for reproducible tests.
+func FromSeed(seed []byte) (Identity, error) {
+	if len(seed) != ed25519.SeedSize {
+		return Identity{}, fmt.Errorf("seed must be %d bytes long", ed25519.SeedSize)
+	}
+	return Identity{privateKey: ed25519.NewKeyFromSeed(seed)}, nil
+}
+
+// Load reads a PEM encoded PKCS #8 private key from the file.
+func Load(path string) (Identity, error) {
+	keyPEM, err := os.ReadFile(path)
+	if err != nil {
+		return Identity{}, err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil || block.Type != pemBlockType {
+		return Identity{}, fmt.Errorf("no private key found in %s", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return Identity{}, err
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return Identity{}, fmt.Errorf("key in %s not an ed25519 key", path)
+	}
+	return Identity{privateKey: privateKey}, nil
+}
+
+// LoadOrGenerate loads the identity from the file,
+// if the file doesn't exist a new identity is generated and saved to it.
+func LoadOrGenerate(path string) (Identity, error) {
+	id, err := Load(path)
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return id, err
+	}
+	id, err = Generate()
+	if err != nil {
+		return Identity{}, err
+	}
+	return id, id.Save(path)
+}
+
+// Save writes the private key to the file readable only by the owner.
+func (i Identity) Save(path string) error {
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(i.privateKey)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: pemBlockType, Bytes: keyBytes})
+	return os.WriteFile(path, keyPEM, 0600)
+}
+
+func (i Identity) PublicKey() ed25519.PublicKey {
+	return i.privateKey.Public().(ed25519.PublicKey)
+}
+
+func (i Identity) NodeID() string {
+	return NodeIDFromPublicKey(i.PublicKey())
+}
+
+func NodeIDFromPublicKey(publicKey ed25519.PublicKey) string {
+	return base64.RawURLEncoding.EncodeToString(publicKey)
+}
+
+func PublicKeyFromNodeID(nodeID string) (ed25519.PublicKey, error) {
+	publicKey, err := base64.RawURLEncoding.DecodeString(nodeID)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("node ID %s not derived from an ed25519 public key", nodeID)
+	}
+	return publicKey, nil
+}
@@ -0,0 +1,223 @@
+package identity
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tamararankovic/hyparview/data"
+)
+
+func seeded(t *testing.T, b byte) Identity {
+	t.Helper()
+	id, err := FromSeed(bytes.Repeat([]byte{b}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestGenerate(t *testing.T) {
+	a, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.NodeID() == b.NodeID() {
+		t.Error("generated identities share the node ID")
+	}
+	publicKey, err := PublicKeyFromNodeID(a.NodeID())
+	if err != nil || !publicKey.Equal(a.PublicKey()) {
+		t.Errorf("node ID does not decode to the public key: %v", err)
+	}
+}
+
+func TestFromSeed(t *testing.T) {
+	if seeded(t, 1).NodeID() != seeded(t, 1).NodeID() {
+		t.Error("same seed derives different node IDs")
+	}
+	if seeded(t, 1).NodeID() == seeded(t, 2).NodeID() {
+		t.Error("different seeds derive the same node ID")
+	}
+	if _, err := FromSeed([]byte("short")); err == nil {
+		t.Error("short seed accepted")
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.key")
+	id := seeded(t, 1)
+	if err := id.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("key file permissions %o, want 600", perm)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.NodeID() != id.NodeID() {
+		t.Errorf("loaded node ID %s, want %s", loaded.NodeID(), id.NodeID())
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.key")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("loading a missing file returned %v", err)
+	}
+	garbage := filepath.Join(t.TempDir(), "garbage.key")
+	if err := os.WriteFile(garbage, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(garbage); err == nil {
+		t.Error("file without a key loaded")
+	}
+}
+
+func TestLoadOrGenerate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.key")
+	generated, err := LoadOrGenerate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadOrGenerate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.NodeID() != generated.NodeID() {
+		t.Error("identity generated again instead of loaded")
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	id := seeded(t, 1)
+	msg := data.Message{
+		Type: data.JOIN,
+		Payload: data.Join{
+			NodeID:          id.NodeID(),
+			ListenAddress:   "a-addr",
+			Metadata:        map[string]string{"region": "eu"},
+			MetadataVersion: 3,
+		},
+	}
+	if err := Verify(msg); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("unsigned msg verified with %v, want %v", err, ErrUnsigned)
+	}
+	signed, err := id.Sign(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(signed); err != nil {
+		t.Fatalf("signed msg not verified: %v", err)
+	}
+	signer, err := Signer(signed)
+	if err != nil || signer != id.NodeID() {
+		t.Errorf("signer %s, %v, want %s", signer, err, id.NodeID())
+	}
+	for name, tamper := range map[string]func(*data.Join){
+		"address":  func(j *data.Join) { j.ListenAddress = "m-addr" },
+		"metadata": func(j *data.Join) { j.Metadata = map[string]string{"region": "us"} },
+		"version":  func(j *data.Join) { j.MetadataVersion++ },
+		"signer":   func(j *data.Join) { j.NodeID = seeded(t, 2).NodeID() },
+	} {
+		join := signed.Payload.(data.Join)
+		join.Metadata = map[string]string{"region": "eu"}
+		tamper(&join)
+		if err := Verify(data.Message{Type: data.JOIN, Payload: join}); err == nil {
+			t.Errorf("msg with tampered %s verified", name)
+		}
+	}
+}
+
+func TestSignOnBehalfOfOtherNode(t *testing.T) {
+	msg := data.Message{Type: data.DISCONNECT, Payload: data.Disconnect{NodeID: seeded(t, 2).NodeID()}}
+	if _, err := seeded(t, 1).Sign(msg); err == nil {
+		t.Error("msg signed on behalf of another node")
+	}
+}
+
+func TestSignUnsignableMsg(t *testing.T) {
+	msg := data.Message{Type: data.HANDSHAKE, Payload: data.Handshake{NodeID: seeded(t, 1).NodeID()}}
+	if _, err := seeded(t, 1).Sign(msg); err == nil {
+		t.Error("handshake msg signed")
+	}
+}
+
+func TestForwardJoinVerifiesAsJoin(t *testing.T) {
+	id := seeded(t, 1)
+	signed, err := id.Sign(data.Message{
+		Type:    data.JOIN,
+		Payload: data.Join{NodeID: id.NodeID(), ListenAddress: "a-addr"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	join := signed.Payload.(data.Join)
+	forwardJoin := data.ForwardJoin{
+		NodeID:        join.NodeID,
+		ListenAddress: join.ListenAddress,
+		TTL:           5,
+		Signature:     join.Signature,
+	}
+	if err := Verify(data.Message{Type: data.FORWARD_JOIN, Payload: forwardJoin}); err != nil {
+		t.Errorf("forward join of a signed join not verified: %v", err)
+	}
+	forwardJoin.ListenAddress = "m-addr"
+	if err := Verify(data.Message{Type: data.FORWARD_JOIN, Payload: forwardJoin}); err == nil {
+		t.Error("forward join with a tampered address verified")
+	}
+}
+
+func TestShuffleTTLNotSigned(t *testing.T) {
+	id := seeded(t, 1)
+	signed, err := id.Sign(data.Message{
+		Type:    data.SHUFFLE,
+		Payload: data.Shuffle{NodeID: id.NodeID(), ListenAddress: "a-addr", TTL: 5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shuffle := signed.Payload.(data.Shuffle)
+	shuffle.TTL--
+	if err := Verify(data.Message{Type: data.SHUFFLE, Payload: shuffle}); err != nil {
+		t.Errorf("forwarded shuffle not verified: %v", err)
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	id := seeded(t, 1)
+	challenge := []byte("challenge")
+	signature, err := id.SignChallenge(challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := seeded(t, 2)
+	if err := verifier.VerifyChallenge(id.NodeID(), challenge, signature); err != nil {
+		t.Errorf("signed challenge not verified: %v", err)
+	}
+	if err := verifier.VerifyChallenge(id.NodeID(), []byte("other"), signature); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("tampered challenge verified with %v", err)
+	}
+	if err := verifier.VerifyChallenge(verifier.NodeID(), challenge, signature); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("challenge signed by another node verified with %v", err)
+	}
+	// a signed msg is not accepted as a signed challenge and the other way around
+	signed, err := id.Sign(data.Message{Type: data.DISCONNECT, Payload: data.Disconnect{NodeID: id.NodeID()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingBytes, err := marshal(data.Message{Type: data.DISCONNECT, Payload: data.Disconnect{NodeID: id.NodeID()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.VerifyChallenge(id.NodeID(), signingBytes, signed.Payload.(data.Disconnect).Signature); err == nil {
+		t.Error("msg signature accepted as a challenge signature")
+	}
+}
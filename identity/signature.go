@@ -0,0 +1,176 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/tamararankovic/hyparview/data"
+)
+
+var (
+	ErrUnsigned         = errors.New("msg not signed")
+	ErrInvalidSignature = errors.New("invalid msg signature")
+)
+
+// Sign returns the msg carrying the signature of the identity,
+// the identity has to be the one the msg is sent on behalf of.
+func (i Identity) Sign(msg data.Message) (data.Message, error) {
+	signer, _, canonical, err := unsigned(msg)
+	if err != nil {
+		return msg, err
+	}
+	if signer != i.NodeID() {
+		return msg, fmt.Errorf("cannot sign msg on behalf of node %s", signer)
+	}
+	signingBytes, err := marshal(canonical)
+	if err != nil {
+		return msg, err
+	}
+	msg.Payload = withSignature(msg.Payload, ed25519.Sign(i.privateKey, signingBytes))
+	return msg, nil
+}
+
+// Verify checks that the msg is signed by the node it claims to be sent on behalf of.
+func Verify(msg data.Message) error {
+	signer, signature, canonical, err := unsigned(msg)
+	if err != nil {
+		return err
+	}
+	if len(signature) == 0 {
+		return ErrUnsigned
+	}
+	publicKey, err := PublicKeyFromNodeID(signer)
+	if err != nil {
+		return err
+	}
+	signingBytes, err := marshal(canonical)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, signingBytes, signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// challengeDomain keeps the handshake challenges apart from the signed msgs.
+const challengeDomain = "hyparview handshake\x00"
+
+// SignChallenge signs the handshake challenge to prove the node ID of the identity.
+func (i Identity) SignChallenge(challenge []byte) ([]byte, error) {
+	return ed25519.Sign(i.privateKey, append([]byte(challengeDomain), challenge...)), nil
+}
+
+// VerifyChallenge checks that the handshake challenge is signed by the node.
+func (i Identity) VerifyChallenge(nodeID string, challenge, signature []byte) error {
+	publicKey, err := PublicKeyFromNodeID(nodeID)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, append([]byte(challengeDomain), challenge...), signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Signer returns the ID of the node the msg is sent on behalf of.
+func Signer(msg data.Message) (string, error) {
+	signer, _, _, err := unsigned(msg)
+	return signer, err
+}
+
+// unsigned returns the signer, the signature and the canonical form of the msg that is signed.
+// TTLs are left out since they change while the msg is forwarded
+// and a forward join is signed as the join issued by the joining node.
+func unsigned(msg data.Message) (string, []byte, data.Message, error) {
+	switch payload := msg.Payload.(type) {
+	case data.Join:
+		signature := payload.Signature
+		payload.Signature = nil
+		return payload.NodeID, signature, data.Message{Type: data.JOIN, Payload: payload}, nil
+	case data.ForwardJoin:
+		join := data.Join{
+			NodeID:          payload.NodeID,
+			ListenAddress:   payload.ListenAddress,
+			Metadata:        payload.Metadata,
+			MetadataVersion: payload.MetadataVersion,
+			Credentials:     payload.Credentials,
+		}
+		return payload.NodeID, payload.Signature, data.Message{Type: data.JOIN, Payload: join}, nil
+	case data.JoinReply:
+		signature := payload.Signature
+		payload.Signature = nil
+		return payload.NodeID, signature, data.Message{Type: msg.Type, Payload: payload}, nil
+	case data.Disconnect:
+		signature := payload.Signature
+		payload.Signature = nil
+		return payload.NodeID, signature, data.Message{Type: msg.Type, Payload: payload}, nil
+	case data.Neighbor:
+		signature := payload.Signature
+		payload.Signature = nil
+		return payload.NodeID, signature, data.Message{Type: msg.Type, Payload: payload}, nil
+	case data.NeighborReply:
+		signature := payload.Signature
+		payload.Signature = nil
+		return payload.NodeID, signature, data.Message{Type: msg.Type, Payload: payload}, nil
+	case data.Shuffle:
+		signature := payload.Signature
+		payload.Signature = nil
+		payload.TTL = 0
+		return payload.NodeID, signature, data.Message{Type: msg.Type, Payload: payload}, nil
+	case data.ShuffleReply:
+		signature := payload.Signature
+		payload.Signature = nil
+		return payload.NodeID, signature, data.Message{Type: msg.Type, Payload: payload}, nil
+	case data.Reject:
+		signature := payload.Signature
+		payload.Signature = nil
+		return payload.NodeID, signature, data.Message{Type: msg.Type, Payload: payload}, nil
+	default:
+		return "", nil, msg, fmt.Errorf("msg type %s cannot be signed", msg.Type)
+	}
+}
+
+func withSignature(payload any, signature []byte) any {
+	switch p := payload.(type) {
+	case data.Join:
+		p.Signature = signature
+		return p
+	case data.ForwardJoin:
+		p.Signature = signature
+		return p
+	case data.JoinReply:
+		p.Signature = signature
+		return p
+	case data.Disconnect:
+		p.Signature = signature
+		return p
+	case data.Neighbor:
+		p.Signature = signature
+		return p
+	case data.NeighborReply:
+		p.Signature = signature
+		return p
+	case data.Shuffle:
+		p.Signature = signature
+		return p
+	case data.ShuffleReply:
+		p.Signature = signature
+		return p
+	case data.Reject:
+		p.Signature = signature
+		return p
+	default:
+		return payload
+	}
+}
+
+func marshal(msg data.Message) ([]byte, error) {
+	payloadBytes, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{byte(msg.Type)}, payloadBytes...), nil
+}
@@ -0,0 +1,264 @@
+// Package conformance checks a HyParView implementation against
+// the pseudocode of the HyParView paper.
+//
+// Every scenario starts the node under test on an in-process network
+// surrounded by scripted peers. The peers send the node the msgs of the scenario
+// and the scenario checks the msgs the node sends back and its resulting views:
+//
+//	conformance.Run(t, conformance.HyParView, conformance.Scenarios)
+package conformance
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tamararankovic/hyparview/data"
+	"github.com/tamararankovic/hyparview/hyparview"
+	"github.com/tamararankovic/hyparview/testing/invariants"
+	"github.com/tamararankovic/hyparview/transport"
+)
+
+const (
+	// NodeID is the ID and the address of the node under test
+	NodeID = "node"
+	// Timeout bounds the wait for a msg or a view change
+	Timeout = 2 * time.Second
+	// Quiet is how long a peer waits for a msg it must not get
+	Quiet = 200 * time.Millisecond
+)
+
+// Node is the implementation under test.
+type Node interface {
+	Snapshot() invariants.Snapshot
+	Stop()
+}
+
+// NewNode starts the implementation under test on the conn manager.
+type NewNode func(config hyparview.HyParViewConfig, self data.Node, connManager *transport.ConnManager) (Node, error)
+
+type hyParViewNode struct {
+	*hyparview.HyParView
+}
+
+func (n hyParViewNode) Snapshot() invariants.Snapshot {
+	return invariants.Take(n.HyParView)
+}
+
+// HyParView starts the implementation of the hyparview package.
+func HyParView(config hyparview.HyParViewConfig, self data.Node, connManager *transport.ConnManager) (Node, error) {
+	hv, err := hyparview.NewHyParView(config, self, connManager)
+	if err != nil {
+		return nil, err
+	}
+	return hyParViewNode{hv}, nil
+}
+
+// Config returns the config the scenarios are written for:
+// an active view of 3 nodes, a passive view of 6 nodes,
+// random walks of 3 hops adding to the passive view after the first one
+// and shuffles only triggered by the scenarios.
+func Config() hyparview.HyParViewConfig {
+	return hyparview.HyParViewConfig{
+		Fanout:          2,
+		PassiveViewSize: 6,
+		ARWL:            3,
+		PRWL:            2,
+		Ka:              2,
+		Kp:              2,
+		ShuffleInterval: time.Hour,
+	}
+}
+
+// Env is the node under test and the scripted peers around it.
+type Env struct {
+	Config  hyparview.HyParViewConfig
+	node    Node
+	network *transport.MemNetwork
+	peers   map[string]*Peer
+}
+
+func newEnv(newNode NewNode) (*Env, error) {
+	e := &Env{
+		Config:  Config(),
+		network: transport.NewMemNetwork(transport.DefaultConnConfig()),
+		peers:   make(map[string]*Peer),
+	}
+	self := data.Node{ID: NodeID, ListenAddress: NodeID}
+	connManager := transport.NewConnManager(self, e.network.NewConnFn(NodeID), e.network.AcceptConnsFn(NodeID))
+	node, err := newNode(e.Config, self, connManager)
+	if err != nil {
+		return nil, err
+	}
+	e.node = node
+	return e, nil
+}
+
+// Peer returns the scripted peer with the ID, the peer is started on first use.
+// The ID of a peer is also its address.
+func (e *Env) Peer(id string) (*Peer, error) {
+	if peer, ok := e.peers[id]; ok {
+		return peer, nil
+	}
+	peer := &Peer{
+		self:     data.Node{ID: id, ListenAddress: id},
+		received: make(chan struct{}),
+	}
+	peer.connManager = transport.NewConnManager(peer.self, e.network.NewConnFn(id), e.network.AcceptConnsFn(id))
+	_ = peer.connManager.OnReceive(peer.onReceive)
+	if err := peer.connManager.StartAcceptingConns(); err != nil {
+		return nil, err
+	}
+	e.peers[id] = peer
+	return peer, nil
+}
+
+func (e *Env) Snapshot() invariants.Snapshot {
+	return e.node.Snapshot()
+}
+
+// Eventually retries the check until it passes and returns its last error once the timeout expires.
+func (e *Env) Eventually(check func(snapshot invariants.Snapshot) error) error {
+	deadline := time.Now().Add(Timeout)
+	for {
+		err := check(e.Snapshot())
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(Timeout / 100)
+	}
+}
+
+func (e *Env) close() {
+	e.node.Stop()
+	for _, peer := range e.peers {
+		peer.connManager.Close()
+	}
+}
+
+// Peer is a scripted remote end of the node under test,
+// it records the msgs the node sends it and answers none of them.
+type Peer struct {
+	self        data.Node
+	connManager *transport.ConnManager
+	lock        sync.Mutex
+	inbox       []data.Message
+	// received is closed and replaced once a msg is received
+	received chan struct{}
+}
+
+func (p *Peer) Node() data.Node {
+	return p.self
+}
+
+// Send sends the msg to the node under test over the link to it.
+func (p *Peer) Send(msg data.Message) error {
+	conn, err := p.connManager.Connect(NodeID)
+	if err != nil {
+		return err
+	}
+	return conn.Send(msg)
+}
+
+// Expect returns the first unread msg of the type, waiting for it up to the timeout.
+// The msgs of other types are kept for later expectations.
+func (p *Peer) Expect(msgType data.MessageType) (data.Message, error) {
+	timeout := time.After(Timeout)
+	for {
+		p.lock.Lock()
+		received := p.received
+		p.lock.Unlock()
+		if msg, ok := p.take(msgType); ok {
+			return msg, nil
+		}
+		select {
+		case <-received:
+		case <-timeout:
+			return data.Message{}, fmt.Errorf("peer %s got no %s msg within %s", p.self.ID, msgType, Timeout)
+		}
+	}
+}
+
+// take removes and returns the first unread msg of the type.
+func (p *Peer) take(msgType data.MessageType) (data.Message, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	i := slices.IndexFunc(p.inbox, func(msg data.Message) bool { return msg.Type == msgType })
+	if i < 0 {
+		return data.Message{}, false
+	}
+	msg := p.inbox[i]
+	p.inbox = slices.Delete(p.inbox, i, i+1)
+	return msg, true
+}
+
+// ExpectNone fails if the peer gets a msg of the type before it has been quiet for a while.
+func (p *Peer) ExpectNone(msgType data.MessageType) error {
+	time.Sleep(Quiet)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, msg := range p.inbox {
+		if msg.Type == msgType {
+			return fmt.Errorf("peer %s got an unexpected %s msg %+v", p.self.ID, msgType, msg.Payload)
+		}
+	}
+	return nil
+}
+
+func (p *Peer) discard() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.inbox = nil
+}
+
+func (p *Peer) onReceive(received transport.MsgReceived) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.inbox = append(p.inbox, received.Msg)
+	close(p.received)
+	p.received = make(chan struct{})
+}
+
+// Step is an action or a check of a scenario.
+type Step func(e *Env) error
+
+// Scenario brings the node under test into a state with the setup steps
+// and checks the reaction of the node to a situation the paper describes.
+type Scenario struct {
+	Name string
+	// Rule is the rule of the paper the scenario checks
+	Rule  string
+	Setup []Step
+	Steps []Step
+}
+
+// Run runs every scenario against a new node in a subtest. The msgs the peers got
+// during the setup are discarded and the views of the node are checked
+// against the invariants that hold for a single node after the steps.
+func Run(t *testing.T, newNode NewNode, scenarios []Scenario) {
+	for _, scenario := range scenarios {
+		t.Run(scenario.Name, func(t *testing.T) {
+			e, err := newEnv(newNode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer e.close()
+			for i, step := range scenario.Setup {
+				if err := step(e); err != nil {
+					t.Fatalf("setup step %d: %v", i+1, err)
+				}
+			}
+			for _, peer := range e.peers {
+				peer.discard()
+			}
+			for i, step := range scenario.Steps {
+				if err := step(e); err != nil {
+					t.Fatalf("%s\nstep %d: %v", scenario.Rule, i+1, err)
+				}
+			}
+			invariants.Assert(t, []invariants.Snapshot{e.Snapshot()}, invariants.NoSelfInViews, invariants.DisjointViews, invariants.BoundedViews)
+		})
+	}
+}
@@ -0,0 +1,14 @@
+package conformance
+
+import (
+	"io"
+	"log"
+	"testing"
+)
+
+func TestHyParViewConformance(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+	Run(t, HyParView, Scenarios)
+}
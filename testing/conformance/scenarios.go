@@ -0,0 +1,257 @@
+package conformance
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/tamararankovic/hyparview/data"
+)
+
+// Scenarios holds the scenarios of the paper rules, they are written for the Config.
+var Scenarios = []Scenario{
+	{
+		Name:  "join",
+		Rule:  "a node receiving a JOIN adds the new node to its active view and sends a FORWARD_JOIN with TTL ARWL to all the other active peers",
+		Setup: []Step{Join("a", "b")},
+		Steps: []Step{
+			Send("c", JoinMsg("c")),
+			ActiveView("a", "b", "c"),
+			Expect("a", data.FORWARD_JOIN, isForwardJoin("c", Config().ARWL)),
+			Expect("b", data.FORWARD_JOIN, isForwardJoin("c", Config().ARWL)),
+			ExpectNone(data.FORWARD_JOIN, "c"),
+		},
+	},
+	{
+		Name:  "join at a full active view",
+		Rule:  "a node with a full active view drops a random active peer with a DISCONNECT to make room for the joining node",
+		Setup: []Step{Join("a", "b", "c")},
+		Steps: []Step{
+			Send("d", JoinMsg("d")),
+			dropsOneOf("a", "b", "c"),
+			InActiveView("d"),
+		},
+	},
+	{
+		Name:  "forward join with TTL zero",
+		Rule:  "a FORWARD_JOIN arriving with TTL zero ends the random walk, the joining node is added to the active view and asked to become a neighbor",
+		Setup: []Step{Join("a", "b")},
+		Steps: []Step{
+			Send("a", ForwardJoinMsg("d", 0)),
+			Expect("d", data.NEIGHTBOR, isNeighbor(true)),
+			ActiveView("a", "b", "d"),
+			ExpectNone(data.FORWARD_JOIN, "b"),
+		},
+	},
+	{
+		Name:  "forward join at a single active peer",
+		Rule:  "a FORWARD_JOIN arriving at a node whose only active peer is the sender ends the random walk",
+		Setup: []Step{Join("a")},
+		Steps: []Step{
+			Send("a", ForwardJoinMsg("d", Config().ARWL)),
+			Expect("d", data.NEIGHTBOR, isNeighbor(true)),
+			ActiveView("a", "d"),
+		},
+	},
+	{
+		Name:  "forward join at TTL PRWL",
+		Rule:  "a FORWARD_JOIN with TTL equal to PRWL adds the joining node to the passive view and is forwarded with the TTL decremented",
+		Setup: []Step{Join("a", "b")},
+		Steps: []Step{
+			Send("a", ForwardJoinMsg("d", Config().PRWL)),
+			Expect("b", data.FORWARD_JOIN, isForwardJoin("d", Config().PRWL-1)),
+			InPassiveView("d"),
+			NotInActiveView("d"),
+			ExpectNone(data.NEIGHTBOR, "d"),
+		},
+	},
+	{
+		Name:  "forward join above TTL PRWL",
+		Rule:  "a FORWARD_JOIN with TTL above PRWL is only forwarded to an active peer other than the sender",
+		Setup: []Step{Join("a", "b")},
+		Steps: []Step{
+			Send("a", ForwardJoinMsg("d", Config().ARWL)),
+			Expect("b", data.FORWARD_JOIN, isForwardJoin("d", Config().ARWL-1)),
+			NotInPassiveView("d"),
+			NotInActiveView("d"),
+		},
+	},
+	{
+		Name:  "high priority neighbor at a full active view",
+		Rule:  "a NEIGHBOR with high priority is always accepted, a random active peer is dropped if the active view is full",
+		Setup: []Step{Join("a", "b", "c")},
+		Steps: []Step{
+			Send("d", NeighborMsg("d", true)),
+			Expect("d", data.NEIGHTBOR_REPLY, isNeighborReply(true)),
+			dropsOneOf("a", "b", "c"),
+			InActiveView("d"),
+		},
+	},
+	{
+		Name:  "low priority neighbor at a full active view",
+		Rule:  "a NEIGHBOR with low priority is refused if the active view is full",
+		Setup: []Step{Join("a", "b", "c")},
+		Steps: []Step{
+			Send("d", NeighborMsg("d", false)),
+			Expect("d", data.NEIGHTBOR_REPLY, isNeighborReply(false)),
+			ActiveView("a", "b", "c"),
+			ExpectNone(data.DISCONNECT, "a", "b", "c"),
+		},
+	},
+	{
+		Name:  "low priority neighbor with a free slot",
+		Rule:  "a NEIGHBOR with low priority is accepted if the active view is not full",
+		Setup: []Step{Join("a")},
+		Steps: []Step{
+			Send("d", NeighborMsg("d", false)),
+			Expect("d", data.NEIGHTBOR_REPLY, isNeighborReply(true)),
+			ActiveView("a", "d"),
+		},
+	},
+	{
+		Name:  "shuffle forwarded",
+		Rule:  "a SHUFFLE whose TTL stays positive after decrementing it is forwarded to an active peer other than the sender",
+		Setup: []Step{Join("a", "b")},
+		Steps: []Step{
+			Send("a", ShuffleMsg("origin", Config().ARWL, "x", "y")),
+			Expect("b", data.SHUFFLE, isShuffle("origin", Config().ARWL-1)),
+			ExpectNone(data.SHUFFLE_REPLY, "origin"),
+			NotInPassiveView("x", "y"),
+		},
+	},
+	{
+		Name:  "shuffle with TTL expiry",
+		Rule:  "a SHUFFLE whose TTL expires is answered with a SHUFFLE_REPLY carrying as many passive view nodes and the received nodes are integrated into the passive view",
+		Setup: []Step{Start("origin"), Join("a", "b"), Send("a", ShuffleMsg("origin", 1, "p", "q", "r")), InPassiveView("p", "q", "r")},
+		Steps: []Step{
+			Send("a", ShuffleMsg("origin", 1, "x", "y")),
+			Expect("origin", data.SHUFFLE_REPLY, isShuffleReply([]string{"x", "y"}, 2)),
+			InPassiveView("x", "y"),
+			ExpectNone(data.SHUFFLE, "b"),
+		},
+	},
+	{
+		Name:  "disconnect",
+		Rule:  "a peer sending a DISCONNECT is moved to the passive view and a passive view node is asked to replace it, with low priority while the active view is not empty",
+		Setup: []Step{Start("p"), Join("a", "b"), Send("a", ShuffleMsg("origin", 1, "p")), InPassiveView("p")},
+		Steps: []Step{
+			Send("a", DisconnectMsg("a")),
+			ActiveView("b"),
+			InPassiveView("a"),
+			Expect("p", data.NEIGHTBOR, isNeighbor(false)),
+		},
+	},
+	{
+		Name:  "accepted neighbor",
+		Rule:  "a passive view node accepting the NEIGHBOR is moved to the active view",
+		Setup: []Step{Start("p"), Join("a", "b"), Send("a", ShuffleMsg("origin", 1, "p")), InPassiveView("p"), Send("a", DisconnectMsg("a")), Expect("p", data.NEIGHTBOR, nil)},
+		Steps: []Step{
+			Send("p", NeighborReplyMsg("p", true)),
+			ActiveView("b", "p"),
+			NotInPassiveView("p"),
+		},
+	},
+	{
+		Name:  "refused neighbor",
+		Rule:  "a passive view node refusing the NEIGHBOR stays in the passive view and another passive view node is tried",
+		Setup: []Step{Start("p", "q"), Join("a", "b"), Send("a", ShuffleMsg("origin", 1, "p", "q")), InPassiveView("p", "q"), Send("a", DisconnectMsg("a"))},
+		Steps: []Step{
+			triesAnotherAfterRefusal("p", "q", "a"),
+			ActiveView("b"),
+		},
+	},
+}
+
+// dropsOneOf checks exactly one of the active peers is sent a DISCONNECT
+// and moved to the passive view, the others stay in the active view.
+func dropsOneOf(ids ...string) Step {
+	return func(e *Env) error {
+		dropped, _, err := expectAny(e, data.DISCONNECT, ids...)
+		if err != nil {
+			return err
+		}
+		if err := NotInActiveView(dropped)(e); err != nil {
+			return err
+		}
+		if err := InPassiveView(dropped)(e); err != nil {
+			return err
+		}
+		kept := slices.DeleteFunc(slices.Clone(ids), func(id string) bool { return id == dropped })
+		if err := InActiveView(kept...)(e); err != nil {
+			return err
+		}
+		return ExpectNone(data.DISCONNECT, kept...)(e)
+	}
+}
+
+// triesAnotherAfterRefusal refuses the NEIGHBOR sent to one of the passive view nodes
+// and checks one of the other ones is asked next.
+func triesAnotherAfterRefusal(ids ...string) Step {
+	return func(e *Env) error {
+		refusing, _, err := expectAny(e, data.NEIGHTBOR, ids...)
+		if err != nil {
+			return err
+		}
+		if err := Send(refusing, NeighborReplyMsg(refusing, false))(e); err != nil {
+			return err
+		}
+		others := slices.DeleteFunc(slices.Clone(ids), func(id string) bool { return id == refusing })
+		if _, _, err := expectAny(e, data.NEIGHTBOR, others...); err != nil {
+			return fmt.Errorf("after %s refused: %w", refusing, err)
+		}
+		return InPassiveView(refusing)(e)
+	}
+}
+
+func isForwardJoin(id string, ttl int) func(msg data.Message) error {
+	return func(msg data.Message) error {
+		forwardJoin := msg.Payload.(data.ForwardJoin)
+		if forwardJoin.NodeID != id || forwardJoin.TTL != ttl {
+			return fmt.Errorf("forward join of %s with TTL %d, want %s with TTL %d", forwardJoin.NodeID, forwardJoin.TTL, id, ttl)
+		}
+		return nil
+	}
+}
+
+func isNeighbor(highPriority bool) func(msg data.Message) error {
+	return func(msg data.Message) error {
+		neighbor := msg.Payload.(data.Neighbor)
+		if neighbor.NodeID != NodeID || neighbor.HighPriority != highPriority {
+			return fmt.Errorf("neighbor from %s with high priority %v, want from %s with high priority %v", neighbor.NodeID, neighbor.HighPriority, NodeID, highPriority)
+		}
+		return nil
+	}
+}
+
+func isNeighborReply(accepted bool) func(msg data.Message) error {
+	return func(msg data.Message) error {
+		if reply := msg.Payload.(data.NeighborReply); reply.Accepted != accepted {
+			return fmt.Errorf("neighbor reply accepted %v, want %v", reply.Accepted, accepted)
+		}
+		return nil
+	}
+}
+
+func isShuffle(origin string, ttl int) func(msg data.Message) error {
+	return func(msg data.Message) error {
+		shuffle := msg.Payload.(data.Shuffle)
+		if shuffle.NodeID != origin || shuffle.TTL != ttl {
+			return fmt.Errorf("shuffle of %s with TTL %d, want %s with TTL %d", shuffle.NodeID, shuffle.TTL, origin, ttl)
+		}
+		return nil
+	}
+}
+
+// isShuffleReply checks the reply echoes the received nodes
+// and carries the expected number of passive view nodes.
+func isShuffleReply(received []string, n int) func(msg data.Message) error {
+	return func(msg data.Message) error {
+		reply := msg.Payload.(data.ShuffleReply)
+		if got := nodeIDs(reply.ReceivedNodes); !slices.Equal(got, received) {
+			return fmt.Errorf("received nodes %v, want %v", got, received)
+		}
+		if len(reply.Nodes) != n {
+			return fmt.Errorf("%d passive view nodes %v, want %d", len(reply.Nodes), nodeIDs(reply.Nodes), n)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,191 @@
+package conformance
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tamararankovic/hyparview/data"
+	"github.com/tamararankovic/hyparview/testing/invariants"
+)
+
+func JoinMsg(id string) data.Message {
+	return data.Message{Type: data.JOIN, Payload: data.Join{NodeID: id, ListenAddress: id}}
+}
+
+func ForwardJoinMsg(id string, ttl int) data.Message {
+	return data.Message{Type: data.FORWARD_JOIN, Payload: data.ForwardJoin{NodeID: id, ListenAddress: id, TTL: ttl}}
+}
+
+func DisconnectMsg(id string) data.Message {
+	return data.Message{Type: data.DISCONNECT, Payload: data.Disconnect{NodeID: id}}
+}
+
+func NeighborMsg(id string, highPriority bool) data.Message {
+	return data.Message{Type: data.NEIGHTBOR, Payload: data.Neighbor{NodeID: id, ListenAddress: id, HighPriority: highPriority}}
+}
+
+func NeighborReplyMsg(id string, accepted bool) data.Message {
+	return data.Message{Type: data.NEIGHTBOR_REPLY, Payload: data.NeighborReply{NodeID: id, ListenAddress: id, Accepted: accepted}}
+}
+
+// ShuffleMsg is the shuffle started by the origin carrying the nodes with the IDs.
+func ShuffleMsg(origin string, ttl int, ids ...string) data.Message {
+	return data.Message{Type: data.SHUFFLE, Payload: data.Shuffle{NodeID: origin, ListenAddress: origin, Nodes: nodes(ids...), TTL: ttl}}
+}
+
+func nodes(ids ...string) []data.Node {
+	nodes := make([]data.Node, len(ids))
+	for i, id := range ids {
+		nodes[i] = data.Node{ID: id, ListenAddress: id}
+	}
+	return nodes
+}
+
+func nodeIDs(nodes []data.Node) []string {
+	ids := make([]string, len(nodes))
+	for i, node := range nodes {
+		ids[i] = node.ID
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// Start starts the peers, so that the node under test can reach them.
+func Start(ids ...string) Step {
+	return func(e *Env) error {
+		for _, id := range ids {
+			if _, err := e.Peer(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Send sends the msg from the peer to the node under test.
+func Send(from string, msg data.Message) Step {
+	return func(e *Env) error {
+		peer, err := e.Peer(from)
+		if err != nil {
+			return err
+		}
+		return peer.Send(msg)
+	}
+}
+
+// Join makes the peers join through the node under test one by one.
+func Join(ids ...string) Step {
+	return func(e *Env) error {
+		for _, id := range ids {
+			if err := Send(id, JoinMsg(id))(e); err != nil {
+				return err
+			}
+			if err := InActiveView(id)(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Expect waits for the peer to get a msg of the type and checks it.
+func Expect(to string, msgType data.MessageType, check func(msg data.Message) error) Step {
+	return func(e *Env) error {
+		peer, err := e.Peer(to)
+		if err != nil {
+			return err
+		}
+		msg, err := peer.Expect(msgType)
+		if err != nil {
+			return err
+		}
+		if check == nil {
+			return nil
+		}
+		if err := check(msg); err != nil {
+			return fmt.Errorf("%s msg to peer %s: %w", msgType, to, err)
+		}
+		return nil
+	}
+}
+
+// ExpectNone checks the peers do not get a msg of the type.
+func ExpectNone(msgType data.MessageType, ids ...string) Step {
+	return func(e *Env) error {
+		for _, id := range ids {
+			peer, err := e.Peer(id)
+			if err != nil {
+				return err
+			}
+			if err := peer.ExpectNone(msgType); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// expectAny waits for one of the peers to get a msg of the type
+// and returns the ID of the peer along with the msg.
+func expectAny(e *Env, msgType data.MessageType, ids ...string) (string, data.Message, error) {
+	deadline := time.Now().Add(Timeout)
+	for time.Now().Before(deadline) {
+		for _, id := range ids {
+			peer, err := e.Peer(id)
+			if err != nil {
+				return "", data.Message{}, err
+			}
+			if msg, ok := peer.take(msgType); ok {
+				return id, msg, nil
+			}
+		}
+		time.Sleep(Timeout / 100)
+	}
+	return "", data.Message{}, fmt.Errorf("none of the peers %s got a %s msg within %s", strings.Join(ids, ", "), msgType, Timeout)
+}
+
+// ActiveView checks the active view of the node under test holds exactly the nodes.
+func ActiveView(ids ...string) Step {
+	want := slices.Clone(ids)
+	slices.Sort(want)
+	return func(e *Env) error {
+		return e.Eventually(func(snapshot invariants.Snapshot) error {
+			if got := nodeIDs(snapshot.Active); !slices.Equal(got, want) {
+				return fmt.Errorf("active view %v, want %v", got, want)
+			}
+			return nil
+		})
+	}
+}
+
+func InActiveView(ids ...string) Step {
+	return inView("active", func(snapshot invariants.Snapshot) []data.Node { return snapshot.Active }, true, ids)
+}
+
+func NotInActiveView(ids ...string) Step {
+	return inView("active", func(snapshot invariants.Snapshot) []data.Node { return snapshot.Active }, false, ids)
+}
+
+func InPassiveView(ids ...string) Step {
+	return inView("passive", func(snapshot invariants.Snapshot) []data.Node { return snapshot.Passive }, true, ids)
+}
+
+func NotInPassiveView(ids ...string) Step {
+	return inView("passive", func(snapshot invariants.Snapshot) []data.Node { return snapshot.Passive }, false, ids)
+}
+
+func inView(name string, view func(snapshot invariants.Snapshot) []data.Node, in bool, ids []string) Step {
+	return func(e *Env) error {
+		return e.Eventually(func(snapshot invariants.Snapshot) error {
+			got := nodeIDs(view(snapshot))
+			for _, id := range ids {
+				if slices.Contains(got, id) != in {
+					return fmt.Errorf("%s view %v, want it to hold %s: %v", name, got, id, in)
+				}
+			}
+			return nil
+		})
+	}
+}
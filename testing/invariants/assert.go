@@ -0,0 +1,33 @@
+package invariants
+
+import (
+	"testing"
+	"time"
+)
+
+// Assert fails the test if the invariants, all of them if none are given,
+// do not hold for the snapshots.
+func Assert(t testing.TB, snapshots []Snapshot, invariants ...Invariant) {
+	t.Helper()
+	if err := Check(snapshots, invariants...); err != nil {
+		t.Error(err)
+	}
+}
+
+// AssertEventually takes the snapshots until the invariants hold
+// and fails the test with the last violations once the timeout expires.
+func AssertEventually(t testing.TB, timeout time.Duration, take func() []Snapshot, invariants ...Invariant) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		err := Check(take(), invariants...)
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("invariants do not hold after %s: %v", timeout, err)
+			return
+		}
+		time.Sleep(timeout / 50)
+	}
+}
@@ -0,0 +1,308 @@
+// Package invariants checks the HyParView protocol invariants
+// over the views of the nodes forming an overlay.
+//
+// A test takes the snapshots of the nodes after every simulation step
+// and asserts the invariants hold:
+//
+//	invariants.Assert(t, invariants.TakeAll(nodes...))
+package invariants
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/tamararankovic/hyparview/data"
+	"github.com/tamararankovic/hyparview/hyparview"
+)
+
+// Snapshot is the state of the views of a node at a point in time.
+type Snapshot struct {
+	Self    data.Node
+	Active  []data.Node
+	Passive []data.Node
+	// ActiveViewSize and PassiveViewSize are the view capacities
+	ActiveViewSize  int
+	PassiveViewSize int
+}
+
+// Take copies the views of the node.
+func Take(hv *hyparview.HyParView) Snapshot {
+	config := hv.Config()
+	snapshot := Snapshot{
+		Self:            hv.Self(),
+		Active:          make([]data.Node, 0),
+		Passive:         make([]data.Node, 0),
+		ActiveViewSize:  config.ActiveViewSize(),
+		PassiveViewSize: config.PassiveViewSize,
+	}
+	for _, peer := range hv.GetPeers() {
+		snapshot.Active = append(snapshot.Active, peer.Node())
+	}
+	for _, peer := range hv.GetPassivePeers() {
+		snapshot.Passive = append(snapshot.Passive, peer.Node())
+	}
+	return snapshot
+}
+
+type namedView struct {
+	name  string
+	nodes []data.Node
+}
+
+func (s Snapshot) views() []namedView {
+	return []namedView{{"active", s.Active}, {"passive", s.Passive}}
+}
+
+// TakeAll copies the views of the nodes.
+func TakeAll(hvs ...*hyparview.HyParView) []Snapshot {
+	snapshots := make([]Snapshot, len(hvs))
+	for i, hv := range hvs {
+		snapshots[i] = Take(hv)
+	}
+	return snapshots
+}
+
+// Violation is a failed invariant along with the views that break it.
+type Violation struct {
+	Invariant string
+	NodeID    string
+	Details   string
+}
+
+func (v Violation) String() string {
+	if v.NodeID == "" {
+		return fmt.Sprintf("%s: %s", v.Invariant, v.Details)
+	}
+	return fmt.Sprintf("%s: node %s: %s", v.Invariant, v.NodeID, v.Details)
+}
+
+// Violations is the error returned when the invariants do not hold.
+type Violations []Violation
+
+func (v Violations) Error() string {
+	lines := make([]string, len(v))
+	for i, violation := range v {
+		lines[i] = violation.String()
+	}
+	return fmt.Sprintf("%d invariant violations:\n%s", len(v), strings.Join(lines, "\n"))
+}
+
+// Invariant returns the violations found in the snapshots of the overlay nodes.
+type Invariant func(snapshots []Snapshot) []Violation
+
+// All holds every invariant Check verifies by default.
+var All = []Invariant{
+	SymmetricActiveLinks,
+	NoSelfInViews,
+	DisjointViews,
+	BoundedViews,
+	Connected,
+}
+
+// Check verifies the invariants, all of them if none are given,
+// and returns Violations if any of them does not hold.
+func Check(snapshots []Snapshot, invariants ...Invariant) error {
+	if len(invariants) == 0 {
+		invariants = All
+	}
+	var violations Violations
+	for _, invariant := range invariants {
+		violations = append(violations, invariant(snapshots)...)
+	}
+	if len(violations) > 0 {
+		return violations
+	}
+	return nil
+}
+
+// SymmetricActiveLinks checks that a node is in the active view
+// of every node in its active view.
+func SymmetricActiveLinks(snapshots []Snapshot) []Violation {
+	byID := index(snapshots)
+	violations := make([]Violation, 0)
+	for _, s := range snapshots {
+		for _, peer := range s.Active {
+			other, ok := byID[peer.ID]
+			if !ok {
+				violations = append(violations, Violation{
+					Invariant: "symmetric active links",
+					NodeID:    s.Self.ID,
+					Details:   fmt.Sprintf("active view holds %s which is not part of the overlay\n  active: %s", peer.ID, ids(s.Active)),
+				})
+				continue
+			}
+			if !contains(other.Active, s.Self.ID) {
+				violations = append(violations, Violation{
+					Invariant: "symmetric active links",
+					NodeID:    s.Self.ID,
+					Details: fmt.Sprintf("active view holds %s but the active view of %s lacks %s\n  %s active: %s\n  %s active: %s",
+						peer.ID, peer.ID, s.Self.ID, s.Self.ID, ids(s.Active), peer.ID, ids(other.Active)),
+				})
+			}
+		}
+	}
+	return violations
+}
+
+// NoSelfInViews checks that no node has itself in a view.
+func NoSelfInViews(snapshots []Snapshot) []Violation {
+	violations := make([]Violation, 0)
+	for _, s := range snapshots {
+		for _, v := range s.views() {
+			view, nodes := v.name, v.nodes
+			if contains(nodes, s.Self.ID) {
+				violations = append(violations, Violation{
+					Invariant: "no self in views",
+					NodeID:    s.Self.ID,
+					Details:   fmt.Sprintf("%s view holds the node itself\n  %s: %s", view, view, ids(nodes)),
+				})
+			}
+		}
+	}
+	return violations
+}
+
+// DisjointViews checks that no node is in both views of another node
+// and that no node appears twice in a view.
+func DisjointViews(snapshots []Snapshot) []Violation {
+	violations := make([]Violation, 0)
+	for _, s := range snapshots {
+		both := make([]string, 0)
+		for _, node := range s.Active {
+			if contains(s.Passive, node.ID) {
+				both = append(both, node.ID)
+			}
+		}
+		if len(both) > 0 {
+			violations = append(violations, Violation{
+				Invariant: "disjoint views",
+				NodeID:    s.Self.ID,
+				Details:   fmt.Sprintf("%s in both views\n  active:  %s\n  passive: %s", strings.Join(both, ", "), ids(s.Active), ids(s.Passive)),
+			})
+		}
+		for _, v := range s.views() {
+			view, nodes := v.name, v.nodes
+			if dups := duplicates(nodes); len(dups) > 0 {
+				violations = append(violations, Violation{
+					Invariant: "disjoint views",
+					NodeID:    s.Self.ID,
+					Details:   fmt.Sprintf("%s repeated in the %s view\n  %s: %s", strings.Join(dups, ", "), view, view, ids(nodes)),
+				})
+			}
+		}
+	}
+	return violations
+}
+
+// BoundedViews checks that the views do not exceed their capacities.
+func BoundedViews(snapshots []Snapshot) []Violation {
+	violations := make([]Violation, 0)
+	for _, s := range snapshots {
+		if len(s.Active) > s.ActiveViewSize {
+			violations = append(violations, Violation{
+				Invariant: "bounded views",
+				NodeID:    s.Self.ID,
+				Details:   fmt.Sprintf("active view holds %d nodes, the capacity is %d\n  active: %s", len(s.Active), s.ActiveViewSize, ids(s.Active)),
+			})
+		}
+		if len(s.Passive) > s.PassiveViewSize {
+			violations = append(violations, Violation{
+				Invariant: "bounded views",
+				NodeID:    s.Self.ID,
+				Details:   fmt.Sprintf("passive view holds %d nodes, the capacity is %d\n  passive: %s", len(s.Passive), s.PassiveViewSize, ids(s.Passive)),
+			})
+		}
+	}
+	return violations
+}
+
+// Connected checks that every node is reachable from every other one
+// over the active links, taken in both directions.
+func Connected(snapshots []Snapshot) []Violation {
+	byID := index(snapshots)
+	neighbors := make(map[string][]string)
+	for _, s := range snapshots {
+		for _, peer := range s.Active {
+			if _, ok := byID[peer.ID]; !ok {
+				continue
+			}
+			neighbors[s.Self.ID] = append(neighbors[s.Self.ID], peer.ID)
+			neighbors[peer.ID] = append(neighbors[peer.ID], s.Self.ID)
+		}
+	}
+	visited := make(map[string]bool)
+	components := make([][]string, 0)
+	for _, s := range snapshots {
+		if visited[s.Self.ID] {
+			continue
+		}
+		component := make([]string, 0)
+		queue := []string{s.Self.ID}
+		visited[s.Self.ID] = true
+		for len(queue) > 0 {
+			id := queue[0]
+			queue = queue[1:]
+			component = append(component, id)
+			for _, neighbor := range neighbors[id] {
+				if !visited[neighbor] {
+					visited[neighbor] = true
+					queue = append(queue, neighbor)
+				}
+			}
+		}
+		sort.Strings(component)
+		components = append(components, component)
+	}
+	if len(components) <= 1 {
+		return nil
+	}
+	sort.Slice(components, func(i, j int) bool {
+		return len(components[i]) > len(components[j])
+	})
+	lines := make([]string, len(components))
+	for i, component := range components {
+		lines[i] = fmt.Sprintf("  component %d: [%s]", i+1, strings.Join(component, " "))
+	}
+	return []Violation{{
+		Invariant: "connected",
+		Details:   fmt.Sprintf("the active links split %d nodes into %d components\n%s", len(snapshots), len(components), strings.Join(lines, "\n")),
+	}}
+}
+
+func index(snapshots []Snapshot) map[string]Snapshot {
+	byID := make(map[string]Snapshot, len(snapshots))
+	for _, s := range snapshots {
+		byID[s.Self.ID] = s
+	}
+	return byID
+}
+
+func contains(nodes []data.Node, id string) bool {
+	return slices.ContainsFunc(nodes, func(node data.Node) bool {
+		return node.ID == id
+	})
+}
+
+func duplicates(nodes []data.Node) []string {
+	seen := make(map[string]int)
+	dups := make([]string, 0)
+	for _, node := range nodes {
+		seen[node.ID]++
+		if seen[node.ID] == 2 {
+			dups = append(dups, node.ID)
+		}
+	}
+	return dups
+}
+
+// ids formats the sorted node IDs.
+func ids(nodes []data.Node) string {
+	list := make([]string, len(nodes))
+	for i, node := range nodes {
+		list[i] = node.ID
+	}
+	sort.Strings(list)
+	return "[" + strings.Join(list, " ") + "]"
+}
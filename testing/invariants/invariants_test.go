@@ -0,0 +1,105 @@
+package invariants
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tamararankovic/hyparview/data"
+)
+
+func node(id string) data.Node {
+	return data.Node{ID: id, ListenAddress: id}
+}
+
+func snapshot(id string, active []string, passive ...string) Snapshot {
+	s := Snapshot{Self: node(id), ActiveViewSize: 2, PassiveViewSize: 2}
+	for _, peer := range active {
+		s.Active = append(s.Active, node(peer))
+	}
+	for _, peer := range passive {
+		s.Passive = append(s.Passive, node(peer))
+	}
+	return s
+}
+
+func TestCheckHealthyOverlay(t *testing.T) {
+	snapshots := []Snapshot{
+		snapshot("a", []string{"b", "c"}),
+		snapshot("b", []string{"a"}, "c"),
+		snapshot("c", []string{"a"}, "b"),
+	}
+	if err := Check(snapshots); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckViolations(t *testing.T) {
+	tests := []struct {
+		name      string
+		snapshots []Snapshot
+		invariant Invariant
+		want      []string
+	}{
+		{
+			name:      "asymmetric link",
+			snapshots: []Snapshot{snapshot("a", []string{"b"}), snapshot("b", nil)},
+			invariant: SymmetricActiveLinks,
+			want:      []string{"node a: active view holds b but the active view of b lacks a", "b active: []"},
+		},
+		{
+			name:      "unknown peer",
+			snapshots: []Snapshot{snapshot("a", []string{"x"})},
+			invariant: SymmetricActiveLinks,
+			want:      []string{"active view holds x which is not part of the overlay"},
+		},
+		{
+			name:      "self in passive view",
+			snapshots: []Snapshot{snapshot("a", nil, "a")},
+			invariant: NoSelfInViews,
+			want:      []string{"node a: passive view holds the node itself"},
+		},
+		{
+			name:      "node in both views",
+			snapshots: []Snapshot{snapshot("a", []string{"b"}, "b")},
+			invariant: DisjointViews,
+			want:      []string{"b in both views", "active:  [b]", "passive: [b]"},
+		},
+		{
+			name:      "repeated node",
+			snapshots: []Snapshot{snapshot("a", nil, "b", "b")},
+			invariant: DisjointViews,
+			want:      []string{"b repeated in the passive view"},
+		},
+		{
+			name:      "active view over capacity",
+			snapshots: []Snapshot{snapshot("a", []string{"b", "c", "d"})},
+			invariant: BoundedViews,
+			want:      []string{"active view holds 3 nodes, the capacity is 2"},
+		},
+		{
+			name: "partitioned overlay",
+			snapshots: []Snapshot{
+				snapshot("a", []string{"b"}),
+				snapshot("b", []string{"a"}),
+				snapshot("c", nil),
+			},
+			invariant: Connected,
+			want:      []string{"split 3 nodes into 2 components", "component 1: [a b]", "component 2: [c]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Check(tt.snapshots, tt.invariant)
+			var violations Violations
+			if !errors.As(err, &violations) {
+				t.Fatalf("expected violations, got %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("violations lack %q:\n%v", want, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package invariants
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/tamararankovic/hyparview/data"
+	"github.com/tamararankovic/hyparview/hyparview"
+	"github.com/tamararankovic/hyparview/transport"
+)
+
+const settleTimeout = 5 * time.Second
+
+// simulation is an overlay of in-process nodes on a memory network.
+type simulation struct {
+	t       *testing.T
+	network *transport.MemNetwork
+	nodes   map[string]*hyparview.HyParView
+	order   []string
+}
+
+func newSimulation(t *testing.T) *simulation {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(out) })
+	s := &simulation{
+		t:       t,
+		network: transport.NewMemNetwork(transport.DefaultConnConfig()),
+		nodes:   make(map[string]*hyparview.HyParView),
+	}
+	t.Cleanup(func() {
+		for _, hv := range s.nodes {
+			hv.Stop()
+		}
+	})
+	return s
+}
+
+// start starts the node, it joins through the contact node unless it is empty.
+func (s *simulation) start(id, contact string) {
+	s.t.Helper()
+	config := hyparview.DefaultConfig(10000)
+	// the active views of the surviving nodes never fill up, so the nodes cut off
+	// by the kill and the partition are accepted as neighbors by the others
+	config.Fanout = 6
+	config.ShuffleInterval = time.Hour
+	self := data.Node{ID: id, ListenAddress: id}
+	connManager := transport.NewConnManager(self, s.network.NewConnFn(id), s.network.AcceptConnsFn(id))
+	hv, err := hyparview.NewHyParView(config, self, connManager)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	s.nodes[id] = hv
+	s.order = append(s.order, id)
+	if contact == "" {
+		return
+	}
+	if err := hv.Join(contact); err != nil {
+		s.t.Fatalf("%s failed to join through %s: %v", id, contact, err)
+	}
+}
+
+// kill stops the node without telling its peers.
+func (s *simulation) kill(id string) {
+	s.nodes[id].Stop()
+	s.network.Isolate(id)
+	delete(s.nodes, id)
+}
+
+// rejoin joins the running node through the contact node again. A join going over a conn
+// the partition closed before the node noticed fails, so the join is retried for a while.
+func (s *simulation) rejoin(id, contact string) {
+	s.t.Helper()
+	deadline := time.Now().Add(settleTimeout)
+	for {
+		err := s.nodes[id].Join(contact)
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			s.t.Fatalf("%s failed to rejoin through %s: %v", id, contact, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// shuffle makes every running node shuffle once.
+func (s *simulation) shuffle() {
+	for _, hv := range s.nodes {
+		_ = hv.Shuffle()
+	}
+}
+
+// take snapshots the views of the running nodes.
+func (s *simulation) take() []Snapshot {
+	snapshots := make([]Snapshot, 0, len(s.nodes))
+	for _, id := range s.order {
+		if hv, ok := s.nodes[id]; ok {
+			snapshots = append(snapshots, Take(hv))
+		}
+	}
+	return snapshots
+}
+
+// step runs the step and waits for the invariants to hold over the running nodes.
+func (s *simulation) step(name string, run func(), invariants ...Invariant) {
+	s.t.Helper()
+	run()
+	AssertEventually(s.t, settleTimeout, s.take, invariants...)
+	if s.t.Failed() {
+		s.t.Fatalf("invariants broken by step %s", name)
+	}
+}
+
+func TestSimulatedOverlay(t *testing.T) {
+	s := newSimulation(t)
+	s.step("start node-0", func() { s.start("node-0", "") })
+	for i := 1; i < 10; i++ {
+		id := fmt.Sprintf("node-%d", i)
+		s.step("join "+id, func() { s.start(id, fmt.Sprintf("node-%d", i/2)) })
+	}
+	// the shuffles fill the passive views the nodes replace the killed node from
+	for round := 0; round < 3; round++ {
+		s.step(fmt.Sprintf("shuffle round %d", round), s.shuffle)
+	}
+	s.step("kill node-3", func() { s.kill("node-3") })
+	s.step("shuffle after the kill", s.shuffle)
+	left := []string{"node-0", "node-1", "node-2", "node-4"}
+	right := []string{"node-5", "node-6", "node-7", "node-8", "node-9"}
+	// the links across the partition break, the overlay is split until it heals
+	s.step("partition", func() { s.network.Partition(left, right) },
+		SymmetricActiveLinks, NoSelfInViews, DisjointViews, BoundedViews)
+	// the nodes only reconnect across the healed partition by joining again
+	s.step("heal", func() {
+		s.network.Heal()
+		for i, id := range right {
+			s.rejoin(id, left[i%len(left)])
+		}
+	})
+}
@@ -0,0 +1,186 @@
+package transport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/tamararankovic/hyparview/data"
+)
+
+// Compression identifies the codec used to compress msgs on a connection.
+type Compression int8
+
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionSnappy
+	CompressionZstd
+)
+
+// maxDecompressedSize protects the receiver from decompression bombs.
+const maxDecompressedSize = 16 << 20
+
+var errDecompressedTooLarge = errors.New("decompressed msg too large")
+
+var compressionNames = map[Compression]string{
+	CompressionNone:   "none",
+	CompressionGzip:   "gzip",
+	CompressionSnappy: "snappy",
+	CompressionZstd:   "zstd",
+}
+
+func (c Compression) String() string {
+	if name, ok := compressionNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", c)
+}
+
+func ParseCompression(name string) (Compression, error) {
+	for compression, compressionName := range compressionNames {
+		if compressionName == name {
+			return compression, nil
+		}
+	}
+	return CompressionNone, fmt.Errorf("unknown compression %s", name)
+}
+
+// negotiateCompression picks the first of the offered codecs that is supported locally.
+func negotiateCompression(offered []string, supported []Compression) Compression {
+	for _, name := range offered {
+		compression, err := ParseCompression(name)
+		if err != nil {
+			continue
+		}
+		for _, s := range supported {
+			if s == compression {
+				return compression
+			}
+		}
+	}
+	return CompressionNone
+}
+
+func compressionNamesOf(compressions []Compression) []string {
+	names := make([]string, len(compressions))
+	for i, compression := range compressions {
+		names[i] = compression.String()
+	}
+	return names
+}
+
+type compressor interface {
+	compress(src []byte) ([]byte, error)
+	decompress(src []byte) ([]byte, error)
+}
+
+var compressors = map[Compression]compressor{
+	CompressionGzip:   gzipCompressor{},
+	CompressionSnappy: snappyCompressor{},
+	CompressionZstd:   newZstdCompressor(),
+}
+
+// compress wraps the serialized msg into a compressed msg.
+func compress(compression Compression, msgSerialized []byte) ([]byte, error) {
+	c, ok := compressors[compression]
+	if !ok {
+		return nil, fmt.Errorf("compression %s not supported", compression)
+	}
+	compressed, err := c.compress(msgSerialized)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{byte(data.COMPRESSED), byte(compression)}, compressed...), nil
+}
+
+// decompress unwraps the serialized msg out of a compressed msg.
+func decompress(msgCompressed []byte) ([]byte, error) {
+	if !isCompressed(msgCompressed) || len(msgCompressed) < 2 {
+		return nil, errors.New("not a compressed message")
+	}
+	compression := Compression(msgCompressed[1])
+	c, ok := compressors[compression]
+	if !ok {
+		return nil, fmt.Errorf("compression %s not supported", compression)
+	}
+	return c.decompress(msgCompressed[2:])
+}
+
+func isCompressed(msgSerialized []byte) bool {
+	return len(msgSerialized) > 0 && data.MessageType(msgSerialized[0]) == data.COMPRESSED
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) decompress(src []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	decompressed, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(decompressed) > maxDecompressedSize {
+		return nil, errDecompressedTooLarge
+	}
+	return decompressed, nil
+}
+
+type snappyCompressor struct{}
+
+func (snappyCompressor) compress(src []byte) ([]byte, error) {
+	return s2.EncodeSnappy(nil, src), nil
+}
+
+func (snappyCompressor) decompress(src []byte) ([]byte, error) {
+	size, err := s2.DecodedLen(src)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxDecompressedSize {
+		return nil, errDecompressedTooLarge
+	}
+	return s2.Decode(nil, src)
+}
+
+type zstdCompressor struct {
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func newZstdCompressor() zstdCompressor {
+	// errors are only returned for invalid options
+	encoder, _ := zstd.NewWriter(nil)
+	decoder, _ := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressedSize))
+	return zstdCompressor{
+		encoder: encoder,
+		decoder: decoder,
+	}
+}
+
+func (z zstdCompressor) compress(src []byte) ([]byte, error) {
+	return z.encoder.EncodeAll(src, nil), nil
+}
+
+func (z zstdCompressor) decompress(src []byte) ([]byte, error) {
+	return z.decoder.DecodeAll(src, nil)
+}
@@ -0,0 +1,72 @@
+package transport
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/tamararankovic/hyparview/data"
+)
+
+func largeShuffle(b *testing.B) []byte {
+	nodes := make([]data.Node, 200)
+	for i := range nodes {
+		nodes[i] = data.Node{
+			ID:            fmt.Sprintf("node-%d", i),
+			ListenAddress: fmt.Sprintf("10.0.%d.%d:7000", i/256, i%256),
+		}
+	}
+	msgSerialized, err := serialize(data.Message{
+		Type: data.SHUFFLE,
+		Payload: data.Shuffle{
+			NodeID:        "node",
+			ListenAddress: "10.0.0.1:7000",
+			Nodes:         nodes,
+			TTL:           3,
+		},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return msgSerialized
+}
+
+var benchmarkCompressions = []Compression{CompressionGzip, CompressionSnappy, CompressionZstd}
+
+func BenchmarkCompress(b *testing.B) {
+	msgSerialized := largeShuffle(b)
+	for _, compression := range benchmarkCompressions {
+		b.Run(compression.String(), func(b *testing.B) {
+			b.SetBytes(int64(len(msgSerialized)))
+			var compressed []byte
+			for i := 0; i < b.N; i++ {
+				var err error
+				compressed, err = compress(compression, msgSerialized)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(compressed)), "bytes/msg")
+			b.ReportMetric(float64(len(compressed))/float64(len(msgSerialized)), "ratio")
+		})
+	}
+}
+
+func BenchmarkDecompress(b *testing.B) {
+	msgSerialized := largeShuffle(b)
+	for _, compression := range benchmarkCompressions {
+		b.Run(compression.String(), func(b *testing.B) {
+			compressed, err := compress(compression, msgSerialized)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(len(msgSerialized)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := decompress(compressed)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
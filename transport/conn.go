@@ -1,11 +1,101 @@
 package transport
 
-import "github.com/tamararankovic/hyparview/data"
+import (
+	"errors"
+	"time"
+
+	"github.com/tamararankovic/hyparview/data"
+)
+
+var (
+	ErrConnClosed    = errors.New("connection closed")
+	ErrSendQueueFull = errors.New("send queue full")
+	ErrFrameTooLarge = errors.New("frame too large")
+	// ErrBlockingSends is returned for the conns the conn manager refuses to run the overlay over
+	ErrBlockingSends = errors.New("sends blocking on a full send queue")
+)
+
+// isClosed reports whether the conn is known to be closed,
+// the conns not telling it are taken as open.
+func isClosed(conn Conn) bool {
+	closer, ok := conn.(interface{ isClosed() bool })
+	return ok && closer.isClosed()
+}
+
+// blocksWhenFull reports whether Send waits for room in the send queue of the conn.
+func blocksWhenFull(conn Conn) bool {
+	sender, ok := conn.(interface{ overflowPolicy() OverflowPolicy })
+	return ok && sender.overflowPolicy() == OverflowBlock
+}
+
+// receivedAll returns the channel closed once the msgs received over the conn
+// are all handled and no more will be, nil for the conns not telling it.
+func receivedAll(conn Conn) <-chan struct{} {
+	receiver, ok := conn.(interface{ receivedAll() <-chan struct{} })
+	if !ok {
+		return nil
+	}
+	return receiver.receivedAll()
+}
 
 type Conn interface {
 	GetAddress() string
+	// GetRemoteNode returns the identity the remote node announced in the handshake.
+	GetRemoteNode() data.Node
+	// GetQueueDepth returns the number of messages waiting to be written.
+	GetQueueDepth() int
 	Send(msg data.Message) error
 	onReceive(handler func(msg data.Message))
 	disconnect() error
 	onDisconnect(handler func())
+	// onMalformedMsg registers the handler of the received msgs that could not be decoded
+	onMalformedMsg(handler func(err error))
+	setRemoteNode(node data.Node)
+	supportedCompressions() []Compression
+	setCompression(compression Compression)
+}
+
+// OverflowPolicy decides what Send does when the send queue of a connection is full.
+type OverflowPolicy int8
+
+const (
+	// OverflowDrop drops the message and returns ErrSendQueueFull.
+	OverflowDrop OverflowPolicy = iota
+	// OverflowBlock blocks the caller until there is room in the queue. The conn manager
+	// refuses the conns with it, the overlay sends to a slow peer must not hold up the others.
+	OverflowBlock
+	// OverflowDisconnect drops the message and closes the connection to the slow peer.
+	OverflowDisconnect
+)
+
+type ConnConfig struct {
+	SendQueueSize int
+	// WriteTimeout and ReadTimeout are disabled when zero,
+	// an expired read deadline closes the connection.
+	WriteTimeout,
+	ReadTimeout time.Duration
+	OverflowPolicy OverflowPolicy
+	// BatchSize is the max number of queued messages coalesced into one frame,
+	// batching is disabled when it is less than 2. The writer waits at most
+	// BatchLatency for more messages before the batch is written.
+	BatchSize    int
+	BatchLatency time.Duration
+	// Compression lists the supported codecs in order of preference,
+	// the codec is negotiated in the handshake. Only msgs of at least
+	// CompressionThreshold bytes are compressed.
+	Compression          []Compression
+	CompressionThreshold int
+}
+
+func DefaultConnConfig() ConnConfig {
+	return ConnConfig{
+		SendQueueSize:        128,
+		WriteTimeout:         10 * time.Second,
+		ReadTimeout:          0,
+		OverflowPolicy:       OverflowDrop,
+		BatchSize:            1,
+		BatchLatency:         0,
+		Compression:          nil,
+		CompressionThreshold: 1024,
+	}
 }
@@ -1,88 +1,737 @@
 package transport
 
 import (
+	"context"
+	"crypto/rand"
 	"errors"
+	"fmt"
 	"log"
+	"maps"
+	"net"
 	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/tamararankovic/hyparview/data"
 )
 
+// ProtocolVersion is exchanged in the handshake, connections from nodes
+// speaking a different version are rejected.
+const ProtocolVersion = 2
+
+const (
+	handshakeTimeout   = 5 * time.Second
+	defaultIdleConnTTL = 30 * time.Second
+	resolveTimeout     = time.Second
+
+	rejectDuplicate = "duplicate connection"
+	rejectVersion   = "protocol version mismatch"
+	rejectSelf      = "connection to self"
+	// rejectAuthentication is also the reason the dialing side gives up on the conn with
+	rejectAuthentication = "authentication failed"
+
+	// nonceSize is the size of the handshake challenges in bytes
+	nonceSize = 32
+)
+
+var ErrDuplicateConn = errors.New("duplicate connection")
+
+// Authenticator proves the node ID of this node in the handshakes and checks the one claimed
+// by the remote node, so no conn can be opened on behalf of another node. The challenges
+// bind the signer, the node it talks to and a fresh nonce, so the signatures can not be replayed.
+// Either all the nodes of an overlay authenticate the conns or none of them does.
+type Authenticator interface {
+	SignChallenge(challenge []byte) ([]byte, error)
+	VerifyChallenge(nodeID string, challenge, signature []byte) error
+}
+
 type ConnManager struct {
-	conns              []Conn
+	self data.Node
+	// conns holds the handshaked connections indexed by the remote node ID
+	conns map[string]Conn
+	// links holds the IDs of the nodes we maintain an overlay link with,
+	// only changes of those connections are reported to the subscribers
+	links map[string]struct{}
+	// idle holds the IDs of the nodes whose ephemeral connections
+	// were released and the time they were released at
+	idle        map[string]time.Time
+	idleConnTTL time.Duration
+	// dialing holds the addresses of the outgoing connections in progress
+	dialing            map[string]struct{}
+	authenticator      Authenticator
+	connsChanged       chan struct{}
+	lock               sync.Mutex
 	newConnFn          func(address string) (Conn, error)
 	acceptConnsFn      func(stopCh chan struct{}, handler func(conn Conn)) error
 	stopAcceptingConns chan struct{}
-	connUp             chan Conn
-	connDown           chan Conn
-	messages           chan MsgReceived
+	stopOnce           sync.Once
+	connUp             *Broadcast[Conn]
+	connDown           *Broadcast[Conn]
+	messages           *Broadcast[MsgReceived]
+	malformed          *Broadcast[MalformedMsg]
 }
 
-func NewConnManager(newConnFn func(address string) (Conn, error), acceptConnsFn func(stopCh chan struct{}, handler func(conn Conn)) error) ConnManager {
-	return ConnManager{
-		conns:         make([]Conn, 0),
+func NewConnManager(self data.Node, newConnFn func(address string) (Conn, error), acceptConnsFn func(stopCh chan struct{}, handler func(conn Conn)) error) *ConnManager {
+	return &ConnManager{
+		self:          self,
+		conns:         make(map[string]Conn),
+		links:         make(map[string]struct{}),
+		idle:          make(map[string]time.Time),
+		idleConnTTL:   defaultIdleConnTTL,
+		dialing:       make(map[string]struct{}),
+		connsChanged:  make(chan struct{}),
 		newConnFn:     newConnFn,
 		acceptConnsFn: acceptConnsFn,
-		connUp:        make(chan Conn),
-		connDown:      make(chan Conn),
-		messages:      make(chan MsgReceived),
+		// closed to tell the accept fn to stop
+		stopAcceptingConns: make(chan struct{}),
+		connUp:             NewBroadcast[Conn](),
+		connDown:           NewBroadcast[Conn](),
+		messages:           NewBroadcast[MsgReceived](),
+		malformed:          NewBroadcast[MalformedMsg](),
 	}
 }
 
 func (cm *ConnManager) StartAcceptingConns() error {
 	return cm.acceptConnsFn(cm.stopAcceptingConns, func(conn Conn) {
 		log.Printf("new connection accepted %s\n", conn.GetAddress())
-		cm.addConn(conn)
+		go cm.acceptConn(conn)
 	})
 }
 
 func (cm *ConnManager) StopAcceptingConns() {
-	cm.stopAcceptingConns <- struct{}{}
+	cm.stopOnce.Do(func() {
+		close(cm.stopAcceptingConns)
+	})
+}
+
+// Close stops accepting connections and closes all the established ones.
+func (cm *ConnManager) Close() {
+	cm.StopAcceptingConns()
+	cm.lock.Lock()
+	conns := make([]Conn, 0, len(cm.conns))
+	for _, conn := range cm.conns {
+		conns = append(conns, conn)
+	}
+	cm.lock.Unlock()
+	for _, conn := range conns {
+		if err := conn.disconnect(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// SetIdleConnTTL sets how long a released ephemeral connection
+// is kept in the pool before it is closed.
+func (cm *ConnManager) SetIdleConnTTL(ttl time.Duration) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	cm.idleConnTTL = ttl
+}
+
+// SetAuthenticator makes the handshakes prove the node IDs of both sides,
+// it has to be set before the conn manager starts accepting conns.
+func (cm *ConnManager) SetAuthenticator(authenticator Authenticator) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	cm.authenticator = authenticator
+}
+
+func (cm *ConnManager) getAuthenticator() Authenticator {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	return cm.authenticator
 }
 
+// Connect returns a connection to the node listening on the address
+// and marks it as a long-lived overlay link.
 func (cm *ConnManager) Connect(address string) (Conn, error) {
+	conn, err := cm.connect(address)
+	if err != nil {
+		return nil, err
+	}
+	return conn, cm.Link(conn)
+}
+
+// ConnectEphemeral returns a connection to the node listening on the address
+// for a short exchange. The connection is taken from the idle pool if possible
+// and should be handed back with Release once it is not needed anymore.
+func (cm *ConnManager) ConnectEphemeral(address string) (Conn, error) {
+	conn, err := cm.connect(address)
+	if err != nil {
+		return nil, err
+	}
+	cm.lock.Lock()
+	delete(cm.idle, conn.GetRemoteNode().ID)
+	cm.lock.Unlock()
+	return conn, nil
+}
+
+// Release returns an ephemeral connection to the idle pool,
+// it is closed if it is not reused within the idle conn TTL.
+// Overlay links are not affected.
+func (cm *ConnManager) Release(conn Conn) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	nodeID := conn.GetRemoteNode().ID
+	if _, ok := cm.links[nodeID]; ok || cm.conns[nodeID] != conn {
+		return
+	}
+	releasedAt := time.Now()
+	cm.idle[nodeID] = releasedAt
+	time.AfterFunc(cm.idleConnTTL, func() {
+		cm.lock.Lock()
+		evict := cm.idle[nodeID] == releasedAt && cm.conns[nodeID] == conn
+		cm.lock.Unlock()
+		if evict {
+			log.Printf("closing idle connection %s [ID=%s]\n", conn.GetAddress(), nodeID)
+			_ = conn.disconnect()
+		}
+	})
+}
+
+// Link marks an established connection as an overlay link
+// and reports it to the conn up subscribers. It returns ErrConnClosed
+// if the connection closed before, no conn down is reported for it then.
+func (cm *ConnManager) Link(conn Conn) error {
+	cm.lock.Lock()
+	nodeID := conn.GetRemoteNode().ID
+	if cm.conns[nodeID] != conn {
+		cm.lock.Unlock()
+		if isClosed(conn) {
+			return ErrConnClosed
+		}
+		return errors.New("conn not found")
+	}
+	delete(cm.idle, nodeID)
+	if _, ok := cm.links[nodeID]; ok {
+		cm.lock.Unlock()
+		return nil
+	}
+	cm.links[nodeID] = struct{}{}
+	cm.lock.Unlock()
+	cm.connUp.Publish(conn)
+	return nil
+}
+
+// connect returns a handshaked connection to the node listening on the address.
+// An existing connection to that node is reused. If both nodes dial each other
+// at the same time, only the connection dialed by the node with the lower ID is kept.
+func (cm *ConnManager) connect(address string) (Conn, error) {
+	cm.lock.Lock()
+	if conn := cm.getConnByListenAddress(address); conn != nil {
+		cm.lock.Unlock()
+		return conn, nil
+	}
+	cm.dialing[address] = struct{}{}
+	cm.lock.Unlock()
+	defer func() {
+		cm.lock.Lock()
+		delete(cm.dialing, address)
+		cm.lock.Unlock()
+	}()
+
 	conn, err := cm.newConnFn(address)
 	if err != nil {
 		return nil, err
 	}
-	cm.addConn(conn)
-	cm.connUp <- conn
+	if blocksWhenFull(conn) {
+		_ = conn.disconnect()
+		return nil, fmt.Errorf("conn to %s refused: %w", address, ErrBlockingSends)
+	}
+	handshakes, state := cm.watch(conn)
+	defer state.complete(false)
+	nonce, err := newNonce()
+	if err != nil {
+		_ = conn.disconnect()
+		return nil, err
+	}
+	err = conn.Send(data.Message{
+		Type: data.HANDSHAKE,
+		Payload: data.Handshake{
+			NodeID:        cm.self.ID,
+			ListenAddress: cm.self.ListenAddress,
+			Version:       ProtocolVersion,
+			Compression:   compressionNamesOf(conn.supportedCompressions()),
+			Nonce:         nonce,
+		},
+	})
+	if err != nil {
+		_ = conn.disconnect()
+		return nil, err
+	}
+	msg, err := awaitHandshake(conn, handshakes, data.HANDSHAKE_REPLY)
+	if err != nil {
+		_ = conn.disconnect()
+		return nil, fmt.Errorf("handshake with %s failed: %w", address, err)
+	}
+	reply, ok := msg.Payload.(data.HandshakeReply)
+	if !ok {
+		_ = conn.disconnect()
+		return nil, fmt.Errorf("msg %v not a handshake reply msg", msg.Payload)
+	}
+	if !reply.Accepted {
+		_ = conn.disconnect()
+		if reply.Reason == rejectDuplicate {
+			return cm.awaitConn(reply.NodeID)
+		}
+		return nil, fmt.Errorf("handshake with %s rejected: %s", address, reply.Reason)
+	}
+	if reply.Version != ProtocolVersion {
+		_ = conn.disconnect()
+		return nil, fmt.Errorf("handshake with %s failed: %s (%d != %d)", address, rejectVersion, reply.Version, ProtocolVersion)
+	}
+	compression, err := ParseCompression(reply.Compression)
+	if err != nil {
+		_ = conn.disconnect()
+		return nil, fmt.Errorf("handshake with %s failed: %w", address, err)
+	}
+	if authenticator := cm.getAuthenticator(); authenticator != nil {
+		if err := cm.prove(conn, authenticator, reply, nonce); err != nil {
+			_ = conn.disconnect()
+			return nil, fmt.Errorf("handshake with %s failed: %w", address, err)
+		}
+	}
+	conn.setCompression(compression)
+	conn.setRemoteNode(data.Node{
+		ID:            reply.NodeID,
+		ListenAddress: reply.ListenAddress,
+	})
+	if existing := cm.register(conn); existing != conn {
+		_ = conn.disconnect()
+		return existing, nil
+	}
+	state.complete(true)
 	return conn, nil
 }
 
+// prove checks that the accepting side signed the nonce of the handshake
+// and proves the node ID of this node by signing the nonce of the reply.
+func (cm *ConnManager) prove(conn Conn, authenticator Authenticator, reply data.HandshakeReply, nonce []byte) error {
+	err := authenticator.VerifyChallenge(reply.NodeID, handshakeChallenge(reply.NodeID, cm.self.ID, nonce), reply.Signature)
+	if err != nil {
+		return fmt.Errorf("%s: %w", rejectAuthentication, err)
+	}
+	if len(reply.Nonce) != nonceSize {
+		return fmt.Errorf("%s: no challenge received", rejectAuthentication)
+	}
+	signature, err := authenticator.SignChallenge(handshakeChallenge(cm.self.ID, reply.NodeID, reply.Nonce))
+	if err != nil {
+		return err
+	}
+	return conn.Send(data.Message{
+		Type:    data.HANDSHAKE_PROOF,
+		Payload: data.HandshakeProof{Signature: signature},
+	})
+}
+
+// Disconnect closes the connection once its queued msgs are flushed.
+// It does not wait for the flush, so a slow peer holds up no caller.
 func (cm *ConnManager) Disconnect(conn Conn) error {
-	index := slices.Index(cm.conns, conn)
-	if index == -1 {
+	cm.lock.Lock()
+	registered, ok := cm.conns[conn.GetRemoteNode().ID]
+	cm.lock.Unlock()
+	if !ok || registered != conn {
 		return errors.New("conn not found")
 	}
-	cm.conns = slices.Delete(cm.conns, index, index+1)
-	err := conn.disconnect()
-	cm.connDown <- conn
-	return err
+	go func() {
+		if err := conn.disconnect(); err != nil {
+			log.Println(err)
+		}
+	}()
+	return nil
+}
+
+// QueueDepths returns the send queue depth of every connection
+// indexed by the remote node ID.
+func (cm *ConnManager) QueueDepths() map[string]int {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	depths := make(map[string]int, len(cm.conns))
+	for nodeID, conn := range cm.conns {
+		depths[nodeID] = conn.GetQueueDepth()
+	}
+	return depths
 }
 
 func (cm *ConnManager) OnConnUp(handler func(conn Conn)) Subscription {
-	return Subscribe(cm.connUp, handler)
+	return cm.connUp.Subscribe(handler)
 }
 
 func (cm *ConnManager) OnConnDown(handler func(conn Conn)) Subscription {
-	return Subscribe(cm.connDown, handler)
+	return cm.connDown.Subscribe(handler)
 }
 
 func (cm *ConnManager) OnReceive(handler func(msg MsgReceived)) Subscription {
-	return Subscribe(cm.messages, handler)
+	return cm.messages.Subscribe(handler)
+}
+
+// OnMalformedMsg registers the handler of the msgs that could not be decoded,
+// only the msgs received after the handshake are reported.
+func (cm *ConnManager) OnMalformedMsg(handler func(msg MalformedMsg)) Subscription {
+	return cm.malformed.Subscribe(handler)
+}
+
+func (cm *ConnManager) acceptConn(conn Conn) {
+	if blocksWhenFull(conn) {
+		log.Printf("connection from %s refused: %v\n", conn.GetAddress(), ErrBlockingSends)
+		_ = conn.disconnect()
+		return
+	}
+	handshakes, state := cm.watch(conn)
+	defer state.complete(false)
+	msg, err := awaitHandshake(conn, handshakes, data.HANDSHAKE)
+	if err != nil {
+		log.Printf("handshake with %s failed: %v\n", conn.GetAddress(), err)
+		_ = conn.disconnect()
+		return
+	}
+	handshake, ok := msg.Payload.(data.Handshake)
+	if !ok {
+		log.Printf("msg %v not a handshake msg\n", msg.Payload)
+		_ = conn.disconnect()
+		return
+	}
+	reason := cm.rejectReason(handshake)
+	compression := negotiateCompression(handshake.Compression, conn.supportedCompressions())
+	reply := data.HandshakeReply{
+		NodeID:        cm.self.ID,
+		ListenAddress: cm.self.ListenAddress,
+		Version:       ProtocolVersion,
+		Accepted:      reason == "",
+		Reason:        reason,
+		Compression:   compression.String(),
+	}
+	if reason != "" {
+		cm.refuse(conn, handshake.NodeID, reply, reason)
+		return
+	}
+	conn.setCompression(compression)
+	conn.setRemoteNode(data.Node{
+		ID:            handshake.NodeID,
+		ListenAddress: handshake.ListenAddress,
+	})
+	if authenticator := cm.getAuthenticator(); authenticator != nil {
+		cm.acceptAuthenticated(conn, authenticator, handshakes, state, handshake, reply)
+		return
+	}
+	// a conn to the node may have been registered since the reject reason was decided
+	if existing := cm.register(conn); existing != conn {
+		cm.refuse(conn, handshake.NodeID, reply, rejectDuplicate)
+		return
+	}
+	state.complete(true)
+	if err := conn.Send(data.Message{Type: data.HANDSHAKE_REPLY, Payload: reply}); err != nil {
+		log.Println(err)
+		_ = conn.disconnect()
+	}
+}
+
+// acceptAuthenticated proves the node ID of this node by signing the nonce of the handshake
+// and registers the conn once the dialing side proves its node ID in turn. A conn to the node
+// registered in the meantime wins, the dialing side then sees its conn closed.
+func (cm *ConnManager) acceptAuthenticated(conn Conn, authenticator Authenticator, handshakes chan data.Message, state *handshakeState, handshake data.Handshake, reply data.HandshakeReply) {
+	nodeID := handshake.NodeID
+	nonce, err := newNonce()
+	if err != nil {
+		log.Println(err)
+		_ = conn.disconnect()
+		return
+	}
+	if len(handshake.Nonce) != nonceSize {
+		cm.refuse(conn, nodeID, reply, rejectAuthentication)
+		return
+	}
+	reply.Signature, err = authenticator.SignChallenge(handshakeChallenge(cm.self.ID, nodeID, handshake.Nonce))
+	if err != nil {
+		log.Println(err)
+		_ = conn.disconnect()
+		return
+	}
+	reply.Nonce = nonce
+	if err := conn.Send(data.Message{Type: data.HANDSHAKE_REPLY, Payload: reply}); err != nil {
+		log.Println(err)
+		_ = conn.disconnect()
+		return
+	}
+	msg, err := awaitHandshake(conn, handshakes, data.HANDSHAKE_PROOF)
+	if err != nil {
+		log.Printf("handshake with %s failed: %v\n", conn.GetAddress(), err)
+		_ = conn.disconnect()
+		return
+	}
+	proof, ok := msg.Payload.(data.HandshakeProof)
+	if !ok {
+		log.Printf("msg %v not a handshake proof msg\n", msg.Payload)
+		_ = conn.disconnect()
+		return
+	}
+	if err := authenticator.VerifyChallenge(nodeID, handshakeChallenge(nodeID, cm.self.ID, nonce), proof.Signature); err != nil {
+		log.Printf("connection from node %s rejected: %s: %v\n", nodeID, rejectAuthentication, err)
+		_ = conn.disconnect()
+		return
+	}
+	if existing := cm.register(conn); existing != conn {
+		log.Printf("connection from node %s rejected: %s\n", nodeID, rejectDuplicate)
+		_ = conn.disconnect()
+		return
+	}
+	state.complete(true)
+}
+
+// refuse tells the dialing node why its conn is not accepted and closes the conn.
+func (cm *ConnManager) refuse(conn Conn, nodeID string, reply data.HandshakeReply, reason string) {
+	log.Printf("connection from node %s rejected: %s\n", nodeID, reason)
+	reply.Accepted = false
+	reply.Reason = reason
+	_ = conn.Send(data.Message{Type: data.HANDSHAKE_REPLY, Payload: reply})
+	_ = conn.disconnect()
 }
 
-func (cm *ConnManager) addConn(conn Conn) {
+// rejectReason returns why the connection initiated with the handshake
+// should not be accepted or an empty string if it should be.
+func (cm *ConnManager) rejectReason(handshake data.Handshake) string {
+	if handshake.Version != ProtocolVersion {
+		return rejectVersion
+	}
+	if handshake.NodeID == cm.self.ID {
+		return rejectSelf
+	}
+	cm.dropClosed(handshake.NodeID)
+	cm.lock.Lock()
+	_, connected := cm.conns[handshake.NodeID]
+	dialing := slices.Collect(maps.Keys(cm.dialing))
+	cm.lock.Unlock()
+	if connected {
+		return rejectDuplicate
+	}
+	// both nodes are dialing each other, the dial of the lower node ID wins
+	if cm.self.ID < handshake.NodeID && slices.ContainsFunc(dialing, func(address string) bool {
+		return sameAddress(address, handshake.ListenAddress)
+	}) {
+		return rejectDuplicate
+	}
+	return ""
+}
+
+// handshakeState tells whether the handshake of a conn is completed.
+type handshakeState struct {
+	established atomic.Bool
+	// done is closed once the handshake succeeds or fails
+	done chan struct{}
+	once sync.Once
+}
+
+func (s *handshakeState) complete(established bool) {
+	s.once.Do(func() {
+		s.established.Store(established)
+		close(s.done)
+	})
+}
+
+// watch starts consuming the messages received over the connection.
+// Handshake messages are returned over the channel, all other messages
+// are published to the subscribers once the handshake is completed.
+// The messages received while the handshake is in progress wait for it to complete.
+func (cm *ConnManager) watch(conn Conn) (chan data.Message, *handshakeState) {
+	handshakes := make(chan data.Message, 1)
+	state := &handshakeState{done: make(chan struct{})}
 	conn.onReceive(func(msg data.Message) {
-		log.Println("message received %v\n", msg)
-		cm.messages <- MsgReceived{Msg: msg, Sender: conn}
+		if msg.Type == data.HANDSHAKE || msg.Type == data.HANDSHAKE_REPLY || msg.Type == data.HANDSHAKE_PROOF {
+			select {
+			case handshakes <- msg:
+			default:
+				log.Printf("unexpected handshake msg from %s\n", conn.GetAddress())
+			}
+			return
+		}
+		if !state.established.Load() {
+			select {
+			case <-state.done:
+			case <-time.After(handshakeTimeout):
+			}
+		}
+		if !state.established.Load() {
+			log.Printf("msg from %s dropped, handshake not completed\n", conn.GetAddress())
+			return
+		}
+		cm.messages.Publish(MsgReceived{Msg: msg, Sender: conn})
+	})
+	conn.onMalformedMsg(func(err error) {
+		if state.established.Load() {
+			cm.malformed.Publish(MalformedMsg{Sender: conn, Err: err})
+		}
 	})
-	cm.conns = append(cm.conns, conn)
-	log.Printf("connection added %s\n", conn.GetAddress())
+	conn.onDisconnect(func() {
+		// the conn down follows the msgs received over the conn
+		if received := receivedAll(conn); received != nil {
+			<-received
+		}
+		cm.unregister(conn)
+	})
+	return handshakes, state
+}
+
+// awaitHandshake waits for the handshake msg of the type, it gives up once the conn
+// closed without it instead of waiting for the timeout.
+func awaitHandshake(conn Conn, handshakes chan data.Message, msgType data.MessageType) (data.Message, error) {
+	var msg data.Message
+	select {
+	case msg = <-handshakes:
+	case <-receivedAll(conn):
+		// the msg may be the last one handled before the conn closed
+		select {
+		case msg = <-handshakes:
+		default:
+			return data.Message{}, ErrConnClosed
+		}
+	case <-time.After(handshakeTimeout):
+		return data.Message{}, errors.New("handshake timed out")
+	}
+	if msg.Type != msgType {
+		return data.Message{}, fmt.Errorf("unexpected handshake msg type %s", msg.Type)
+	}
+	return msg, nil
+}
+
+// awaitConn waits for the winning connection to the node
+// after our own connection attempt lost the tie-break.
+func (cm *ConnManager) awaitConn(nodeID string) (Conn, error) {
+	timeout := time.After(handshakeTimeout)
+	for {
+		cm.lock.Lock()
+		conn, ok := cm.conns[nodeID]
+		changed := cm.connsChanged
+		cm.lock.Unlock()
+		if ok {
+			return conn, nil
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			return nil, ErrDuplicateConn
+		}
+	}
+}
+
+// register adds the connection unless a connection to the same node already exists,
+// it returns the connection that remains registered.
+func (cm *ConnManager) register(conn Conn) Conn {
+	cm.dropClosed(conn.GetRemoteNode().ID)
+	cm.lock.Lock()
+	nodeID := conn.GetRemoteNode().ID
+	if existing, ok := cm.conns[nodeID]; ok {
+		cm.lock.Unlock()
+		return existing
+	}
+	cm.conns[nodeID] = conn
+	close(cm.connsChanged)
+	cm.connsChanged = make(chan struct{})
+	cm.lock.Unlock()
+	log.Printf("connection added %s [ID=%s]\n", conn.GetAddress(), nodeID)
+	return conn
+}
+
+func (cm *ConnManager) unregister(conn Conn) {
+	cm.lock.Lock()
+	nodeID := conn.GetRemoteNode().ID
+	registered, ok := cm.conns[nodeID]
+	if !ok || registered != conn {
+		cm.lock.Unlock()
+		return
+	}
+	delete(cm.conns, nodeID)
+	delete(cm.idle, nodeID)
+	_, linked := cm.links[nodeID]
+	delete(cm.links, nodeID)
+	cm.lock.Unlock()
+	log.Printf("connection removed %s [ID=%s]\n", conn.GetAddress(), nodeID)
+	if linked {
+		cm.connDown.Publish(conn)
+	}
+}
+
+// dropClosed unregisters the conn to the node if it closed before it was unregistered,
+// so it is not taken for a duplicate of the new conn to the node.
+func (cm *ConnManager) dropClosed(nodeID string) {
+	cm.lock.Lock()
+	conn, ok := cm.conns[nodeID]
+	cm.lock.Unlock()
+	if ok && isClosed(conn) {
+		cm.unregister(conn)
+	}
+}
+
+func (cm *ConnManager) getConnByListenAddress(address string) Conn {
+	for _, conn := range cm.conns {
+		if conn.GetRemoteNode().ListenAddress == address && !isClosed(conn) {
+			return conn
+		}
+	}
+	return nil
+}
+
+func newNonce() ([]byte, error) {
+	nonce := make([]byte, nonceSize)
+	_, err := rand.Read(nonce)
+	return nonce, err
+}
+
+// handshakeChallenge returns what the signer signs to prove its node ID to the node it talks to.
+func handshakeChallenge(signer, verifier string, nonce []byte) []byte {
+	challenge := make([]byte, 0, len(signer)+len(verifier)+len(nonce)+2)
+	challenge = append(challenge, signer...)
+	challenge = append(challenge, 0)
+	challenge = append(challenge, verifier...)
+	challenge = append(challenge, 0)
+	return append(challenge, nonce...)
+}
+
+// sameAddress reports whether the addresses name the same listener,
+// e.g. localhost:7000 and 127.0.0.1:7000. The hosts are only resolved
+// if the addresses differ in them alone.
+func sameAddress(a, b string) bool {
+	if a == b {
+		return true
+	}
+	hostA, portA, errA := net.SplitHostPort(a)
+	hostB, portB, errB := net.SplitHostPort(b)
+	if errA != nil || errB != nil || portA != portB {
+		return false
+	}
+	ipsB := resolve(hostB)
+	for _, ip := range resolve(hostA) {
+		if slices.ContainsFunc(ipsB, ip.Equal) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve returns the IPs of the host, nil if it can not be resolved in time.
+func resolve(host string) []net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+	return ips
 }
 
 type MsgReceived struct {
 	Msg    data.Message
 	Sender Conn
 }
+
+type MalformedMsg struct {
+	Sender Conn
+	Err    error
+}
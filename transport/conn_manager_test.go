@@ -0,0 +1,330 @@
+package transport
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tamararankovic/hyparview/data"
+	"github.com/tamararankovic/hyparview/identity"
+)
+
+func newMemConnManager(t *testing.T, network *MemNetwork, id, address string) *ConnManager {
+	t.Helper()
+	cm := NewConnManager(data.Node{ID: id, ListenAddress: address}, network.NewConnFn(address), network.AcceptConnsFn(address))
+	if err := cm.StartAcceptingConns(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cm.Close)
+	return cm
+}
+
+// registered returns the conns of the conn manager indexed by the remote node ID.
+func registered(cm *ConnManager) map[string]Conn {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	conns := make(map[string]Conn, len(cm.conns))
+	for id, conn := range cm.conns {
+		conns[id] = conn
+	}
+	return conns
+}
+
+func openPipes(network *MemNetwork) int {
+	network.lock.Lock()
+	defer network.lock.Unlock()
+	return len(network.pipes)
+}
+
+func awaitPipes(t *testing.T, network *MemNetwork, want int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for openPipes(network) != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d conns open, want %d", openPipes(network), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	network := NewMemNetwork(DefaultConnConfig())
+	a := newMemConnManager(t, network, "a", "a-addr")
+	b := newMemConnManager(t, network, "b", "b-addr")
+	conn, err := a.Connect("b-addr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remote := conn.GetRemoteNode(); remote.ID != "b" || remote.ListenAddress != "b-addr" {
+		t.Errorf("remote node %+v, want b listening on b-addr", remote)
+	}
+	deadline := time.Now().Add(time.Second)
+	for registered(b)["a"] == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("accepted conn not registered under the ID of the dialing node")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if remote := registered(b)["a"].GetRemoteNode(); remote.ListenAddress != "a-addr" {
+		t.Errorf("accepted conn reports listen address %s, want a-addr", remote.ListenAddress)
+	}
+	again, err := a.ConnectEphemeral("b-addr")
+	if err != nil || again != conn {
+		t.Errorf("second connect returned %v, %v, want the existing conn", again, err)
+	}
+	reverse, err := b.Connect("a-addr")
+	if err != nil || reverse != registered(b)["a"] {
+		t.Errorf("connect from the accepting side returned %v, %v, want the accepted conn", reverse, err)
+	}
+	awaitPipes(t, network, 1)
+}
+
+func TestHandshakeRejectsSelf(t *testing.T) {
+	network := NewMemNetwork(DefaultConnConfig())
+	a := newMemConnManager(t, network, "a", "a-addr")
+	newMemConnManager(t, network, "a", "other-addr")
+	if _, err := a.Connect("other-addr"); err == nil {
+		t.Fatal("conn to a node with the same ID accepted")
+	}
+	awaitPipes(t, network, 0)
+}
+
+func TestDuplicateConnsRejected(t *testing.T) {
+	network := NewMemNetwork(DefaultConnConfig())
+	b := newMemConnManager(t, network, "b", "b-addr")
+	// the dialers claim the same node ID, the handshakes race for the registration at b
+	const dialers = 4
+	var wg sync.WaitGroup
+	conns := make(chan Conn, dialers)
+	for i := 0; i < dialers; i++ {
+		dialer := newMemConnManager(t, network, "a", "a-addr-"+string(rune('0'+i)))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if conn, err := dialer.Connect("b-addr"); err == nil {
+				conns <- conn
+			}
+		}()
+	}
+	wg.Wait()
+	close(conns)
+	if accepted := len(conns); accepted != 1 {
+		t.Fatalf("%d dials accepted, want 1", accepted)
+	}
+	if len(registered(b)) != 1 {
+		t.Errorf("b holds %d conns, want 1", len(registered(b)))
+	}
+	awaitPipes(t, network, 1)
+}
+
+func TestSimultaneousDialKeepsOneConn(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		network := NewMemNetwork(DefaultConnConfig())
+		a := newMemConnManager(t, network, "a", "a-addr")
+		b := newMemConnManager(t, network, "b", "b-addr")
+		var wg sync.WaitGroup
+		wg.Add(2)
+		var fromA, fromB Conn
+		var errA, errB error
+		go func() {
+			defer wg.Done()
+			fromA, errA = a.Connect("b-addr")
+		}()
+		go func() {
+			defer wg.Done()
+			fromB, errB = b.Connect("a-addr")
+		}()
+		wg.Wait()
+		if errA != nil || errB != nil {
+			t.Fatalf("simultaneous dials failed: %v, %v", errA, errB)
+		}
+		if registered(a)["b"] != fromA || registered(b)["a"] != fromB {
+			t.Fatal("dials returned conns other than the registered ones")
+		}
+		awaitPipes(t, network, 1)
+	}
+}
+
+func TestDialFailsOnceConnCloses(t *testing.T) {
+	network := NewMemNetwork(DefaultConnConfig())
+	a := newMemConnManager(t, network, "a", "a-addr")
+	// the listener closes the conns without replying to the handshake
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	err := network.AcceptConnsFn("b-addr")(stop, func(conn Conn) {
+		_ = conn.disconnect()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	_, err = a.Connect("b-addr")
+	if !errors.Is(err, ErrConnClosed) {
+		t.Fatalf("dial error %v, want %v", err, ErrConnClosed)
+	}
+	if elapsed := time.Since(start); elapsed >= handshakeTimeout {
+		t.Errorf("dial failed after %s, want before the handshake timeout", elapsed)
+	}
+}
+
+func TestClosedConnNotTakenForDuplicate(t *testing.T) {
+	network := NewMemNetwork(DefaultConnConfig())
+	a := newMemConnManager(t, network, "a", "a-addr")
+	b := newMemConnManager(t, network, "b", "b-addr")
+	if _, err := a.Connect("b-addr"); err != nil {
+		t.Fatal(err)
+	}
+	// the conn closes at a while it is still registered
+	closed := registered(a)["b"]
+	_ = closed.disconnect()
+	deadline := time.Now().Add(time.Second)
+	for registered(a)["b"] != nil || registered(b)["a"] != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the closed conn is not unregistered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	a.lock.Lock()
+	a.conns["b"] = closed
+	a.lock.Unlock()
+	conn, err := b.Connect("a-addr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registered(b)["a"] != conn {
+		t.Fatal("dial returned a conn other than the registered one")
+	}
+	if registered(a)["b"] == closed {
+		t.Error("the closed conn is still registered")
+	}
+}
+
+func TestBlockingConnsRefused(t *testing.T) {
+	config := DefaultConnConfig()
+	config.OverflowPolicy = OverflowBlock
+	network := NewMemNetwork(config)
+	a := newMemConnManager(t, network, "a", "a-addr")
+	newMemConnManager(t, network, "b", "b-addr")
+	if _, err := a.Connect("b-addr"); !errors.Is(err, ErrBlockingSends) {
+		t.Fatalf("dial error %v, want %v", err, ErrBlockingSends)
+	}
+	awaitPipes(t, network, 0)
+}
+
+func TestTieBreakMatchesAddressNames(t *testing.T) {
+	if !sameAddress("localhost:7000", "127.0.0.1:7000") {
+		t.Error("localhost:7000 and 127.0.0.1:7000 do not match")
+	}
+	if sameAddress("localhost:7000", "127.0.0.1:7001") || sameAddress("node-1", "node-2") {
+		t.Error("different listeners match")
+	}
+	cm := NewConnManager(data.Node{ID: "b", ListenAddress: "127.0.0.1:7001"}, nil, nil)
+	cm.dialing["localhost:7000"] = struct{}{}
+	for _, tc := range []struct {
+		nodeID string
+		want   string
+	}{
+		{"c", rejectDuplicate},
+		{"a", ""},
+	} {
+		reason := cm.rejectReason(data.Handshake{NodeID: tc.nodeID, ListenAddress: "127.0.0.1:7000", Version: ProtocolVersion})
+		if reason != tc.want {
+			t.Errorf("node %s dialing while dialed: reject reason %q, want %q", tc.nodeID, reason, tc.want)
+		}
+	}
+}
+
+func newAuthenticatedConnManager(t *testing.T, network *MemNetwork, id identity.Identity, address string) *ConnManager {
+	t.Helper()
+	cm := NewConnManager(data.Node{ID: id.NodeID(), ListenAddress: address}, network.NewConnFn(address), network.AcceptConnsFn(address))
+	cm.SetAuthenticator(id)
+	if err := cm.StartAcceptingConns(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cm.Close)
+	return cm
+}
+
+func generateIdentity(t *testing.T) identity.Identity {
+	t.Helper()
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestAuthenticatedHandshake(t *testing.T) {
+	network := NewMemNetwork(DefaultConnConfig())
+	idA, idB := generateIdentity(t), generateIdentity(t)
+	a := newAuthenticatedConnManager(t, network, idA, "a-addr")
+	b := newAuthenticatedConnManager(t, network, idB, "b-addr")
+	received := make(chan data.Message, 1)
+	_ = b.OnReceive(func(msg MsgReceived) {
+		received <- msg.Msg
+	})
+	conn, err := a.Connect("b-addr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.GetRemoteNode().ID != idB.NodeID() {
+		t.Errorf("remote node %s, want %s", conn.GetRemoteNode().ID, idB.NodeID())
+	}
+	// sent right after the handshake, delivered once the accepting side verified the proof
+	if err := conn.Send(data.Message{Type: data.DISCONNECT, Payload: data.Disconnect{NodeID: idA.NodeID()}}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		if msg.Type != data.DISCONNECT {
+			t.Errorf("received %s, want %s", msg.Type, data.DISCONNECT)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("msg sent right after the handshake not received")
+	}
+	if registered(b)[idA.NodeID()] == nil {
+		t.Error("authenticated conn not registered at the accepting side")
+	}
+}
+
+func TestSpoofedNodeIDRejected(t *testing.T) {
+	network := NewMemNetwork(DefaultConnConfig())
+	idA, idB, idM := generateIdentity(t), generateIdentity(t), generateIdentity(t)
+	b := newAuthenticatedConnManager(t, network, idB, "b-addr")
+	// m claims the node ID of a but can only sign with its own key
+	m := NewConnManager(data.Node{ID: idA.NodeID(), ListenAddress: "m-addr"}, network.NewConnFn("m-addr"), network.AcceptConnsFn("m-addr"))
+	m.SetAuthenticator(idM)
+	if err := m.StartAcceptingConns(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Close)
+	_, _ = m.Connect("b-addr")
+	awaitPipes(t, network, 0)
+	if registered(b)[idA.NodeID()] != nil {
+		t.Error("conn with a spoofed node ID registered")
+	}
+	// b dialing the spoofing node does not trust its claimed ID either
+	if _, err := b.Connect("m-addr"); err == nil {
+		t.Error("conn to a node with a spoofed node ID opened")
+	}
+	awaitPipes(t, network, 0)
+}
+
+func TestUnauthenticatedDialRejected(t *testing.T) {
+	network := NewMemNetwork(DefaultConnConfig())
+	b := newAuthenticatedConnManager(t, network, generateIdentity(t), "b-addr")
+	a := newMemConnManager(t, network, "a", "a-addr")
+	// the dialing side does not prove its node ID, the accepting side gives up waiting for the proof
+	_, _ = a.Connect("b-addr")
+	deadline := time.Now().Add(2 * handshakeTimeout)
+	for openPipes(network) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("unauthenticated conn not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(registered(b)) != 0 {
+		t.Error("unauthenticated conn registered")
+	}
+}
@@ -0,0 +1,158 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// MemNetwork connects the nodes of a single process through in-memory pipes.
+// The addresses are arbitrary names. The network can be partitioned
+// to simulate link failures between groups of nodes.
+type MemNetwork struct {
+	config ConnConfig
+	// listeners holds the accept handlers indexed by the listen address
+	listeners map[string]func(conn Conn)
+	// groups holds the partition group of the addresses,
+	// the addresses not assigned to a group are in group 0
+	groups map[string]int
+	pipes  map[*memPipe]struct{}
+	lock   sync.Mutex
+}
+
+// memPipe is an established connection between the dialer and the listener address.
+type memPipe struct {
+	from, to string
+	ends     [2]net.Conn
+}
+
+// memAddr is the net.Addr of a pipe end.
+type memAddr string
+
+func (a memAddr) Network() string { return "mem" }
+func (a memAddr) String() string  { return string(a) }
+
+// memConn reports the address of the other end instead of the one of net.Pipe
+// and forgets the pipe once it is closed.
+type memConn struct {
+	net.Conn
+	remote  memAddr
+	network *MemNetwork
+	pipe    *memPipe
+}
+
+func (c memConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c memConn) Close() error {
+	c.network.closePipe(c.pipe)
+	return nil
+}
+
+func NewMemNetwork(config ConnConfig) *MemNetwork {
+	return &MemNetwork{
+		config:    config,
+		listeners: make(map[string]func(conn Conn)),
+		groups:    make(map[string]int),
+		pipes:     make(map[*memPipe]struct{}),
+	}
+}
+
+// NewConnFn returns the dial fn of the node listening on the local address,
+// the local address is used to decide which partition the node is in.
+func (n *MemNetwork) NewConnFn(localAddress string) func(address string) (Conn, error) {
+	return func(address string) (Conn, error) {
+		n.lock.Lock()
+		handler, ok := n.listeners[address]
+		if !ok || n.groups[localAddress] != n.groups[address] {
+			n.lock.Unlock()
+			return nil, fmt.Errorf("dial mem %s: connection refused", address)
+		}
+		dialerEnd, listenerEnd := net.Pipe()
+		pipe := &memPipe{from: localAddress, to: address, ends: [2]net.Conn{dialerEnd, listenerEnd}}
+		n.pipes[pipe] = struct{}{}
+		n.lock.Unlock()
+
+		accepted, err := MakeTCPConn(memConn{Conn: listenerEnd, remote: memAddr(localAddress), network: n, pipe: pipe}, n.config)
+		if err != nil {
+			n.closePipe(pipe)
+			return nil, err
+		}
+		go handler(accepted)
+		return MakeTCPConn(memConn{Conn: dialerEnd, remote: memAddr(address), network: n, pipe: pipe}, n.config)
+	}
+}
+
+// AcceptConnsFn returns the accept fn of the node listening on the address.
+func (n *MemNetwork) AcceptConnsFn(address string) func(stopCh chan struct{}, handler func(conn Conn)) error {
+	return func(stopCh chan struct{}, handler func(conn Conn)) error {
+		n.lock.Lock()
+		defer n.lock.Unlock()
+		if _, ok := n.listeners[address]; ok {
+			return fmt.Errorf("listen mem %s: address already in use", address)
+		}
+		n.listeners[address] = handler
+		go func() {
+			<-stopCh
+			n.lock.Lock()
+			delete(n.listeners, address)
+			n.lock.Unlock()
+		}()
+		return nil
+	}
+}
+
+// Partition splits the network into the groups of addresses, the nodes can only
+// reach the ones in their group. The addresses not listed form a group of their own.
+// The conns crossing the groups are closed.
+func (n *MemNetwork) Partition(groups ...[]string) {
+	n.lock.Lock()
+	n.groups = make(map[string]int)
+	for i, group := range groups {
+		for _, address := range group {
+			n.groups[address] = i + 1
+		}
+	}
+	cut := make([]*memPipe, 0)
+	for pipe := range n.pipes {
+		if n.groups[pipe.from] != n.groups[pipe.to] {
+			cut = append(cut, pipe)
+		}
+	}
+	n.lock.Unlock()
+	for _, pipe := range cut {
+		n.closePipe(pipe)
+	}
+}
+
+// Heal removes the partitions.
+func (n *MemNetwork) Heal() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.groups = make(map[string]int)
+}
+
+// Isolate closes all the conns of the address, as if the node crashed.
+func (n *MemNetwork) Isolate(address string) {
+	n.lock.Lock()
+	cut := make([]*memPipe, 0)
+	for pipe := range n.pipes {
+		if pipe.from == address || pipe.to == address {
+			cut = append(cut, pipe)
+		}
+	}
+	n.lock.Unlock()
+	for _, pipe := range cut {
+		n.closePipe(pipe)
+	}
+}
+
+func (n *MemNetwork) closePipe(pipe *memPipe) {
+	n.lock.Lock()
+	delete(n.pipes, pipe)
+	n.lock.Unlock()
+	for _, end := range pipe.ends {
+		_ = end.Close()
+	}
+}
@@ -0,0 +1,35 @@
+package transport
+
+import "testing"
+
+func TestMemNetworkPartition(t *testing.T) {
+	network := NewMemNetwork(DefaultConnConfig())
+	stopCh := make(chan struct{})
+	accepted := make(chan Conn, 4)
+	for _, address := range []string{"a", "b"} {
+		if err := network.AcceptConnsFn(address)(stopCh, func(conn Conn) { accepted <- conn }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dial := network.NewConnFn("a")
+	if _, err := dial("b"); err != nil {
+		t.Fatalf("dial before the partition: %v", err)
+	}
+	if conn := <-accepted; conn.GetAddress() != "a" {
+		t.Errorf("accepted conn address %s, want a", conn.GetAddress())
+	}
+
+	network.Partition([]string{"a"}, []string{"b"})
+	if _, err := dial("b"); err == nil {
+		t.Error("dial across the partition succeeded")
+	}
+	network.Heal()
+	if _, err := dial("b"); err != nil {
+		t.Errorf("dial after healing: %v", err)
+	}
+
+	close(stopCh)
+	if _, err := dial("c"); err == nil {
+		t.Error("dial of an address nobody listens on succeeded")
+	}
+}
@@ -2,102 +2,273 @@ package transport
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/tamararankovic/hyparview/data"
 )
 
+// maxFrameSize bounds the payload size a frame header may announce,
+// the payload buffer is allocated before a byte of it is read.
+const maxFrameSize = maxDecompressedSize
+
 type TCPConn struct {
 	address      string
+	remote       data.Node
 	conn         net.Conn
+	config       ConnConfig
+	compression  Compression
 	msgCh        chan data.Message
+	sendQueue    chan []byte
+	closeCh      chan struct{}
+	writerDone   chan struct{}
 	disconnectCh chan struct{}
+	// receivedCh is closed once the receive handler has handled the last msg
+	receivedCh   chan struct{}
+	closeOnce    sync.Once
+	disconnected sync.Once
+	received     sync.Once
+	malformedFn  func(err error)
+	lock         sync.Mutex
 }
 
 func NewTCPConn(address string) (Conn, error) {
-	conn, err := net.Dial("tcp", address)
-	if err != nil {
-		return nil, err
+	return NewTCPConnFn(DefaultConnConfig())(address)
+}
+
+func NewTCPConnFn(config ConnConfig) func(address string) (Conn, error) {
+	return func(address string) (Conn, error) {
+		conn, err := net.DialTimeout("tcp", address, handshakeTimeout)
+		if err != nil {
+			return nil, err
+		}
+		return MakeTCPConn(conn, config)
 	}
-	return MakeTCPConn(conn)
 }
 
-func MakeTCPConn(conn net.Conn) (Conn, error) {
-	tcpConn := TCPConn{
+func MakeTCPConn(conn net.Conn, config ConnConfig) (Conn, error) {
+	if config.SendQueueSize <= 0 {
+		return nil, fmt.Errorf("invalid send queue size %d", config.SendQueueSize)
+	}
+	tcpConn := &TCPConn{
 		address:      conn.RemoteAddr().String(),
 		conn:         conn,
+		config:       config,
 		msgCh:        make(chan data.Message),
+		sendQueue:    make(chan []byte, config.SendQueueSize),
+		closeCh:      make(chan struct{}),
+		writerDone:   make(chan struct{}),
 		disconnectCh: make(chan struct{}),
+		receivedCh:   make(chan struct{}),
 	}
 	tcpConn.read()
+	tcpConn.write()
 	return tcpConn, nil
 }
 
-func (t TCPConn) GetAddress() string {
+func (t *TCPConn) GetAddress() string {
 	return t.address
 }
 
-func (t TCPConn) Send(msg data.Message) error {
+func (t *TCPConn) GetRemoteNode() data.Node {
+	return t.remote
+}
+
+func (t *TCPConn) GetQueueDepth() int {
+	return len(t.sendQueue)
+}
+
+// Send enqueues the message to be written by the writer goroutine,
+// a full queue is handled according to the overflow policy.
+func (t *TCPConn) Send(msg data.Message) error {
 	payload, err := serialize(msg)
 	if err != nil {
 		return err
 	}
-	payloadSize := make([]byte, 4)
-	binary.LittleEndian.PutUint32(payloadSize, uint32(len(payload)))
-	msgSerialized := append(payloadSize, payload...)
-	_, err = t.conn.Write(msgSerialized)
-	if t.isClosed(err) {
-		t.disconnectCh <- struct{}{}
+	if len(payload) > maxFrameSize {
+		return fmt.Errorf("%w: msg of %d bytes", ErrFrameTooLarge, len(payload))
 	}
-	return err
+	return t.sendSerialized(payload)
 }
 
-func (t TCPConn) disconnect() error {
-	err := t.conn.Close()
-	if err != nil {
-		return err
+// sendSerialized enqueues the already serialized msg,
+// it is framed and compressed by the writer goroutine.
+func (t *TCPConn) sendSerialized(msgSerialized []byte) error {
+	select {
+	case <-t.closeCh:
+		return ErrConnClosed
+	case <-t.disconnectCh:
+		return ErrConnClosed
+	default:
 	}
-	t.disconnectCh <- struct{}{}
+	if t.config.OverflowPolicy == OverflowBlock {
+		select {
+		case t.sendQueue <- msgSerialized:
+			return nil
+		case <-t.closeCh:
+			return ErrConnClosed
+		case <-t.disconnectCh:
+			return ErrConnClosed
+		}
+	}
+	select {
+	case t.sendQueue <- msgSerialized:
+		return nil
+	default:
+	}
+	if t.config.OverflowPolicy == OverflowDisconnect {
+		t.handleError(fmt.Errorf("%w, disconnecting %s", ErrSendQueueFull, t.address))
+	}
+	return ErrSendQueueFull
+}
+
+// disconnect flushes the queued messages and closes the connection.
+func (t *TCPConn) disconnect() error {
+	t.closeOnce.Do(func() {
+		close(t.closeCh)
+	})
+	<-t.writerDone
 	return nil
 }
 
-func (t TCPConn) onDisconnect(handler func()) {
+// halfClose shuts down the writing side of the connection,
+// the remote node reads EOF while this side can still receive.
+func (t *TCPConn) halfClose() error {
+	writeCloser, ok := t.conn.(interface{ CloseWrite() error })
+	if !ok {
+		return fmt.Errorf("%s does not support half-closing", t.conn.RemoteAddr().Network())
+	}
+	return writeCloser.CloseWrite()
+}
+
+func (t *TCPConn) overflowPolicy() OverflowPolicy {
+	return t.config.OverflowPolicy
+}
+
+func (t *TCPConn) isClosed() bool {
+	select {
+	case <-t.disconnectCh:
+		return true
+	default:
+		return false
+	}
+}
+
+func (t *TCPConn) onDisconnect(handler func()) {
 	go func() {
-		for range t.disconnectCh {
-			handler()
-		}
+		<-t.disconnectCh
+		handler()
 	}()
 }
 
-func (t TCPConn) onReceive(handler func(msg data.Message)) {
+func (t *TCPConn) onReceive(handler func(msg data.Message)) {
 	go func() {
 		for msg := range t.msgCh {
 			handler(msg)
 		}
+		t.received.Do(func() {
+			close(t.receivedCh)
+		})
 	}()
 }
 
-func (t TCPConn) read() {
+func (t *TCPConn) receivedAll() <-chan struct{} {
+	return t.receivedCh
+}
+
+func (t *TCPConn) onMalformedMsg(handler func(err error)) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.malformedFn = handler
+}
+
+func (t *TCPConn) reportMalformed(err error) {
+	log.Println(err)
+	t.lock.Lock()
+	handler := t.malformedFn
+	t.lock.Unlock()
+	if handler != nil {
+		handler(err)
+	}
+}
+
+func (t *TCPConn) setRemoteNode(node data.Node) {
+	t.remote = node
+}
+
+func (t *TCPConn) supportedCompressions() []Compression {
+	return t.config.Compression
+}
+
+// setCompression may be called while the handshake msgs are being written.
+func (t *TCPConn) setCompression(compression Compression) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.compression = compression
+}
+
+// compress compresses the serialized msg with the negotiated codec
+// if it exceeds the compression threshold.
+func (t *TCPConn) compress(msgSerialized []byte) ([]byte, error) {
+	t.lock.Lock()
+	compression := t.compression
+	t.lock.Unlock()
+	if compression == CompressionNone || len(msgSerialized) < t.config.CompressionThreshold {
+		return msgSerialized, nil
+	}
+	return compress(compression, msgSerialized)
+}
+
+func (t *TCPConn) read() {
 	go func() {
+		defer close(t.msgCh)
 		header := make([]byte, 4)
 		for {
-			_, err := t.conn.Read(header)
+			if t.config.ReadTimeout > 0 {
+				_ = t.conn.SetReadDeadline(time.Now().Add(t.config.ReadTimeout))
+			}
+			_, err := io.ReadFull(t.conn, header)
 			if err != nil {
 				t.handleError(err)
 				break
 			}
 			payloadSize := binary.LittleEndian.Uint32(header)
+			if payloadSize > maxFrameSize {
+				t.reportMalformed(fmt.Errorf("%w: header of %s announces %d bytes", ErrFrameTooLarge, t.address, payloadSize))
+				t.signalDisconnect()
+				break
+			}
 			payload := make([]byte, payloadSize)
-			_, err = t.conn.Read(payload)
+			_, err = io.ReadFull(t.conn, payload)
 			if err != nil {
 				t.handleError(err)
 				break
 			}
+			if isCompressed(payload) {
+				payload, err = decompress(payload)
+				if err != nil {
+					t.reportMalformed(err)
+					continue
+				}
+			}
+			if isBatch(payload) {
+				msgs, err := deserializeBatch(payload)
+				if err != nil {
+					t.reportMalformed(err)
+				}
+				for _, msg := range msgs {
+					t.msgCh <- msg
+				}
+				continue
+			}
 			msg, err := deserialize(payload)
 			if err != nil {
-				log.Println(err)
+				t.reportMalformed(err)
 				continue
 			}
 			t.msgCh <- msg
@@ -105,39 +276,168 @@ func (t TCPConn) read() {
 	}()
 }
 
-func (t TCPConn) handleError(err error) {
+func (t *TCPConn) write() {
+	go func() {
+		defer close(t.writerDone)
+		for {
+			select {
+			case msgSerialized := <-t.sendQueue:
+				err := t.writeMsg(t.encode(t.collectBatch(msgSerialized)))
+				if err != nil {
+					t.handleError(err)
+					return
+				}
+			case <-t.closeCh:
+				t.flush()
+				t.signalDisconnect()
+				return
+			case <-t.disconnectCh:
+				return
+			}
+		}
+	}()
+}
+
+func (t *TCPConn) flush() {
+	for {
+		select {
+		case msgSerialized := <-t.sendQueue:
+			err := t.writeMsg(t.encode([][]byte{msgSerialized}))
+			if err != nil {
+				log.Println(err)
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// collectBatch coalesces the msg with the msgs queued within the batch latency,
+// it returns the msg alone if batching is disabled or nothing else was queued.
+func (t *TCPConn) collectBatch(msgSerialized []byte) [][]byte {
+	if t.config.BatchSize < 2 {
+		return [][]byte{msgSerialized}
+	}
+	msgsSerialized := [][]byte{msgSerialized}
+	var timeout <-chan time.Time
+	if t.config.BatchLatency > 0 {
+		timer := time.NewTimer(t.config.BatchLatency)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+collect:
+	for len(msgsSerialized) < t.config.BatchSize {
+		if timeout == nil {
+			select {
+			case next := <-t.sendQueue:
+				msgsSerialized = append(msgsSerialized, next)
+			default:
+				break collect
+			}
+			continue
+		}
+		select {
+		case next := <-t.sendQueue:
+			msgsSerialized = append(msgsSerialized, next)
+		case <-timeout:
+			break collect
+		case <-t.closeCh:
+			break collect
+		}
+	}
+	return msgsSerialized
+}
+
+// encode frames the msgs for the wire, several msgs are sent as a batch.
+// Only the outer frame is compressed, the batched msgs are not compressed one by one.
+// A batch over the max frame size is written as consecutive frames instead.
+func (t *TCPConn) encode(msgsSerialized [][]byte) []byte {
+	payload := msgsSerialized[0]
+	if len(msgsSerialized) > 1 {
+		entries := make([][]byte, len(msgsSerialized))
+		for i, msgSerialized := range msgsSerialized {
+			entries[i] = frame(msgSerialized)
+		}
+		payload = serializeBatch(entries)
+		if len(payload) > maxFrameSize {
+			frames := make([]byte, 0, len(payload))
+			for _, msgSerialized := range msgsSerialized {
+				frames = append(frames, t.encode([][]byte{msgSerialized})...)
+			}
+			return frames
+		}
+	}
+	compressed, err := t.compress(payload)
+	if err != nil {
+		log.Println(err)
+		return frame(payload)
+	}
+	return frame(compressed)
+}
+
+func (t *TCPConn) writeMsg(msgSerialized []byte) error {
+	if t.config.WriteTimeout > 0 {
+		_ = t.conn.SetWriteDeadline(time.Now().Add(t.config.WriteTimeout))
+	}
+	_, err := t.conn.Write(msgSerialized)
+	return err
+}
+
+func frame(payload []byte) []byte {
+	msgSerialized := make([]byte, 4, 4+len(payload))
+	binary.LittleEndian.PutUint32(msgSerialized, uint32(len(payload)))
+	return append(msgSerialized, payload...)
+}
+
+func (t *TCPConn) handleError(err error) {
 	if err == nil {
 		return
 	}
-	log.Println(err)
-	t.disconnectCh <- struct{}{}
+	if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		log.Println(err)
+	}
+	t.signalDisconnect()
+}
+
+func (t *TCPConn) signalDisconnect() {
+	t.disconnected.Do(func() {
+		_ = t.conn.Close()
+		close(t.disconnectCh)
+	})
 }
 
-func (t TCPConn) isClosed(err error) bool {
-	return strings.Contains(err.Error(), "use of closed network connection") ||
-		strings.Contains(err.Error(), "broken pipe") ||
-		strings.Contains(err.Error(), "connection reset by peer") ||
-		strings.Contains(err.Error(), "EOF")
+func AcceptTcpConnsFn(address string, config ConnConfig) func(stopCh chan struct{}, handler func(conn Conn)) error {
+	return acceptConnsFn("tcp", address, config)
 }
 
-func AcceptTcpConnsFn(address string) func(stopCh chan struct{}, handler func(conn Conn)) error {
+// acceptConnsFn listens on the address of the stream network and wraps
+// every accepted connection, the listener is closed once stopCh is closed.
+func acceptConnsFn(network, address string, config ConnConfig) func(stopCh chan struct{}, handler func(conn Conn)) error {
 	return func(stopCh chan struct{}, handler func(conn Conn)) error {
-		listener, err := net.Listen("tcp", address)
+		listener, err := net.Listen(network, address)
 		if err != nil {
 			return err
 		}
-		// defer listener.Close()
 		log.Printf("Server listening on %s\n", address)
 
+		go func() {
+			<-stopCh
+			_ = listener.Close()
+		}()
 		go func() {
 			for {
 				conn, err := listener.Accept()
+				if errors.Is(err, net.ErrClosed) {
+					log.Printf("Server on %s stopped\n", address)
+					return
+				}
 				if err != nil {
 					log.Println("Connection error:", err)
 					continue
 				}
-				log.Printf("new TCP connection %s\n", conn.RemoteAddr().String())
-				tcpConn, err := MakeTCPConn(conn)
+				log.Printf("new %s connection %s\n", network, conn.RemoteAddr().String())
+				tcpConn, err := MakeTCPConn(conn, config)
 				if err != nil {
 					log.Println(err)
 					continue
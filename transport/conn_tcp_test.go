@@ -0,0 +1,255 @@
+package transport
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tamararankovic/hyparview/data"
+)
+
+func connPair(b testing.TB, config ConnConfig) (Conn, Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan Conn)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			b.Error(err)
+			close(accepted)
+			return
+		}
+		server, err := MakeTCPConn(conn, config)
+		if err != nil {
+			b.Error(err)
+		}
+		accepted <- server
+	}()
+	client, err := NewTCPConnFn(config)(listener.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	server := <-accepted
+	if server == nil {
+		b.FailNow()
+	}
+	return client, server
+}
+
+func benchmarkSend(b *testing.B, config ConnConfig) {
+	config.OverflowPolicy = OverflowBlock
+	client, server := connPair(b, config)
+	defer client.disconnect()
+	defer server.disconnect()
+	received := make(chan struct{}, 1024)
+	server.onReceive(func(msg data.Message) {
+		received <- struct{}{}
+	})
+	msg := data.Message{
+		Type: data.SHUFFLE,
+		Payload: data.Shuffle{
+			NodeID:        "node",
+			ListenAddress: "127.0.0.1:8000",
+			Nodes:         []data.Node{{ID: "a", ListenAddress: "127.0.0.1:8001"}},
+			TTL:           3,
+		},
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	go func() {
+		for i := 0; i < b.N; i++ {
+			if err := client.Send(msg); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < b.N; i++ {
+		<-received
+	}
+}
+
+func BenchmarkTCPConnSend(b *testing.B) {
+	benchmarkSend(b, DefaultConnConfig())
+}
+
+func BenchmarkTCPConnSendBatched(b *testing.B) {
+	for _, batchSize := range []int{8, 32, 128} {
+		b.Run(fmt.Sprintf("size=%d", batchSize), func(b *testing.B) {
+			config := DefaultConnConfig()
+			config.BatchSize = batchSize
+			config.BatchLatency = 100 * time.Microsecond
+			benchmarkSend(b, config)
+		})
+	}
+}
+
+// countingConn counts the writes to the conn, the writer goroutine writes every frame at once.
+type countingConn struct {
+	net.Conn
+	writes *atomic.Int32
+}
+
+func (c countingConn) Write(b []byte) (int, error) {
+	c.writes.Add(1)
+	return c.Conn.Write(b)
+}
+
+// countingPair returns a conn sending over TCP with the compression set on both ends,
+// the number of frames it writes and the msgs the other end receives.
+func countingPair(t *testing.T, config ConnConfig, compression Compression) (Conn, *atomic.Int32, chan data.Message) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	dialed, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writes := &atomic.Int32{}
+	client, err := MakeTCPConn(countingConn{Conn: dialed, writes: writes}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := MakeTCPConn(accepted, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = client.disconnect()
+		_ = server.disconnect()
+	})
+	client.setCompression(compression)
+	server.setCompression(compression)
+	received := make(chan data.Message, 64)
+	server.onReceive(func(msg data.Message) { received <- msg })
+	server.onMalformedMsg(func(err error) { t.Errorf("malformed msg received: %v", err) })
+	return client, writes, received
+}
+
+// numberedShuffle returns the i-th test msg, every other one carries enough nodes
+// to exceed the compression threshold.
+func numberedShuffle(i int) data.Message {
+	nodes := make([]data.Node, 1)
+	if i%2 == 1 {
+		nodes = make([]data.Node, 100)
+	}
+	for j := range nodes {
+		nodes[j] = data.Node{ID: fmt.Sprintf("node-%d", j), ListenAddress: fmt.Sprintf("10.0.0.%d:7000", j)}
+	}
+	return data.Message{
+		Type:    data.SHUFFLE,
+		Payload: data.Shuffle{NodeID: fmt.Sprintf("msg-%d", i), ListenAddress: "10.0.0.1:7000", Nodes: nodes, TTL: 3},
+	}
+}
+
+func expectNumbered(t *testing.T, received chan data.Message, count int) {
+	t.Helper()
+	for i := 0; i < count; i++ {
+		select {
+		case msg := <-received:
+			shuffle, ok := msg.Payload.(data.Shuffle)
+			want := numberedShuffle(i).Payload.(data.Shuffle)
+			if !ok || shuffle.NodeID != want.NodeID || len(shuffle.Nodes) != len(want.Nodes) {
+				t.Fatalf("msg %d: received %+v, want %s with %d nodes", i, msg.Payload, want.NodeID, len(want.Nodes))
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("msg %d of %d not received", i, count)
+		}
+	}
+}
+
+func TestTCPConnBatchDeliversInOrder(t *testing.T) {
+	config := DefaultConnConfig()
+	config.BatchSize = 8
+	config.BatchLatency = 200 * time.Millisecond
+	client, writes, received := countingPair(t, config, CompressionNone)
+	const count = 5
+	for i := 0; i < count; i++ {
+		if err := client.Send(numberedShuffle(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectNumbered(t, received, count)
+	if n := writes.Load(); n != 1 {
+		t.Errorf("%d msgs sent in %d frames, want a single batch", count, n)
+	}
+}
+
+func TestTCPConnRoundTrip(t *testing.T) {
+	for _, batchSize := range []int{1, 8} {
+		for _, compression := range []Compression{CompressionNone, CompressionGzip, CompressionSnappy, CompressionZstd} {
+			t.Run(fmt.Sprintf("batch=%d/%s", batchSize, compression), func(t *testing.T) {
+				config := DefaultConnConfig()
+				config.BatchSize = batchSize
+				config.BatchLatency = 10 * time.Millisecond
+				config.Compression = []Compression{compression}
+				client, _, received := countingPair(t, config, compression)
+				const count = 10
+				for i := 0; i < count; i++ {
+					if err := client.Send(numberedShuffle(i)); err != nil {
+						t.Fatal(err)
+					}
+				}
+				expectNumbered(t, received, count)
+			})
+		}
+	}
+}
+
+func TestTCPConnClosesOnOversizedFrame(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	dialed, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialed.Close()
+	accepted, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := MakeTCPConn(accepted, DefaultConnConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	malformed := make(chan error, 1)
+	server.onMalformedMsg(func(err error) { malformed <- err })
+	disconnected := make(chan struct{})
+	server.onDisconnect(func() { close(disconnected) })
+	header := make([]byte, 4)
+	binary.LittleEndian.PutUint32(header, math.MaxUint32)
+	if _, err := dialed.Write(header); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-disconnected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("conn not closed after an oversized frame header")
+	}
+	select {
+	case err := <-malformed:
+		if !errors.Is(err, ErrFrameTooLarge) {
+			t.Errorf("oversized frame reported as %v", err)
+		}
+	default:
+		t.Error("oversized frame not reported as malformed")
+	}
+}
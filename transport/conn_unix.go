@@ -0,0 +1,36 @@
+package transport
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+)
+
+// NewUnixConnFn dials the nodes listening on Unix domain sockets,
+// the listen addresses of such nodes are the socket paths.
+func NewUnixConnFn(config ConnConfig) func(address string) (Conn, error) {
+	return func(address string) (Conn, error) {
+		conn, err := net.DialTimeout("unix", address, handshakeTimeout)
+		if err != nil {
+			return nil, err
+		}
+		return MakeTCPConn(conn, config)
+	}
+}
+
+// AcceptUnixConnsFn listens on the Unix domain socket at the path,
+// a socket left behind by a previous run is removed.
+func AcceptUnixConnsFn(path string, config ConnConfig) func(stopCh chan struct{}, handler func(conn Conn)) error {
+	accept := acceptConnsFn("unix", path, config)
+	return func(stopCh chan struct{}, handler func(conn Conn)) error {
+		if info, err := os.Lstat(path); err == nil && info.Mode()&fs.ModeSocket != 0 {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return accept(stopCh, handler)
+	}
+}
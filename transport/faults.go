@@ -0,0 +1,299 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tamararankovic/hyparview/data"
+)
+
+var ErrConnectRefused = errors.New("connect refused by fault injection")
+
+// FaultRule describes the failures injected into the conns it matches.
+type FaultRule struct {
+	// Peers limits the rule to the conns whose address, remote node ID
+	// or remote listen address is listed, the rule matches all conns if empty
+	Peers []string
+	// MsgTypes limits the rule to the msgs of the types, all msgs if empty
+	MsgTypes []data.MessageType
+	// Incoming applies the rule to the received msgs instead of the sent ones,
+	// the corruption and the half-closing only apply to the sent msgs
+	Incoming bool
+	// Drop, Duplicate and Corrupt are the probabilities of dropping,
+	// sending twice and flipping a payload byte of a matched msg
+	Drop,
+	Duplicate,
+	Corrupt float64
+	// Delay postpones the matched msgs by Delay plus a random part of Jitter,
+	// the delayed msgs may get reordered
+	Delay,
+	Jitter time.Duration
+	// HalfClose shuts down the writing side of the conn on the first matched msg
+	HalfClose bool
+	// RefuseConnect fails the dials of the listed peer addresses
+	RefuseConnect bool
+}
+
+func (r FaultRule) Validate() error {
+	var errs []error
+	probabilities := []struct {
+		name  string
+		value float64
+	}{{"Drop", r.Drop}, {"Duplicate", r.Duplicate}, {"Corrupt", r.Corrupt}}
+	for _, p := range probabilities {
+		if p.value < 0 || p.value > 1 {
+			errs = append(errs, fmt.Errorf("%s must be a probability between 0 and 1, got %f", p.name, p.value))
+		}
+	}
+	if r.Delay < 0 || r.Jitter < 0 {
+		errs = append(errs, errors.New("Delay and Jitter must not be negative"))
+	}
+	if r.RefuseConnect && len(r.Peers) == 0 {
+		errs = append(errs, errors.New("RefuseConnect needs the Peers to refuse"))
+	}
+	return errors.Join(errs...)
+}
+
+func (r FaultRule) matches(conn Conn, msgType data.MessageType, incoming bool) bool {
+	if r.Incoming != incoming || r.RefuseConnect {
+		return false
+	}
+	if len(r.MsgTypes) > 0 && !slices.Contains(r.MsgTypes, msgType) {
+		return false
+	}
+	if len(r.Peers) == 0 {
+		return true
+	}
+	remote := conn.GetRemoteNode()
+	return slices.ContainsFunc(r.Peers, func(peer string) bool {
+		return peer == conn.GetAddress() || (peer != "" && (peer == remote.ID || peer == remote.ListenAddress))
+	})
+}
+
+// FaultInjector decorates the conn fns passed to NewConnManager
+// and injects the failures described by its rules into the conns.
+// The rules can be replaced at any time, the first rule matching
+// a msg decides what happens to it.
+type FaultInjector struct {
+	rules []FaultRule
+	// chance returns a random number in [0, 1)
+	chance func() float64
+	lock   sync.Mutex
+}
+
+func NewFaultInjector() *FaultInjector {
+	return &FaultInjector{
+		rules:  make([]FaultRule, 0),
+		chance: rand.Float64,
+	}
+}
+
+// SetRules replaces the rules, none of them is applied if any is invalid.
+func (f *FaultInjector) SetRules(rules ...FaultRule) error {
+	for i, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("rule %d: %w", i, err)
+		}
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.rules = slices.Clone(rules)
+	return nil
+}
+
+func (f *FaultInjector) Rules() []FaultRule {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return slices.Clone(f.rules)
+}
+
+// WrapNewConnFn returns the dial fn refusing the addresses of the RefuseConnect rules
+// and injecting the failures into the dialed conns.
+func (f *FaultInjector) WrapNewConnFn(newConnFn func(address string) (Conn, error)) func(address string) (Conn, error) {
+	return func(address string) (Conn, error) {
+		if f.refuses(address) {
+			return nil, fmt.Errorf("dial %s: %w", address, ErrConnectRefused)
+		}
+		conn, err := newConnFn(address)
+		if err != nil {
+			return nil, err
+		}
+		return &faultyConn{Conn: conn, injector: f}, nil
+	}
+}
+
+// WrapAcceptConnsFn returns the accept fn injecting the failures into the accepted conns.
+func (f *FaultInjector) WrapAcceptConnsFn(acceptConnsFn func(stopCh chan struct{}, handler func(conn Conn)) error) func(stopCh chan struct{}, handler func(conn Conn)) error {
+	return func(stopCh chan struct{}, handler func(conn Conn)) error {
+		return acceptConnsFn(stopCh, func(conn Conn) {
+			handler(&faultyConn{Conn: conn, injector: f})
+		})
+	}
+}
+
+func (f *FaultInjector) refuses(address string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return slices.ContainsFunc(f.rules, func(rule FaultRule) bool {
+		return rule.RefuseConnect && slices.Contains(rule.Peers, address)
+	})
+}
+
+func (f *FaultInjector) match(conn Conn, msgType data.MessageType, incoming bool) (FaultRule, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, rule := range f.rules {
+		if rule.matches(conn, msgType, incoming) {
+			return rule, true
+		}
+	}
+	return FaultRule{}, false
+}
+
+func (f *FaultInjector) happens(probability float64) bool {
+	return probability > 0 && f.chance() < probability
+}
+
+func (f *FaultInjector) delay(rule FaultRule) time.Duration {
+	delay := rule.Delay
+	if rule.Jitter > 0 {
+		delay += time.Duration(f.chance() * float64(rule.Jitter))
+	}
+	return delay
+}
+
+// faultyConn applies the rules of the injector to the msgs of the wrapped conn.
+type faultyConn struct {
+	Conn
+	injector   *FaultInjector
+	halfClosed atomic.Bool
+}
+
+func (c *faultyConn) overflowPolicy() OverflowPolicy {
+	sender, ok := c.Conn.(interface{ overflowPolicy() OverflowPolicy })
+	if !ok {
+		return OverflowDrop
+	}
+	return sender.overflowPolicy()
+}
+
+func (c *faultyConn) isClosed() bool {
+	return isClosed(c.Conn)
+}
+
+func (c *faultyConn) receivedAll() <-chan struct{} {
+	return receivedAll(c.Conn)
+}
+
+func (c *faultyConn) Send(msg data.Message) error {
+	rule, ok := c.injector.match(c.Conn, msg.Type, false)
+	if !ok {
+		return c.Conn.Send(msg)
+	}
+	if c.halfClosed.Load() {
+		return nil
+	}
+	if rule.HalfClose {
+		c.halfClose()
+		return nil
+	}
+	if c.injector.happens(rule.Drop) {
+		return nil
+	}
+	send := func() error { return c.Conn.Send(msg) }
+	if c.injector.happens(rule.Corrupt) {
+		send = func() error { return c.sendCorrupted(msg) }
+	}
+	times := 1
+	if c.injector.happens(rule.Duplicate) {
+		times = 2
+	}
+	delay := c.injector.delay(rule)
+	if delay > 0 {
+		time.AfterFunc(delay, func() {
+			for i := 0; i < times; i++ {
+				if err := send(); err != nil {
+					log.Printf("delayed msg %s to %s not sent: %v\n", msg.Type, c.GetAddress(), err)
+				}
+			}
+		})
+		return nil
+	}
+	for i := 0; i < times; i++ {
+		if err := send(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *faultyConn) onReceive(handler func(msg data.Message)) {
+	c.Conn.onReceive(func(msg data.Message) {
+		rule, ok := c.injector.match(c.Conn, msg.Type, true)
+		if !ok {
+			handler(msg)
+			return
+		}
+		if c.injector.happens(rule.Drop) {
+			return
+		}
+		times := 1
+		if c.injector.happens(rule.Duplicate) {
+			times = 2
+		}
+		if delay := c.injector.delay(rule); delay > 0 {
+			time.AfterFunc(delay, func() {
+				for i := 0; i < times; i++ {
+					handler(msg)
+				}
+			})
+			return
+		}
+		for i := 0; i < times; i++ {
+			handler(msg)
+		}
+	})
+}
+
+// sendCorrupted flips a random bit of a random payload byte,
+// the msg type byte is left intact.
+func (c *faultyConn) sendCorrupted(msg data.Message) error {
+	payload, err := serialize(msg)
+	if err != nil {
+		return err
+	}
+	if len(payload) > 1 {
+		i := 1 + int(c.injector.chance()*float64(len(payload)-1))
+		payload[i] ^= 1 << uint(c.injector.chance()*8)
+	}
+	raw, ok := c.Conn.(interface{ sendSerialized(payload []byte) error })
+	if !ok {
+		return fmt.Errorf("%T does not support corrupting msgs", c.Conn)
+	}
+	return raw.sendSerialized(payload)
+}
+
+// halfClose shuts down the writing side of the conn,
+// the conns that can not be half-closed are closed.
+func (c *faultyConn) halfClose() {
+	if c.halfClosed.Swap(true) {
+		return
+	}
+	halfCloser, ok := c.Conn.(interface{ halfClose() error })
+	if ok {
+		err := halfCloser.halfClose()
+		if err == nil {
+			return
+		}
+		log.Println(err)
+	}
+	if err := c.Conn.disconnect(); err != nil {
+		log.Println(err)
+	}
+}
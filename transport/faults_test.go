@@ -0,0 +1,134 @@
+package transport
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tamararankovic/hyparview/data"
+)
+
+// faultyPair dials b from a over the in-memory network with the faults injected
+// on the dialing side and returns the dialed conn and the msgs b receives.
+func faultyPair(t *testing.T, injector *FaultInjector) (Conn, chan data.Message, chan error) {
+	t.Helper()
+	network := NewMemNetwork(DefaultConnConfig())
+	received := make(chan data.Message, 16)
+	malformed := make(chan error, 16)
+	accepted := make(chan struct{})
+	err := network.AcceptConnsFn("b")(make(chan struct{}), func(conn Conn) {
+		conn.onMalformedMsg(func(err error) { malformed <- err })
+		conn.onReceive(func(msg data.Message) { received <- msg })
+		close(accepted)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := injector.WrapNewConnFn(network.NewConnFn("a"))("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-accepted
+	return conn, received, malformed
+}
+
+func expectMsgs(t *testing.T, received chan data.Message, want ...data.MessageType) {
+	t.Helper()
+	for _, msgType := range want {
+		select {
+		case msg := <-received:
+			if msg.Type != msgType {
+				t.Fatalf("received %s, want %s", msg.Type, msgType)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s not received", msgType)
+		}
+	}
+	select {
+	case msg := <-received:
+		t.Fatalf("unexpected msg %s received", msg.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestFaultInjectorDropAndDuplicate(t *testing.T) {
+	injector := NewFaultInjector()
+	conn, received, _ := faultyPair(t, injector)
+	err := injector.SetRules(
+		FaultRule{MsgTypes: []data.MessageType{data.SHUFFLE}, Drop: 1},
+		FaultRule{MsgTypes: []data.MessageType{data.DISCONNECT}, Duplicate: 1},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []data.Message{
+		{Type: data.SHUFFLE, Payload: data.Shuffle{NodeID: "a"}},
+		{Type: data.JOIN, Payload: data.Join{NodeID: "a"}},
+		{Type: data.DISCONNECT, Payload: data.Disconnect{NodeID: "a"}},
+	} {
+		if err := conn.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectMsgs(t, received, data.JOIN, data.DISCONNECT, data.DISCONNECT)
+
+	// the rules are replaced at runtime
+	if err := injector.SetRules(); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Send(data.Message{Type: data.SHUFFLE, Payload: data.Shuffle{NodeID: "a"}}); err != nil {
+		t.Fatal(err)
+	}
+	expectMsgs(t, received, data.SHUFFLE)
+}
+
+func TestFaultInjectorDelay(t *testing.T) {
+	injector := NewFaultInjector()
+	conn, received, _ := faultyPair(t, injector)
+	if err := injector.SetRules(FaultRule{Delay: 100 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	sent := time.Now()
+	if err := conn.Send(data.Message{Type: data.JOIN, Payload: data.Join{NodeID: "a"}}); err != nil {
+		t.Fatal(err)
+	}
+	expectMsgs(t, received, data.JOIN)
+	if elapsed := time.Since(sent); elapsed < 100*time.Millisecond {
+		t.Errorf("msg delivered after %s, want at least 100ms", elapsed)
+	}
+}
+
+func TestFaultInjectorCorrupt(t *testing.T) {
+	injector := NewFaultInjector()
+	conn, received, malformed := faultyPair(t, injector)
+	if err := injector.SetRules(FaultRule{Corrupt: 1}); err != nil {
+		t.Fatal(err)
+	}
+	// flip the lowest bit of the first payload byte, the opening brace of the JSON
+	injector.chance = func() float64 { return 0 }
+	if err := conn.Send(data.Message{Type: data.JOIN, Payload: data.Join{NodeID: "a"}}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-malformed:
+	case msg := <-received:
+		t.Fatalf("corrupted msg %v decoded", msg)
+	case <-time.After(time.Second):
+		t.Fatal("corrupted msg not reported as malformed")
+	}
+}
+
+func TestFaultInjectorRefuseConnect(t *testing.T) {
+	injector := NewFaultInjector()
+	if err := injector.SetRules(FaultRule{Peers: []string{"b"}, RefuseConnect: true}); err != nil {
+		t.Fatal(err)
+	}
+	network := NewMemNetwork(DefaultConnConfig())
+	dial := injector.WrapNewConnFn(network.NewConnFn("a"))
+	if _, err := dial("b"); !errors.Is(err, ErrConnectRefused) {
+		t.Errorf("dial error %v, want %v", err, ErrConnectRefused)
+	}
+	if err := injector.SetRules(FaultRule{Drop: 2}); err == nil {
+		t.Error("expected the invalid drop probability to be rejected")
+	}
+}
@@ -1,9 +1,11 @@
 package transport
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"reflect"
 
 	"github.com/tamararankovic/hyparview/data"
 )
@@ -23,17 +25,61 @@ func deserialize(msgSerialized []byte) (data.Message, error) {
 		return data.Message{}, errors.New("message empty")
 	}
 	msgType := data.MessageType(msgSerialized[0])
-	payload := payloadByType[msgType]
-	if payload == nil {
+	payloadType := payloadByType[msgType]
+	if payloadType == nil {
 		return data.Message{}, fmt.Errorf("payload struct not found for msg type %v", msgType)
 	}
-	err := json.Unmarshal(msgSerialized[1:], &payload)
+	payload := reflect.New(reflect.TypeOf(payloadType))
+	err := json.Unmarshal(msgSerialized[1:], payload.Interface())
 	return data.Message{
 		Type:    msgType,
-		Payload: payload,
+		Payload: payload.Elem().Interface(),
 	}, err
 }
 
+// serializeBatch builds a batch msg out of length-prefixed serialized msgs.
+func serializeBatch(msgsSerialized [][]byte) []byte {
+	size := 1
+	for _, msgSerialized := range msgsSerialized {
+		size += len(msgSerialized)
+	}
+	batch := make([]byte, 0, size)
+	batch = append(batch, byte(data.BATCH))
+	for _, msgSerialized := range msgsSerialized {
+		batch = append(batch, msgSerialized...)
+	}
+	return batch
+}
+
+func deserializeBatch(batch []byte) ([]data.Message, error) {
+	if len(batch) == 0 || data.MessageType(batch[0]) != data.BATCH {
+		return nil, errors.New("not a batch message")
+	}
+	msgs := make([]data.Message, 0)
+	rest := batch[1:]
+	for len(rest) > 0 {
+		if len(rest) < 4 {
+			return msgs, errors.New("batch message truncated")
+		}
+		msgSize := binary.LittleEndian.Uint32(rest[:4])
+		rest = rest[4:]
+		if uint32(len(rest)) < msgSize {
+			return msgs, errors.New("batch message truncated")
+		}
+		msg, err := deserialize(rest[:msgSize])
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, msg)
+		rest = rest[msgSize:]
+	}
+	return msgs, nil
+}
+
+func isBatch(msgSerialized []byte) bool {
+	return len(msgSerialized) > 0 && data.MessageType(msgSerialized[0]) == data.BATCH
+}
+
 var payloadByType map[data.MessageType]any = map[data.MessageType]any{
 	data.JOIN:            data.Join{},
 	data.FORWARD_JOIN:    data.ForwardJoin{},
@@ -42,4 +88,9 @@ var payloadByType map[data.MessageType]any = map[data.MessageType]any{
 	data.NEIGHTBOR_REPLY: data.NeighborReply{},
 	data.SHUFFLE:         data.Shuffle{},
 	data.SHUFFLE_REPLY:   data.ShuffleReply{},
+	data.REJECT:          data.Reject{},
+	data.HANDSHAKE:       data.Handshake{},
+	data.HANDSHAKE_REPLY: data.HandshakeReply{},
+	data.HANDSHAKE_PROOF: data.HandshakeProof{},
+	data.JOIN_REPLY:      data.JoinReply{},
 }
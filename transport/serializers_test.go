@@ -0,0 +1,129 @@
+package transport
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/tamararankovic/hyparview/data"
+)
+
+func addSerializedSeeds(f *testing.F) {
+	node := data.Node{ID: "node-1", ListenAddress: "127.0.0.1:7001"}
+	msgs := []data.Message{
+		{Type: data.JOIN, Payload: data.Join{NodeID: node.ID, ListenAddress: node.ListenAddress, Credentials: []byte("token")}},
+		{Type: data.JOIN_REPLY, Payload: data.JoinReply{NodeID: node.ID, ListenAddress: node.ListenAddress, MetadataVersion: 1}},
+		{Type: data.FORWARD_JOIN, Payload: data.ForwardJoin{NodeID: node.ID, ListenAddress: node.ListenAddress, TTL: 6}},
+		{Type: data.DISCONNECT, Payload: data.Disconnect{NodeID: node.ID, Signature: []byte{1, 2, 3}}},
+		{Type: data.NEIGHTBOR, Payload: data.Neighbor{NodeID: node.ID, ListenAddress: node.ListenAddress, HighPriority: true}},
+		{Type: data.NEIGHTBOR_REPLY, Payload: data.NeighborReply{NodeID: node.ID, Accepted: true}},
+		{Type: data.SHUFFLE, Payload: data.Shuffle{NodeID: node.ID, ListenAddress: node.ListenAddress, Nodes: []data.Node{node, node}, TTL: 3}},
+		{Type: data.SHUFFLE_REPLY, Payload: data.ShuffleReply{NodeID: node.ID, ReceivedNodes: []data.Node{node}, Nodes: []data.Node{}}},
+		{Type: data.REJECT, Payload: data.Reject{NodeID: node.ID, MsgType: data.JOIN, Reason: "banned"}},
+		{Type: data.HANDSHAKE, Payload: data.Handshake{NodeID: node.ID, ListenAddress: node.ListenAddress, Version: ProtocolVersion, Compression: []string{"zstd"}}},
+		{Type: data.HANDSHAKE_REPLY, Payload: data.HandshakeReply{NodeID: node.ID, Accepted: true, Compression: "zstd"}},
+		{Type: data.HANDSHAKE_PROOF, Payload: data.HandshakeProof{Signature: []byte{1, 2, 3}}},
+	}
+	msgsSerialized := make([][]byte, 0, len(msgs))
+	for _, msg := range msgs {
+		msgSerialized, err := serialize(msg)
+		if err != nil {
+			f.Fatal(err)
+		}
+		msgsSerialized = append(msgsSerialized, msgSerialized)
+		f.Add(msgSerialized)
+	}
+	f.Add(serializeBatch([][]byte{frame(msgsSerialized[0]), frame(msgsSerialized[6])}))
+	f.Add([]byte{})
+	f.Add([]byte{byte(data.SHUFFLE), 'n', 'u', 'l', 'l'})
+	f.Add([]byte{byte(data.BATCH), 0xff, 0xff, 0xff, 0xff})
+}
+
+// FuzzDeserialize checks that no input makes deserialize panic
+// and that every decoded msg survives a serialization round trip.
+func FuzzDeserialize(f *testing.F) {
+	addSerializedSeeds(f)
+	f.Fuzz(func(t *testing.T, msgSerialized []byte) {
+		msg, err := deserialize(msgSerialized)
+		if err != nil {
+			return
+		}
+		reserialized, err := serialize(msg)
+		if err != nil {
+			t.Fatalf("decoded msg %+v not serializable: %v", msg, err)
+		}
+		decoded, err := deserialize(reserialized)
+		if err != nil {
+			t.Fatalf("reserialized msg %q not decodable: %v", reserialized, err)
+		}
+		if !reflect.DeepEqual(msg, decoded) {
+			t.Errorf("round trip changed the msg from %+v to %+v", msg, decoded)
+		}
+	})
+}
+
+func FuzzDeserializeBatch(f *testing.F) {
+	addSerializedSeeds(f)
+	f.Fuzz(func(t *testing.T, batch []byte) {
+		msgs, err := deserializeBatch(batch)
+		if err == nil && len(batch) > 1 && len(msgs) == 0 {
+			t.Errorf("batch of %d bytes decoded into no msgs", len(batch))
+		}
+	})
+}
+
+func smallShuffle() data.Message {
+	nodes := make([]data.Node, 7)
+	for i := range nodes {
+		nodes[i] = data.Node{ID: fmt.Sprintf("node-%d", i), ListenAddress: fmt.Sprintf("10.0.0.%d:7000", i)}
+	}
+	return data.Message{
+		Type:    data.SHUFFLE,
+		Payload: data.Shuffle{NodeID: "node", ListenAddress: "10.0.0.1:7000", Nodes: nodes, TTL: 3},
+	}
+}
+
+func BenchmarkSerialize(b *testing.B) {
+	large, err := deserialize(largeShuffle(b))
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarks := []struct {
+		name string
+		msg  data.Message
+	}{{"shuffle=7", smallShuffle()}, {"shuffle=200", large}}
+	for _, bm := range benchmarks {
+		msg := bm.msg
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := serialize(msg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDeserialize(b *testing.B) {
+	small, err := serialize(smallShuffle())
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarks := []struct {
+		name          string
+		msgSerialized []byte
+	}{{"shuffle=7", small}, {"shuffle=200", largeShuffle(b)}}
+	for _, bm := range benchmarks {
+		msgSerialized := bm.msgSerialized
+		b.Run(bm.name, func(b *testing.B) {
+			b.SetBytes(int64(len(msgSerialized)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := deserialize(msgSerialized); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,5 +1,7 @@
 package transport
 
+import "sync"
+
 type Subscription struct {
 	unsub chan struct{}
 }
@@ -22,3 +24,50 @@ func Subscribe[T any](ch chan T, handler func(peer T)) Subscription {
 	}()
 	return Subscription{unsub: unsub}
 }
+
+// Broadcast delivers every published value to all of its subscribers.
+// Publishing never blocks on a subscriber that has already unsubscribed.
+type Broadcast[T any] struct {
+	subs map[chan T]chan struct{}
+	lock sync.Mutex
+}
+
+func NewBroadcast[T any]() *Broadcast[T] {
+	return &Broadcast[T]{
+		subs: make(map[chan T]chan struct{}),
+	}
+}
+
+func (b *Broadcast[T]) Subscribe(handler func(value T)) Subscription {
+	ch := make(chan T)
+	done := make(chan struct{})
+	b.lock.Lock()
+	b.subs[ch] = done
+	b.lock.Unlock()
+	sub := Subscribe(ch, handler)
+	unsub := make(chan struct{})
+	go func() {
+		<-unsub
+		b.lock.Lock()
+		delete(b.subs, ch)
+		b.lock.Unlock()
+		close(done)
+		sub.Unsubscribe()
+	}()
+	return Subscription{unsub: unsub}
+}
+
+func (b *Broadcast[T]) Publish(value T) {
+	b.lock.Lock()
+	subs := make(map[chan T]chan struct{}, len(b.subs))
+	for ch, done := range b.subs {
+		subs[ch] = done
+	}
+	b.lock.Unlock()
+	for ch, done := range subs {
+		select {
+		case ch <- value:
+		case <-done:
+		}
+	}
+}